@@ -1,6 +1,7 @@
 #!/bin/bash
 
 set -ex
+set -o pipefail
 
 LSB_FILE="/etc/lsb-release.host"
 OS_RELEASE_FILE="/etc/os-release.host"
@@ -9,6 +10,38 @@ HOST_MODULES_DIR="/lib/modules.host"
 
 KERNEL_VERSION="${KERNEL_VERSION:-$(uname -r)}"
 
+# Where to get the kernel sources from instead of upstream, for clusters without internet access.
+# HEADERS_MIRROR_URL is a url template, HEADERS_SOURCE_DIR a directory with tarballs or prepared headers.
+HEADERS_MIRROR_URL="${HEADERS_MIRROR_URL:-}"
+HEADERS_SOURCE_DIR="${HEADERS_SOURCE_DIR:-}"
+
+# expand_mirror_url version major
+expand_mirror_url()
+{
+  url="${HEADERS_MIRROR_URL//\{release\}/${KERNEL_VERSION}}"
+  url="${url//\{version\}/$1}"
+  url="${url//\{major\}/$2}"
+  url="${url//\{build_id\}/${BUILD_ID}}"
+  echo "${url}"
+}
+
+# extract_source_tarball strip-components tarball...
+# Extracts the first of the tarballs found in the headers source directory.
+extract_source_tarball()
+{
+  strip="$1"
+  shift
+  for tarball in "$@"; do
+    if [[ -e "${HEADERS_SOURCE_DIR}/${tarball}" ]]; then
+      echo "Extracting kernel sources from ${tarball}."
+      tar --strip-components="${strip}" -xzf "${HEADERS_SOURCE_DIR}/${tarball}" -C "${BUILD_DIR}"
+      return 0
+    fi
+  done
+  echo "No kernel sources for ${KERNEL_VERSION} found in ${HEADERS_SOURCE_DIR}."
+  return 1
+}
+
 generate_headers()
 {
   echo "Generating kernel headers"
@@ -28,9 +61,18 @@ generate_headers()
 
 fetch_cos_linux_sources()
 {
-  echo "Fetching upstream kernel sources."
   mkdir -p "${BUILD_DIR}"
-  curl -s "https://storage.googleapis.com/cos-tools/${BUILD_ID}/kernel-src.tar.gz" \
+  if [[ -n "${HEADERS_SOURCE_DIR}" ]]; then
+    extract_source_tarball 0 "cos-${BUILD_ID}-kernel-src.tar.gz"
+    return
+  fi
+
+  url="https://storage.googleapis.com/cos-tools/${BUILD_ID}/kernel-src.tar.gz"
+  if [[ -n "${HEADERS_MIRROR_URL}" ]]; then
+    url="$(expand_mirror_url "" "")"
+  fi
+  echo "Fetching kernel sources from ${url}."
+  curl -sf "${url}" \
     | tar -xzf - -C "${BUILD_DIR}"
 }
 
@@ -47,12 +89,37 @@ fetch_generic_linux_sources()
     kernel_version=$(echo $kernel_version | rev | sed s/0\.// | rev)
   fi
 
-  echo "Fetching upstream kernel sources for ${kernel_version}."
   mkdir -p "${BUILD_DIR}"
-  curl -sL "https://www.kernel.org/pub/linux/kernel/v${major_version}.x/linux-$kernel_version.tar.gz" \
+  if [[ -n "${HEADERS_SOURCE_DIR}" ]]; then
+    extract_source_tarball 1 "linux-${KERNEL_VERSION}.tar.gz" "linux-${kernel_version}.tar.gz"
+    return
+  fi
+
+  url="https://www.kernel.org/pub/linux/kernel/v${major_version}.x/linux-$kernel_version.tar.gz"
+  if [[ -n "${HEADERS_MIRROR_URL}" ]]; then
+    url="$(expand_mirror_url "${kernel_version}" "${major_version}")"
+  fi
+  echo "Fetching kernel sources for ${kernel_version} from ${url}."
+  curl -sfL "${url}" \
     | tar --strip-components=1 -xzf - -C "${BUILD_DIR}"
 }
 
+install_prebuilt_linux_headers()
+{
+  prebuilt_dir="${HEADERS_SOURCE_DIR}/${KERNEL_VERSION}"
+  [[ -z "${HEADERS_SOURCE_DIR}" ]] && return 1
+  [[ ! -e "${prebuilt_dir}/include/generated" ]] && return 1
+
+  SOURCES_DIR="${TARGET_DIR}/linux-prebuilt-${KERNEL_VERSION}"
+  if [[ ! -e "${SOURCES_DIR}/.installed" ]]; then
+    echo "Installing prepared kernel headers for ${KERNEL_VERSION}"
+    rm -rf "${SOURCES_DIR}"
+    mkdir -p "${SOURCES_DIR}"
+    time cp -R "${prebuilt_dir}/." "${SOURCES_DIR}"
+    touch "${SOURCES_DIR}/.installed"
+  fi
+}
+
 install_cos_linux_headers()
 {
   if grep -q CHROMEOS_RELEASE_VERSION "${LSB_FILE}" >/dev/null; then
@@ -88,6 +155,11 @@ install_generic_linux_headers()
 
 install_headers()
 {
+  if install_prebuilt_linux_headers; then
+    HEADERS_TARGET="${SOURCES_DIR}"
+    return
+  fi
+
   distro="$(awk '/^NAME *= */ { gsub(/^NAME *= */, ""); print }' "${OS_RELEASE_FILE}")"
 
   case $distro in
@@ -123,7 +195,8 @@ check_headers()
   return 0
 }
 
-if [[ ! -e /lib/modules/.installed ]]; then
+# The marker is shared by all the kernel versions, check the links too in case the node kernel was upgraded
+if [[ ! -e /lib/modules/.installed ]] || [[ ! -e "/lib/modules/${KERNEL_VERSION}/build" ]]; then
   if check_headers "${HOST_MODULES_DIR}"; then
     HEADERS_TARGET="${HOST_MODULES_DIR}/source"
   else
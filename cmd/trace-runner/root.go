@@ -13,6 +13,6 @@ func main() {
 
 	root := cmd.NewTraceRunnerCommand()
 	if err := root.Execute(); err != nil {
-		os.Exit(1)
+		os.Exit(cmd.ExitCode(err))
 	}
 }
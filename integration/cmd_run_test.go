@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	batchv1 "k8s.io/api/batch/v1"
 )
@@ -13,7 +14,7 @@ type outputAsserter func(string, []byte)
 
 func (k *KubectlTraceSuite) TestRunNode() {
 	nodeName := k.GetTestNode()
-	bpftraceProgram := `kprobe:do_sys_open { printf("%s: %s\n", comm, str(arg1)) }'`
+	bpftraceProgram := `kprobe:do_sys_open { printf("%s: %s\n", comm, str(arg1)) }`
 	out := k.KubectlTraceCmd("run", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "-e", bpftraceProgram, nodeName)
 	assert.Regexp(k.T(), "trace (\\w+-){4}\\w+ created", out)
 }
@@ -21,7 +22,7 @@ func (k *KubectlTraceSuite) TestRunNode() {
 func (k *KubectlTraceSuite) TestReturnErrOnErr() {
 	nodeName := k.GetTestNode()
 
-	bpftraceProgram := `kprobe:not_a_real_kprobe { printf("%s: %s\n", comm, str(arg1)) }'`
+	bpftraceProgram := `kprobe:not_a_real_kprobe { printf("%s: %s\n", comm, str(arg1)) }`
 	out := k.KubectlTraceCmd("run", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "-e", bpftraceProgram, nodeName)
 	assert.Regexp(k.T(), regexp.MustCompile("trace [a-f0-9-]{36} created"), out)
 
@@ -44,3 +45,62 @@ func (k *KubectlTraceSuite) TestReturnErrOnErr() {
 	assert.Equal(k.T(), int32(0), job.Status.Succeeded, "No jobs in the batch should have succeeded")
 	assert.Greater(k.T(), job.Status.Failed, int32(1), "There should be at least one failed job")
 }
+
+func (k *KubectlTraceSuite) TestReturnErrOnErrWithWait() {
+	nodeName := k.GetTestNode()
+
+	bpftraceProgram := `kprobe:not_a_real_kprobe { printf("%s: %s\n", comm, str(arg1)) }`
+	out := k.KubectlTraceCmd("run", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "--wait", "-e", bpftraceProgram, nodeName)
+	assert.Regexp(k.T(), regexp.MustCompile("trace [a-f0-9-]{36} created"), out)
+	assert.Regexp(k.T(), regexp.MustCompile("trace [a-f0-9-]{36} failed: CompileError"), out)
+	assert.Contains(k.T(), out, "kprobe:not_a_real_kprobe")
+}
+
+func (k *KubectlTraceSuite) TestProbesNode() {
+	nodeName := k.GetTestNode()
+
+	out := k.KubectlTraceCmd("probes", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), nodeName, "kprobe:do_sys_open*")
+	assert.Contains(k.T(), out, "kprobe:do_sys_open")
+}
+
+func (k *KubectlTraceSuite) TestNodeInfo() {
+	nodeName := k.GetTestNode()
+
+	out := k.KubectlTraceCmd("node-info", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "-o", "json", nodeName)
+	assert.Regexp(k.T(), regexp.MustCompile(`"kernelVersion": "[^"]+"`), out)
+	assert.Regexp(k.T(), regexp.MustCompile(`"bpftraceVersion": "bpftrace v[^"]+"`), out)
+}
+
+func (k *KubectlTraceSuite) TestFetchHeadersFromMirror() {
+	nodeName := k.GetTestNode()
+
+	// The mirror serves nothing, the init container requesting the sources is enough to know the option is used
+	mirror, err := startHeadersMirror(k.T().TempDir())
+	require.Nil(k.T(), err)
+	defer mirror.Close()
+
+	mirrorURL := mirror.URL(k.testBackend.HostAddress()) + "/v{major}.x/linux-{version}.tar.gz"
+	bpftraceProgram := `kprobe:do_sys_open { printf("%s: %s\n", comm, str(arg1)) }`
+	out := k.KubectlTraceCmd("run", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "--init-imagename="+k.InitImage(),
+		"--headers=fetch", "--headers-mirror="+mirrorURL, "--validate=false", "-e", bpftraceProgram, nodeName)
+	assert.Regexp(k.T(), regexp.MustCompile("trace [a-f0-9-]{36} created"), out)
+
+	requested := regexp.MustCompile(`^/v\d+\.x/linux-[0-9.]+\.tar\.gz$`)
+	for i := 0; i < waitForTargetPodSeconds*2; i++ {
+		for _, r := range mirror.Requests() {
+			if requested.MatchString(r) {
+				return
+			}
+		}
+		time.Sleep(1 * time.Second)
+	}
+	assert.Failf(k.T(), "kernel sources not requested from the mirror", "requests: %v", mirror.Requests())
+}
+
+func (k *KubectlTraceSuite) TestRunNodeCapabilities() {
+	nodeName := k.GetTestNode()
+
+	bpftraceProgram := `BEGIN { printf("capabilities\n"); exit(); }`
+	out := k.KubectlTraceCmd("run", "--namespace="+k.namespace(), "--imagename="+k.RunnerImage(), "--security-profile=capabilities", "--wait", "-e", bpftraceProgram, nodeName)
+	assert.Regexp(k.T(), regexp.MustCompile("trace [a-f0-9-]{36} completed"), out)
+}
@@ -0,0 +1,56 @@
+package integration
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// headersMirror stands in for an internal mirror of kernel sources: it serves a local directory
+// over http on all the interfaces of the host, so that the cluster nodes can reach it,
+// and records the paths that were requested.
+type headersMirror struct {
+	listener net.Listener
+	server   *http.Server
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func startHeadersMirror(dir string) (*headersMirror, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return nil, err
+	}
+
+	m := &headersMirror{listener: l}
+	files := http.FileServer(http.Dir(dir))
+	m.server = &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.mu.Lock()
+			m.requests = append(m.requests, r.URL.Path)
+			m.mu.Unlock()
+			files.ServeHTTP(w, r)
+		}),
+	}
+	go m.server.Serve(l)
+
+	return m, nil
+}
+
+// URL returns the base url of the mirror for clients reaching the host at hostAddress.
+func (m *headersMirror) URL(hostAddress string) string {
+	return fmt.Sprintf("http://%s:%d", hostAddress, m.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Requests returns the paths requested so far.
+func (m *headersMirror) Requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string{}, m.requests...)
+}
+
+func (m *headersMirror) Close() error {
+	return m.server.Close()
+}
@@ -3,6 +3,7 @@ package integration
 import (
 	"fmt"
 	"github.com/iovisor/kubectl-trace/pkg/docker"
+	"net"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -133,6 +134,18 @@ func (b *kindBackend) RunnerImage() string {
 	return b.runnerImage
 }
 
+// HostAddress is the gateway of the kind docker network, which is the host as seen from the nodes.
+func (b *kindBackend) HostAddress() string {
+	output := b.suite.runWithoutError("docker", "network", "inspect", "kind", "--format", "{{range .IPAM.Config}}{{if .Gateway}}{{.Gateway}} {{end}}{{end}}")
+	for _, address := range strings.Fields(output) {
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			return address
+		}
+	}
+	assert.Failf(b.suite.T(), "no IPv4 gateway found for the kind network", "output: %s", output)
+	return ""
+}
+
 func (b *kindBackend) RunNodeCommand(command string) error {
 	comm := exec.Command("docker", "exec", "-i", KindClusterName+"-control-plane", "bash", "-c", command)
 	o, err := comm.CombinedOutput()
@@ -108,6 +108,15 @@ func (b *minikubeBackend) RunnerImage() string {
 	return b.runnerImage
 }
 
+// HostAddress is the address minikube gives to host.minikube.internal on the node.
+func (b *minikubeBackend) HostAddress() string {
+	output := b.suite.runWithoutError("minikube", "ssh", "--profile", MinikubeProfileName, "--", "grep", "host.minikube.internal", "/etc/hosts")
+	fields := strings.Fields(output)
+	require.NotEmpty(b.suite.T(), fields, "host.minikube.internal not found on the minikube node")
+	require.NotNil(b.suite.T(), net.ParseIP(fields[0]))
+	return fields[0]
+}
+
 func (b *minikubeBackend) RunNodeCommand(command string) error {
 	comm := exec.Command("docker", "exec", "-i", MinikubeProfileName, "bash", "-c", command)
 	o, err := comm.CombinedOutput()
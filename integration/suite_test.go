@@ -14,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/iovisor/kubectl-trace/pkg/cmd"
 	"github.com/iovisor/kubectl-trace/pkg/docker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -65,6 +66,7 @@ type TestBackend interface {
 	GetBackendNode() string
 	RunnerImage() string
 	RegistryPort() int
+	HostAddress() string
 }
 
 type TestNameSpaceInfo struct {
@@ -96,6 +98,13 @@ func (k *KubectlTraceSuite) RunnerImage() string {
 	return k.testBackend.RunnerImage()
 }
 
+// InitImage is the init container image pushed to the backend registry.
+func (k *KubectlTraceSuite) InitImage() string {
+	parsedImage, err := docker.ParseImageName(cmd.InitImageName)
+	assert.Nil(k.T(), err)
+	return fmt.Sprintf("localhost:%d/%s/%s:latest", RegistryRemotePort, parsedImage.Repository, parsedImage.Name)
+}
+
 func (k *KubectlTraceSuite) GetTestNode() string {
 	return k.testBackend.GetBackendNode()
 }
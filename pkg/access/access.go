@@ -0,0 +1,231 @@
+package access
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+)
+
+// Permission is something kubectl trace needs to be allowed to do on a resource.
+type Permission struct {
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	// Namespace is empty for cluster wide resources, and for namespaced ones in all the namespaces.
+	Namespace string
+}
+
+// String describes the permission like kubectl auth can-i takes it.
+func (p Permission) String() string {
+	r := p.Resource
+	if p.Group != "" {
+		r = fmt.Sprintf("%s.%s", r, p.Group)
+	}
+	if p.Subresource != "" {
+		r = fmt.Sprintf("%s/%s", r, p.Subresource)
+	}
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s", p.Verb, r)
+	}
+	return fmt.Sprintf("%s %s in namespace %s", p.Verb, r, p.Namespace)
+}
+
+// These are the commands whose permissions are checked before they run.
+const (
+	CommandRun     = "run"
+	CommandAttach  = "attach"
+	CommandLogs    = "logs"
+	CommandDelete  = "delete"
+	CommandExtend  = "extend"
+	CommandTrigger = "trigger"
+)
+
+// Commands are the commands whose permissions are checked, in the order they are listed.
+var Commands = []string{CommandRun, CommandAttach, CommandLogs, CommandDelete, CommandExtend, CommandTrigger}
+
+// RunPermissions returns what the run command needs to create a trace in namespace, and to attach to it
+// or wait for it when asked to.
+func RunPermissions(namespace string, attach, wait bool) []Permission {
+	perms := []Permission{
+		{Verb: "get", Resource: "nodes"},
+		{Verb: "create", Resource: "configmaps", Namespace: namespace},
+		{Verb: "create", Group: "batch", Resource: "jobs", Namespace: namespace},
+		// The config map is updated to be owned by the job
+		{Verb: "update", Resource: "configmaps", Namespace: namespace},
+	}
+	if attach {
+		perms = append(perms,
+			Permission{Verb: "list", Resource: "pods", Namespace: namespace},
+			Permission{Verb: "create", Resource: "pods", Subresource: "attach", Namespace: namespace})
+	}
+	if wait {
+		perms = append(perms,
+			Permission{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+			Permission{Verb: "list", Resource: "pods", Namespace: namespace})
+	}
+	return perms
+}
+
+// NodeInfoPermissions returns what a node-info job in namespace needs, on top of creating it like a trace:
+// reading its output and deleting it once done.
+func NodeInfoPermissions(namespace string) []Permission {
+	return append(RunPermissions(namespace, false, false),
+		Permission{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: namespace},
+		Permission{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+		Permission{Verb: "delete", Resource: "configmaps", Namespace: namespace},
+		Permission{Verb: "list", Resource: "configmaps", Namespace: namespace},
+		Permission{Verb: "list", Resource: "pods", Namespace: namespace},
+		Permission{Verb: "get", Resource: "pods", Subresource: "log", Namespace: namespace})
+}
+
+// AttachPermissions returns what the attach command needs to attach to a trace in namespace.
+func AttachPermissions(namespace string) []Permission {
+	return []Permission{
+		{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "list", Resource: "pods", Namespace: namespace},
+		{Verb: "create", Resource: "pods", Subresource: "attach", Namespace: namespace},
+	}
+}
+
+// LogsPermissions returns what the logs command needs to read the logs of a trace in namespace.
+func LogsPermissions(namespace string) []Permission {
+	return []Permission{
+		{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "list", Resource: "pods", Namespace: namespace},
+		{Verb: "get", Resource: "pods", Subresource: "log", Namespace: namespace},
+	}
+}
+
+// DeletePermissions returns what the delete command needs to delete traces in namespace.
+func DeletePermissions(namespace string) []Permission {
+	return []Permission{
+		{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "delete", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "list", Resource: "configmaps", Namespace: namespace},
+		{Verb: "delete", Resource: "configmaps", Namespace: namespace},
+	}
+}
+
+// ExtendPermissions returns what the extend command needs to change the deadline of a trace in namespace.
+func ExtendPermissions(namespace string) []Permission {
+	return []Permission{
+		{Verb: "list", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "update", Group: "batch", Resource: "jobs", Namespace: namespace},
+		{Verb: "get", Resource: "configmaps", Namespace: namespace},
+		{Verb: "update", Resource: "configmaps", Namespace: namespace},
+	}
+}
+
+// TriggerPermissions returns what the trigger command needs to watch the pods of namespace, and their events
+// when some conditions fire on events, and to create traces in traceNamespace when they meet a condition.
+func TriggerPermissions(namespace, traceNamespace string, events bool) []Permission {
+	perms := append(RunPermissions(traceNamespace, false, false),
+		Permission{Verb: "list", Resource: "pods", Namespace: namespace},
+		Permission{Verb: "watch", Resource: "pods", Namespace: namespace})
+	if events {
+		perms = append(perms, Permission{Verb: "list", Resource: "events", Namespace: namespace})
+	}
+	return perms
+}
+
+// CommandPermissions returns the permissions of a command, with those of the run command for a plain run.
+func CommandPermissions(command, namespace string) ([]Permission, error) {
+	switch command {
+	case CommandRun:
+		return RunPermissions(namespace, false, false), nil
+	case CommandAttach:
+		return AttachPermissions(namespace), nil
+	case CommandLogs:
+		return LogsPermissions(namespace), nil
+	case CommandDelete:
+		return DeletePermissions(namespace), nil
+	case CommandExtend:
+		return ExtendPermissions(namespace), nil
+	case CommandTrigger:
+		return TriggerPermissions(namespace, namespace, true), nil
+	}
+	return nil, fmt.Errorf("unknown command %q, must be one of %s", command, strings.Join(Commands, ", "))
+}
+
+// Review is the outcome of reviewing a permission.
+type Review struct {
+	Permission Permission
+	Allowed    bool
+	Reason     string
+}
+
+// Reviewer asks the API server whether the current user has permissions.
+type Reviewer struct {
+	Client authorizationv1client.SelfSubjectAccessReviewInterface
+}
+
+// Review reviews each of the permissions, once each when they are repeated.
+func (r *Reviewer) Review(perms []Permission) ([]Review, error) {
+	reviews := []Review{}
+	seen := map[Permission]bool{}
+	for _, p := range perms {
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		ssar, err := r.Client.Create(context.Background(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("could not review permission to %s: %s", p, err)
+		}
+		reviews = append(reviews, Review{
+			Permission: p,
+			Allowed:    ssar.Status.Allowed,
+			Reason:     ssar.Status.Reason,
+		})
+	}
+	return reviews, nil
+}
+
+// Check returns a MissingError listing the permissions that are not allowed, if any.
+func (r *Reviewer) Check(command string, perms []Permission) error {
+	reviews, err := r.Review(perms)
+	if err != nil {
+		return err
+	}
+
+	missing := []Permission{}
+	for _, rv := range reviews {
+		if !rv.Allowed {
+			missing = append(missing, rv.Permission)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &MissingError{Command: command, Missing: missing}
+}
+
+// MissingError is returned when a command lacks permissions, before it does anything.
+type MissingError struct {
+	Command string
+	Missing []Permission
+}
+
+func (e *MissingError) Error() string {
+	lines := []string{fmt.Sprintf("not allowed to %s, missing permissions:", e.Command)}
+	for _, p := range e.Missing {
+		lines = append(lines, fmt.Sprintf("  - %s", p))
+	}
+	return strings.Join(lines, "\n")
+}
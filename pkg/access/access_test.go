@@ -0,0 +1,81 @@
+package access
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newTestReviewer provides a reviewer allowing everything but the denied permissions, and counting the reviews.
+func newTestReviewer(denied []Permission, count *int) *Reviewer {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		*count++
+		ssar := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		a := ssar.Spec.ResourceAttributes
+		p := Permission{Verb: a.Verb, Group: a.Group, Resource: a.Resource, Subresource: a.Subresource, Namespace: a.Namespace}
+		ssar.Status.Allowed = true
+		for _, d := range denied {
+			if d == p {
+				ssar.Status.Allowed = false
+			}
+		}
+		return true, ssar, nil
+	})
+	return &Reviewer{Client: client.AuthorizationV1().SelfSubjectAccessReviews()}
+}
+
+func TestPermissionString(t *testing.T) {
+	assert.Equal(t, "get nodes", Permission{Verb: "get", Resource: "nodes"}.String())
+	assert.Equal(t, "create jobs.batch in namespace default", Permission{Verb: "create", Group: "batch", Resource: "jobs", Namespace: "default"}.String())
+	assert.Equal(t, "create pods/attach in namespace default", Permission{Verb: "create", Resource: "pods", Subresource: "attach", Namespace: "default"}.String())
+}
+
+func TestCheckMissing(t *testing.T) {
+	count := 0
+	r := newTestReviewer([]Permission{
+		{Verb: "create", Resource: "configmaps", Namespace: "default"},
+		{Verb: "create", Resource: "pods", Subresource: "attach", Namespace: "default"},
+	}, &count)
+
+	err := r.Check(CommandRun, RunPermissions("default", true, false))
+	require.NotNil(t, err)
+	assert.Equal(t, `not allowed to run, missing permissions:
+  - create configmaps in namespace default
+  - create pods/attach in namespace default`, err.Error())
+
+	missing, ok := err.(*MissingError)
+	require.True(t, ok)
+	assert.Len(t, missing.Missing, 2)
+}
+
+func TestCheckAllowed(t *testing.T) {
+	count := 0
+	r := newTestReviewer(nil, &count)
+
+	assert.Nil(t, r.Check(CommandRun, RunPermissions("default", true, true)))
+	// Listing pods is needed both to attach and wait, it is reviewed once
+	assert.Equal(t, 7, count)
+}
+
+func TestCommandPermissions(t *testing.T) {
+	for _, c := range Commands {
+		perms, err := CommandPermissions(c, "default")
+		assert.Nil(t, err)
+		assert.NotEmpty(t, perms, c)
+	}
+
+	_, err := CommandPermissions("get", "default")
+	assert.EqualError(t, err, `unknown command "get", must be one of run, attach, logs, delete, extend, trigger`)
+}
+
+func TestTriggerPermissions(t *testing.T) {
+	events := Permission{Verb: "list", Resource: "events", Namespace: "shop"}
+	assert.NotContains(t, TriggerPermissions("shop", "default", false), events)
+	assert.Contains(t, TriggerPermissions("shop", "default", true), events)
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/termination"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -40,6 +41,7 @@ func NewAttacher(client tcorev1.CoreV1Interface, config *restclient.Config, stre
 const (
 	podNotFoundError              = "no pod found to attach with the given selector"
 	podPhaseNotAcceptedError      = "cannot attach into a container in a completed pod; current phase is %s"
+	podTerminatedError            = "cannot attach into a container in a completed pod; current phase is %s, trace ended with %s"
 	invalidPodContainersSizeError = "unexpected number of containers in trace job pod"
 )
 
@@ -74,6 +76,9 @@ func (a *Attacher) Attach(selector, namespace string) {
 			}
 			pod := &pl.Items[0]
 			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				if m := termination.FromPod(pod); m != nil {
+					return false, fmt.Errorf(podTerminatedError, pod.Status.Phase, m)
+				}
 				return false, fmt.Errorf(podPhaseNotAcceptedError, pod.Status.Phase)
 			}
 
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/iovisor/kubectl-trace/pkg/tracespec"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	applyShort = `Create traces from trace spec files` // Wrap with i18n.T()
+
+	applyLong = `Create traces from trace spec files.
+
+A trace spec file describes a trace in yaml, with its target, program, deadline, images, resources and patch,
+so that it can be kept along with runbooks. A file can hold several trace specs, separated by ---.` // Wrap with templates.LongDesc()
+
+	applyExamples = `
+  # Create the traces of a file
+  %[1]s trace apply -f trace.yaml
+
+  # Export the spec of a running trace, and create it again later
+  %[1]s trace export 656ee75a-ee3c-11e8-9e7a-8c164500a77e > trace.yaml
+  %[1]s trace apply -f trace.yaml
+
+  # A trace spec file
+  apiVersion: kubectl-trace.iovisor.org/v1alpha1
+  kind: TraceSpec
+  metadata:
+    namespace: kubectl-trace-system
+  spec:
+    target:
+      pod: checkout
+      container: app
+      namespace: payments
+    programFile: read.bt
+    args: ["10"]
+    deadline: 300
+    resources:
+      limits:
+        memory: 2G
+    output:
+      wait: true`
+
+	applyFileRequiredErrString = "a trace spec file is required, use -f"
+)
+
+// ApplyOptions ...
+type ApplyOptions struct {
+	genericclioptions.IOStreams
+
+	filename string
+	specs    []*tracespec.TraceSpec
+}
+
+// NewApplyOptions provides an instance of ApplyOptions with default values.
+func NewApplyOptions(streams genericclioptions.IOStreams) *ApplyOptions {
+	return &ApplyOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewApplyCommand provides the apply command wrapping ApplyOptions.
+func NewApplyCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewApplyOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "apply -f FILE",
+		Short:        applyShort,
+		Long:         applyLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(applyExamples, "kubectl"), // Wrap with templates.Examples()
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Run(factory); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.filename, "filename", "f", o.filename, "File containing trace specs")
+
+	return cmd
+}
+
+// Validate loads the trace specs, so that an invalid file creates no trace at all.
+func (o *ApplyOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(o.filename) == 0 {
+		return fmt.Errorf(applyFileRequiredErrString)
+	}
+
+	var err error
+	o.specs, err = tracespec.Load(o.filename)
+	return err
+}
+
+// Run creates the traces of the file, in order, stopping at the first one that cannot be created.
+func (o *ApplyOptions) Run(factory cmdutil.Factory) error {
+	namespace, _, err := factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	runs := []*RunOptions{}
+	for _, s := range o.specs {
+		ro, err := runOptionsFromSpec(o.IOStreams, s, namespace)
+		if err != nil {
+			return err
+		}
+		if err := ro.validateOptions(); err != nil {
+			return err
+		}
+		runs = append(runs, ro)
+	}
+
+	for _, ro := range runs {
+		if err := ro.completeTarget(factory); err != nil {
+			return err
+		}
+		if err := ro.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runOptionsFromSpec makes the options the run command would have for the spec.
+// The fields the spec leaves out keep the defaults of the run command.
+func runOptionsFromSpec(streams genericclioptions.IOStreams, s *tracespec.TraceSpec, namespace string) (*RunOptions, error) {
+	spec := &s.Spec
+	o := NewRunOptions(streams)
+
+	o.namespace = namespace
+	if len(spec.Target.Namespace) > 0 {
+		o.namespace = spec.Target.Namespace
+	}
+	o.traceNamespace = s.Metadata.Namespace
+
+	if len(spec.Target.Pod) > 0 {
+		o.resourceArg = "pod/" + spec.Target.Pod
+	} else {
+		o.resourceArg = "node/" + spec.Target.Node
+	}
+	o.container = spec.Target.Container
+
+	o.program = spec.Program
+	if len(spec.ProgramFile) > 0 {
+		b, err := ioutil.ReadFile(spec.ProgramFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening program file %s", spec.ProgramFile)
+		}
+		o.program = string(b)
+	}
+	if len(o.program) == 0 {
+		return nil, fmt.Errorf(bpftraceEmptyErrString)
+	}
+	o.args = spec.Args
+
+	if spec.Deadline != nil {
+		o.deadline = int64(*spec.Deadline)
+	}
+	if spec.DeadlineGracePeriod != nil {
+		o.deadlineGracePeriod = int64(*spec.DeadlineGracePeriod)
+	}
+	if len(spec.ServiceAccount) > 0 {
+		o.serviceAccount = spec.ServiceAccount
+	}
+	if len(spec.Image) > 0 {
+		o.imageName = spec.Image
+	}
+	if len(spec.InitImage) > 0 {
+		o.initImageName = spec.InitImage
+	}
+	if len(spec.Headers) > 0 {
+		o.headers = spec.Headers
+	}
+	o.headersSource = s.HeadersSource()
+	if len(spec.SecurityProfile) > 0 {
+		o.securityProfile = spec.SecurityProfile
+	}
+	o.followRestarts = spec.FollowRestarts
+	if spec.Validate != nil {
+		o.validate = *spec.Validate
+	}
+	o.skipLint = spec.SkipLint
+	o.podOptions = s.PodOptions()
+
+	if spec.Patch != nil {
+		o.patch = spec.Patch.File
+		o.patchType = spec.Patch.Type
+	}
+
+	o.attach = spec.Output.Attach
+	o.wait = spec.Output.Wait
+	return o, nil
+}
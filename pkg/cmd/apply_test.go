@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/tracespec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+const argsSpec = `
+apiVersion: kubectl-trace.iovisor.org/v1alpha1
+kind: TraceSpec
+spec:
+  target:
+    node: kubernetes-node-emt8
+  program: 'kprobe:do_sys_open /pid == $1/ { printf("%s %d\n", comm, $#); }'
+  args: ["1234"]
+`
+
+func TestRunOptionsFromSpecArgs(t *testing.T) {
+	specs, err := tracespec.Decode([]byte(argsSpec))
+	require.Nil(t, err)
+	require.Len(t, specs, 1)
+
+	ro, err := runOptionsFromSpec(genericclioptions.NewTestIOStreamsDiscard(), specs[0], "default")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"1234"}, ro.args)
+	assert.Nil(t, ro.validateOptions())
+
+	// Without the args, the program uses a positional parameter that is not given
+	specs[0].Spec.Args = nil
+	ro, err = runOptionsFromSpec(genericclioptions.NewTestIOStreamsDiscard(), specs[0], "default")
+	require.Nil(t, err)
+	err = ro.validateOptions()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "positional parameter $1 is not given, none is passed with --arg")
+}
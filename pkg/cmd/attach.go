@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/iovisor/kubectl-trace/pkg/access"
 	"github.com/iovisor/kubectl-trace/pkg/attacher"
 	"github.com/iovisor/kubectl-trace/pkg/meta"
 	"github.com/iovisor/kubectl-trace/pkg/signals"
@@ -36,10 +37,12 @@ var (
 // AttachOptions ...
 type AttachOptions struct {
 	genericclioptions.IOStreams
-	traceID      *types.UID
-	traceName    *string
-	namespace    string
-	clientConfig *rest.Config
+	traceID         *types.UID
+	traceName       *string
+	namespace       string
+	targetNamespace *string
+	traceNamespace  string
+	clientConfig    *rest.Config
 }
 
 // NewAttachOptions provides an instance of AttachOptions with default values.
@@ -74,6 +77,8 @@ func NewAttachCommand(factory cmdutil.Factory, streams genericclioptions.IOStrea
 		},
 	}
 
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the trace is in, the namespace given with --namespace then is the one of the pod it runs against")
+
 	return cmd
 }
 
@@ -98,7 +103,7 @@ func (o *AttachOptions) Validate(cmd *cobra.Command, args []string) error {
 func (o *AttachOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	// Prepare namespace
 	var err error
-	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
 	if err != nil {
 		return err
 	}
@@ -113,6 +118,10 @@ func (o *AttachOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, ar
 }
 
 func (o *AttachOptions) Run() error {
+	if err := checkAccess(o.clientConfig, access.CommandAttach, access.AttachPermissions(o.namespace)); err != nil {
+		return err
+	}
+
 	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
 	if err != nil {
 		return err
@@ -128,8 +137,9 @@ func (o *AttachOptions) Run() error {
 	}
 
 	tf := tracejob.TraceJobFilter{
-		Name: o.traceName,
-		ID:   o.traceID,
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
 	}
 
 	jobs, err := tc.GetJob(tf)
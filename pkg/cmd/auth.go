@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/iovisor/kubectl-trace/pkg/access"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	authShort = `Inspect the permissions kubectl trace needs` // Wrap with i18n.T()
+	authLong  = authShort
+
+	canIShort = `Check whether the current user has the permissions of the kubectl trace commands` // Wrap with i18n.T()
+	canILong  = `Check whether the current user has the permissions of the kubectl trace commands.
+
+The run, attach, logs, delete, extend and trigger commands check the same permissions before doing anything,
+so that they fail with the list of the missing ones instead of leaving a trace half created.`
+
+	canIExamples = `
+  # Check the permissions of all the commands in the current namespace
+  %[1]s trace auth can-i
+
+  # Check the permissions to run traces in a namespace
+  %[1]s trace auth can-i run -n tracing`
+)
+
+// NewAuthCommand provides the auth command and its can-i child.
+func NewAuthCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: authShort,
+		Long:  authLong, // Wrap with templates.LongDesc()
+		Run: func(c *cobra.Command, args []string) {
+			cobra.NoArgs(c, args)
+			c.Help()
+		},
+	}
+
+	cmd.AddCommand(NewCanICommand(factory, streams))
+
+	return cmd
+}
+
+// newReviewer provides a reviewer of the permissions of the current user.
+func newReviewer(clientConfig *rest.Config) (*access.Reviewer, error) {
+	client, err := authorizationv1client.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &access.Reviewer{Client: client.SelfSubjectAccessReviews()}, nil
+}
+
+// checkAccess fails with the permissions command lacks, if any.
+func checkAccess(clientConfig *rest.Config, command string, perms []access.Permission) error {
+	r, err := newReviewer(clientConfig)
+	if err != nil {
+		return err
+	}
+	return r.Check(command, perms)
+}
+
+// CanIOptions ...
+type CanIOptions struct {
+	genericclioptions.IOStreams
+
+	commands     []string
+	namespace    string
+	clientConfig *rest.Config
+}
+
+// NewCanIOptions provides an instance of CanIOptions with default values.
+func NewCanIOptions(streams genericclioptions.IOStreams) *CanIOptions {
+	return &CanIOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewCanICommand provides the auth can-i command wrapping CanIOptions.
+func NewCanICommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewCanIOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "can-i [COMMAND...]",
+		Short:        canIShort,
+		Long:         canILong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(canIExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		ValidArgs:    access.Commands,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating CanIOptions accordingly.
+func (o *CanIOptions) Validate(cmd *cobra.Command, args []string) error {
+	o.commands = access.Commands
+	if len(args) > 0 {
+		o.commands = args
+	}
+	for _, c := range o.commands {
+		if _, err := access.CommandPermissions(c, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *CanIOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the auth can-i command.
+func (o *CanIOptions) Run() error {
+	r, err := newReviewer(o.clientConfig)
+	if err != nil {
+		return err
+	}
+
+	reviews := map[string][]access.Review{}
+	for _, c := range o.commands {
+		perms, err := access.CommandPermissions(c, o.namespace)
+		if err != nil {
+			return err
+		}
+		reviews[c], err = r.Review(perms)
+		if err != nil {
+			return err
+		}
+	}
+
+	canIPrint(o.Out, o.commands, reviews)
+	return nil
+}
+
+// canIPrint prints the reviews of the permissions of each command.
+func canIPrint(out io.Writer, commands []string, reviews map[string][]access.Review) {
+	w := tabwriter.NewWriter(out, 0, 8, 3, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "COMMAND\tPERMISSION\tALLOWED")
+	for _, c := range commands {
+		for _, rv := range reviews[c] {
+			allowed := "no"
+			if rv.Allowed {
+				allowed = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", c, rv.Permission, allowed)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/iovisor/kubectl-trace/pkg/config"
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	configShort = `View and edit the defaults of the kubectl trace flags` // Wrap with i18n.T()
+	configLong  = `View and edit the defaults of the kubectl trace flags.
+
+The defaults are kept in ~/.kube/kubectl-trace.yaml, or in the file the KUBECTL_TRACE_CONFIG environment variable
+points at. They apply to all the kube contexts, or to one of them overriding those of all the contexts.
+Flags given on the command line always win over the defaults.
+
+The flags that can be given defaults are: ` + strings.Join(config.Keys, ", ") + `.`
+
+	configExamples = `
+  # Show the config file
+  %[1]s trace config view
+
+  # Always run traces with a custom image
+  %[1]s trace config set imagename quay.io/myorg/kubectl-trace-bpftrace:v1
+
+  # Always set environment variables in the trace pods
+  %[1]s trace config set env GOMAXPROCS=1 TZ=UTC
+
+  # Run traces with a dedicated service account in a kube context
+  %[1]s trace config set serviceaccount kubectl-trace --for-context=production
+
+  # Remove a default
+  %[1]s trace config set imagename ""`
+)
+
+// NewConfigCommand provides the config command and its view and set children.
+func NewConfigCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "config",
+		Short:   configShort,
+		Long:    configLong,                             // Wrap with templates.LongDesc()
+		Example: fmt.Sprintf(configExamples, "kubectl"), // Wrap with templates.Examples()
+		// A broken config file must not prevent from fixing it
+		PersistentPreRun: func(c *cobra.Command, args []string) {
+			c.SetOutput(streams.ErrOut)
+		},
+		Run: func(c *cobra.Command, args []string) {
+			cobra.NoArgs(c, args)
+			c.Help()
+		},
+	}
+
+	cmd.AddCommand(NewConfigViewCommand(streams))
+	cmd.AddCommand(NewConfigSetCommand(streams))
+
+	return cmd
+}
+
+// applyConfig sets the flags of cmd that are not given on the command line to their defaults in kubeContext.
+func applyConfig(cmd *cobra.Command, kubeContext string) error {
+	c, err := config.Load(config.Path())
+	if err != nil {
+		return err
+	}
+
+	for key, values := range c.For(kubeContext) {
+		f := cmd.Flags().Lookup(key)
+		if f == nil || f.Changed {
+			continue
+		}
+		// Setting the value, not the flag, keeps it from counting as given on the command line.
+		// A list is set an item at a time, as the flag would be repeated.
+		for _, value := range values {
+			if err := f.Value.Set(value); err != nil {
+				return fmt.Errorf("invalid default %q for %s in %s: %s", value, key, config.Path(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// ConfigViewOptions ...
+type ConfigViewOptions struct {
+	genericclioptions.IOStreams
+}
+
+// NewConfigViewOptions provides an instance of ConfigViewOptions with default values.
+func NewConfigViewOptions(streams genericclioptions.IOStreams) *ConfigViewOptions {
+	return &ConfigViewOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewConfigViewCommand provides the config view command wrapping ConfigViewOptions.
+func NewConfigViewCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewConfigViewOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "view",
+		Short:        "Show the config file",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// Run executes the config view command.
+func (o *ConfigViewOptions) Run() error {
+	c, err := config.Load(config.Path())
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(o.Out, "# %s\n%s", config.Path(), b)
+	return nil
+}
+
+// ConfigSetOptions ...
+type ConfigSetOptions struct {
+	genericclioptions.IOStreams
+
+	key    string
+	values []string
+
+	// Flags local to this command
+	forContext string
+}
+
+// NewConfigSetOptions provides an instance of ConfigSetOptions with default values.
+func NewConfigSetOptions(streams genericclioptions.IOStreams) *ConfigSetOptions {
+	return &ConfigSetOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewConfigSetCommand provides the config set command wrapping ConfigSetOptions.
+func NewConfigSetCommand(streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewConfigSetOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "set KEY VALUE... [--for-context CONTEXT]",
+		Short:        "Set the default of a flag, several values for a flag that can be repeated, an empty value removes it",
+		SilenceUsage: true,
+		ValidArgs:    config.Keys,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&o.forContext, "for-context", o.forContext, "Kube context to set the default for, instead of all of them")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating ConfigSetOptions accordingly.
+func (o *ConfigSetOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("KEY and VALUE are required arguments for the set command")
+	}
+	o.key, o.values = args[0], args[1:]
+	return nil
+}
+
+// Run executes the config set command.
+func (o *ConfigSetOptions) Run() error {
+	path := config.Path()
+	c, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Set(o.forContext, o.key, o.values...); err != nil {
+		return err
+	}
+	return c.Save(path)
+}
+
+// kubeContext returns the kube context the command runs in, the one given with --context or the current one.
+func kubeContext(configFlags *genericclioptions.ConfigFlags) string {
+	if configFlags.Context != nil && len(*configFlags.Context) > 0 {
+		return *configFlags.Context
+	}
+	raw, err := configFlags.ToRawKubeConfigLoader().RawConfig()
+	if err != nil {
+		return ""
+	}
+	return raw.CurrentContext
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestApplyConfigLists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubectl-trace.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`
+defaults:
+  env: [A=1, B=2]
+  label:
+  - team=sre
+  - ticket=INC-42
+  annotation: reason=slow disks, again
+contexts:
+  production:
+    env: [C=3]
+`), 0644))
+	defer os.Setenv(config.EnvVar, os.Getenv(config.EnvVar))
+	os.Setenv(config.EnvVar, path)
+
+	cmd := NewRunCommand(nil, genericclioptions.NewTestIOStreamsDiscard())
+	require.Nil(t, applyConfig(cmd, "staging"))
+
+	env, err := cmd.Flags().GetStringArray("env")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"A=1", "B=2"}, env)
+	labels, err := cmd.Flags().GetStringArray("label")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"team=sre", "ticket=INC-42"}, labels)
+	annotations, err := cmd.Flags().GetStringArray("annotation")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"reason=slow disks, again"}, annotations)
+
+	// The defaults of the context win, and the flags given on the command line win over both
+	cmd = NewRunCommand(nil, genericclioptions.NewTestIOStreamsDiscard())
+	require.Nil(t, cmd.Flags().Parse([]string{"--label", "team=oncall"}))
+	require.Nil(t, applyConfig(cmd, "production"))
+
+	env, err = cmd.Flags().GetStringArray("env")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"C=3"}, env)
+	labels, err = cmd.Flags().GetStringArray("label")
+	require.Nil(t, err)
+	assert.Equal(t, []string{"team=oncall"}, labels)
+}
@@ -1,11 +1,17 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/iovisor/kubectl-trace/pkg/access"
 	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/signals"
 	"github.com/iovisor/kubectl-trace/pkg/tracejob"
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
@@ -28,8 +34,22 @@ var (
   # Delete all bpftrace programs in a specific namespace
   %[1]s trace delete -n myns --all
 
+  # Delete all bpftrace programs running against pods of a namespace, created in a dedicated namespace
+  %[1]s trace delete -n myns --trace-namespace=kubectl-trace-system --all
+
   # Delete all bpftrace programs in all the namespaces
-  %[1]s trace delete --all-namespaces`
+  %[1]s trace delete --all-namespaces
+
+  # Delete the completed bpftrace programs older than two hours
+  %[1]s trace delete --status=completed --older-than=2h
+
+  # Delete the bpftrace programs running against a pod, and wait for their pods to be gone
+  %[1]s trace delete --target=pod/nginx --wait
+
+  # Delete the bpftrace programs on a node, labeled team=sre
+  %[1]s trace delete --node=kubernetes-node-emt8.c.myproject.internal -l team=sre`
+
+	deleteTargetErrString = "--target must be node/NAME or pod/NAME"
 )
 
 // DeleteOptions ...
@@ -39,9 +59,19 @@ type DeleteOptions struct {
 	traceID              *types.UID
 	traceName            *string
 	namespace            string
+	targetNamespace      *string
+	traceNamespace       string
 	clientConfig         *rest.Config
 	all                  bool
 	allNamespaces        bool
+
+	// Filters local to this command
+	status    string
+	olderThan time.Duration
+	node      string
+	target    string
+	wait      bool
+	filter    tracejob.TraceJobFilter
 }
 
 // NewDeleteOptions provides an instance of DeleteOptions with default values.
@@ -49,6 +79,7 @@ func NewDeleteOptions(streams genericclioptions.IOStreams) *DeleteOptions {
 	rbFlags := &genericclioptions.ResourceBuilderFlags{}
 	rbFlags.WithAllNamespaces(false)
 	rbFlags.WithAll(false)
+	rbFlags.WithLabelSelector("")
 
 	return &DeleteOptions{
 		ResourceBuilderFlags: rbFlags,
@@ -82,6 +113,12 @@ func NewDeleteCommand(factory cmdutil.Factory, streams genericclioptions.IOStrea
 	}
 
 	o.ResourceBuilderFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the traces are in, the namespace given with --namespace then selects the traces of its pods")
+	cmd.Flags().StringVar(&o.status, "status", o.status, "Only delete the traces with this status: running, completed or failed")
+	cmd.Flags().DurationVar(&o.olderThan, "older-than", o.olderThan, "Only delete the traces older than this, like 2h")
+	cmd.Flags().StringVar(&o.node, "node", o.node, "Only delete the traces on this node")
+	cmd.Flags().StringVar(&o.target, "target", o.target, "Only delete the traces running against this node or pod, as node/NAME or pod/NAME")
+	cmd.Flags().BoolVar(&o.wait, "wait", o.wait, "Whether or not to wait for the pods of the traces to be gone")
 
 	return cmd
 }
@@ -98,14 +135,66 @@ func (o *DeleteOptions) Validate(cmd *cobra.Command, args []string) error {
 		break
 	}
 
+	if len(o.status) > 0 {
+		status, err := tracejob.ParseTraceJobStatus(o.status)
+		if err != nil {
+			return err
+		}
+		o.filter.Status = &status
+	}
+	if o.olderThan < 0 {
+		return fmt.Errorf("--older-than must not be negative")
+	}
+	if o.olderThan > 0 {
+		createdBefore := time.Now().Add(-o.olderThan)
+		o.filter.CreatedBefore = &createdBefore
+	}
+	if len(o.node) > 0 {
+		o.filter.Node = &o.node
+	}
+	if len(o.target) > 0 {
+		target, err := normalizeTarget(o.target)
+		if err != nil {
+			return err
+		}
+		o.filter.Target = &target
+	}
+	if selector := *o.ResourceBuilderFlags.LabelSelector; len(selector) > 0 {
+		if _, err := labels.Parse(selector); err != nil {
+			return err
+		}
+		o.filter.Selector = selector
+	}
+
 	return nil
 }
 
+// normalizeTarget returns the target as the trace jobs record it, node/NAME or pod/NAME, whatever the resource alias.
+func normalizeTarget(target string) (string, error) {
+	parts := strings.SplitN(target, "/", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return "", fmt.Errorf(deleteTargetErrString)
+	}
+	switch parts[0] {
+	case "node", "nodes", "no":
+		return "node/" + parts[1], nil
+	case "pod", "pods", "po":
+		return "pod/" + parts[1], nil
+	}
+	return "", fmt.Errorf(deleteTargetErrString)
+}
+
+// filtered tells whether traces are selected by filters rather than by ID or name.
+func (o *DeleteOptions) filtered() bool {
+	f := o.filter
+	return f.Status != nil || f.CreatedBefore != nil || f.Node != nil || f.Target != nil || len(f.Selector) > 0
+}
+
 // Complete completes the setup of the command.
 func (o *DeleteOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	// Prepare namespace
 	var err error
-	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
 	if err != nil {
 		return err
 	}
@@ -125,13 +214,21 @@ func (o *DeleteOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, ar
 		return err
 	}
 
-	if o.traceID == nil && o.traceName == nil && o.all == false {
-		return fmt.Errorf("when no trace id or trace name are specified you must specify --all=true to delete all the traces")
+	if o.traceID == nil && o.traceName == nil && o.all == false && !o.filtered() {
+		return fmt.Errorf("when no trace id, trace name or filter are specified you must specify --all=true to delete all the traces")
 	}
 	return nil
 }
 
 func (o *DeleteOptions) Run() error {
+	perms := access.DeletePermissions(o.namespace)
+	if o.wait {
+		perms = append(perms, access.Permission{Verb: "list", Resource: "pods", Namespace: o.namespace})
+	}
+	if err := checkAccess(o.clientConfig, access.CommandDelete, perms); err != nil {
+		return err
+	}
+
 	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
 	if err != nil {
 		return err
@@ -145,19 +242,24 @@ func (o *DeleteOptions) Run() error {
 	tc := &tracejob.TraceJobClient{
 		JobClient:    jobsClient.Jobs(o.namespace),
 		ConfigClient: coreClient.ConfigMaps(o.namespace),
+		PodClient:    coreClient.Pods(o.namespace),
 	}
 
 	tc.WithOutStream(o.Out)
 
-	tf := tracejob.TraceJobFilter{
-		Name: o.traceName,
-		ID:   o.traceID,
-	}
+	tf := o.filter
+	tf.Name = o.traceName
+	tf.ID = o.traceID
+	tf.TargetNamespace = o.targetNamespace
 
-	err = tc.DeleteJobs(tf)
-	if err != nil {
-		return err
+	// The traces deleted are waited for even when others could not be deleted
+	deleted, err := tc.DeleteJobs(tf)
+	if o.wait && len(deleted) > 0 {
+		ctx := signals.WithStandardSignals(context.Background())
+		if werr := tc.WaitPodsDeleted(ctx, deleted); werr != nil {
+			return werr
+		}
+		fmt.Fprintf(o.Out, "pods of %d traces gone\n", len(deleted))
 	}
-
-	return nil
+	return err
 }
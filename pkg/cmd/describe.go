@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	describeShort = `Show the details of a trace` // Wrap with i18n.T()
+
+	describeLong = `Show the details of a trace.
+
+Once the trace has finished, the summary the trace runner left in the termination message of its pod is shown too:
+why the program exited, how long it ran, the probes it attached, the events it lost and the errors it reported.` // Wrap with templates.LongDesc()
+
+	describeExamples = `
+  # Show the details of a trace
+  %[1]s trace describe 656ee75a-ee3c-11e8-9e7a-8c164500a77e
+
+  # Show the details of a trace created in a dedicated namespace
+  %[1]s trace describe kubectl-trace-d5842929-0b78-11e9-a9fa-40a3cc632df1 --trace-namespace=kubectl-trace-system`
+)
+
+// DescribeOptions ...
+type DescribeOptions struct {
+	genericclioptions.IOStreams
+
+	traceID         *types.UID
+	traceName       *string
+	namespace       string
+	targetNamespace *string
+	traceNamespace  string
+	clientConfig    *rest.Config
+}
+
+// NewDescribeOptions provides an instance of DescribeOptions with default values.
+func NewDescribeOptions(streams genericclioptions.IOStreams) *DescribeOptions {
+	return &DescribeOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewDescribeCommand provides the describe command wrapping DescribeOptions.
+func NewDescribeCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewDescribeOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "describe (TRACE_ID | TRACE_NAME)",
+		Short:        describeShort,
+		Long:         describeLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(describeExamples, "kubectl"), // Wrap with templates.Examples()
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the trace is in, the namespace given with --namespace then is the one of the pod it runs against")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating DescribeOptions accordingly.
+func (o *DescribeOptions) Validate(cmd *cobra.Command, args []string) error {
+	if meta.IsObjectName(args[0]) {
+		o.traceName = &args[0]
+	} else {
+		tid := types.UID(args[0])
+		o.traceID = &tid
+	}
+
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *DescribeOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var err error
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
+	if err != nil {
+		return err
+	}
+
+	o.clientConfig, err = factory.ToRESTConfig()
+	return err
+}
+
+// Run prints the details of the trace.
+func (o *DescribeOptions) Run() error {
+	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+	coreClient, err := corev1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+
+	tc := &tracejob.TraceJobClient{
+		JobClient:    jobsClient.Jobs(o.namespace),
+		ConfigClient: coreClient.ConfigMaps(o.namespace),
+		PodClient:    coreClient.Pods(o.namespace),
+	}
+	tc.WithOutStream(ioutil.Discard)
+
+	jobs, err := tc.GetJob(tracejob.TraceJobFilter{
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
+	})
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no trace found with the provided criterias")
+	}
+
+	describeTrace(o.Out, jobs[0])
+	return nil
+}
+
+func describeTrace(o io.Writer, j tracejob.TraceJob) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(o, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	status := j.Status
+	if status == "" {
+		status = tracejob.TraceJobUnknown
+	}
+	fmt.Fprintf(w, "Name:\t%s\n", j.Name)
+	fmt.Fprintf(w, "ID:\t%s\n", j.ID)
+	fmt.Fprintf(w, "Namespace:\t%s\n", j.Namespace)
+	fmt.Fprintf(w, "Target:\t%s\n", valueOrNone(j.Target))
+	if len(j.TargetNamespace) > 0 {
+		fmt.Fprintf(w, "Target Namespace:\t%s\n", j.TargetNamespace)
+	}
+	fmt.Fprintf(w, "Node:\t%s\n", valueOrNone(j.Hostname))
+	fmt.Fprintf(w, "Status:\t%s\n", status)
+	fmt.Fprintf(w, "Age:\t%s\n", translateTimestampSince(j.StartTime))
+
+	m := j.TerminationMessage
+	if m == nil {
+		fmt.Fprintf(w, "Result:\t<none>\n")
+		return
+	}
+	fmt.Fprintf(w, "Result:\t%s\n", m.Reason)
+	if m.RuntimeSeconds > 0 {
+		fmt.Fprintf(w, "  Runtime:\t%s\n", time.Duration(m.RuntimeSeconds)*time.Second)
+	}
+	fmt.Fprintf(w, "  Probes Attached:\t%d\n", m.ProbesAttached)
+	fmt.Fprintf(w, "  Lost Events:\t%d\n", m.LostEvents)
+	if m.Restarts > 0 {
+		fmt.Fprintf(w, "  Restarts Followed:\t%d\n", m.Restarts)
+	}
+	if m.ContainerStarted {
+		fmt.Fprintf(w, "  Container Started:\tbefore the probes were attached\n")
+	}
+	if len(m.PID) > 0 {
+		fmt.Fprintf(w, "  PID:\t%s\n", m.PID)
+	}
+	if len(m.Cgroup) > 0 {
+		fmt.Fprintf(w, "  Cgroup:\t%s\n", m.Cgroup)
+	}
+	if len(m.BpftraceVersion) > 0 {
+		fmt.Fprintf(w, "  bpftrace:\t%s\n", m.BpftraceVersion)
+	}
+	if len(m.Errors) > 0 {
+		fmt.Fprintf(w, "  Errors:\t\n")
+		for _, e := range m.Errors {
+			if e.Line > 0 {
+				fmt.Fprintf(w, "    %d:%d: %s\n", e.Line, e.Column, e.Message)
+				continue
+			}
+			fmt.Fprintf(w, "    %s\n", e.Message)
+		}
+	}
+}
+
+func valueOrNone(s string) string {
+	if len(s) == 0 {
+		return "<none>"
+	}
+	return s
+}
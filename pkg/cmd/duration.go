@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+)
+
+// secondsValue is a flag holding a number of seconds, given as a duration like 90s, 15m or 2h,
+// or as a plain number of seconds as the flags used to take.
+type secondsValue int64
+
+func newSecondsValue(val int64, p *int64) *secondsValue {
+	*p = val
+	return (*secondsValue)(p)
+}
+
+func (s *secondsValue) Set(v string) error {
+	d, err := tracejob.ParseDuration(v)
+	if err != nil {
+		return err
+	}
+	*s = secondsValue(d / time.Second)
+	return nil
+}
+
+func (s *secondsValue) Type() string {
+	return "duration"
+}
+
+func (s *secondsValue) String() string {
+	// Left out of the help like the zero values of the other flags
+	if *s == 0 {
+		return "0"
+	}
+	return (time.Duration(*s) * time.Second).String()
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/iovisor/kubectl-trace/pkg/tracespec"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	exportShort = `Print the trace spec of an existing trace` // Wrap with i18n.T()
+
+	exportLong = `Print the trace spec of an existing trace, to create it again with apply.
+
+The patch the trace was created with is not recorded, it is left out of the spec.` // Wrap with templates.LongDesc()
+
+	exportExamples = `
+  # Print the spec of a trace
+  %[1]s trace export 656ee75a-ee3c-11e8-9e7a-8c164500a77e
+
+  # Save the spec of a trace created in a dedicated namespace
+  %[1]s trace export kubectl-trace-d5842929-0b78-11e9-a9fa-40a3cc632df1 --trace-namespace=kubectl-trace-system > trace.yaml`
+)
+
+// ExportOptions ...
+type ExportOptions struct {
+	genericclioptions.IOStreams
+
+	traceID         *types.UID
+	traceName       *string
+	namespace       string
+	targetNamespace *string
+	traceNamespace  string
+	clientConfig    *rest.Config
+}
+
+// NewExportOptions provides an instance of ExportOptions with default values.
+func NewExportOptions(streams genericclioptions.IOStreams) *ExportOptions {
+	return &ExportOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewExportCommand provides the export command wrapping ExportOptions.
+func NewExportCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewExportOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "export (TRACE_ID | TRACE_NAME)",
+		Short:        exportShort,
+		Long:         exportLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(exportExamples, "kubectl"), // Wrap with templates.Examples()
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the trace is in, the namespace given with --namespace then is the one of the pod it runs against")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating ExportOptions accordingly.
+func (o *ExportOptions) Validate(cmd *cobra.Command, args []string) error {
+	if meta.IsObjectName(args[0]) {
+		o.traceName = &args[0]
+	} else {
+		tid := types.UID(args[0])
+		o.traceID = &tid
+	}
+
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *ExportOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var err error
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
+	if err != nil {
+		return err
+	}
+
+	o.clientConfig, err = factory.ToRESTConfig()
+	return err
+}
+
+// Run prints the spec of the trace.
+func (o *ExportOptions) Run() error {
+	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+	coreClient, err := corev1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+
+	tc := &tracejob.TraceJobClient{
+		JobClient:    jobsClient.Jobs(o.namespace),
+		ConfigClient: coreClient.ConfigMaps(o.namespace),
+	}
+	tc.WithOutStream(ioutil.Discard)
+
+	jobs, err := tc.GetJob(tracejob.TraceJobFilter{
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
+	})
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no trace found with the provided criterias")
+	}
+
+	// The job and the config map are both named after the trace
+	name := jobs[0].Name
+	job, err := tc.JobClient.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	cm, err := tc.ConfigClient.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	b, err := yaml.Marshal(tracespec.FromJob(job, cm))
+	if err != nil {
+		return err
+	}
+	_, err = o.Out.Write(b)
+	return err
+}
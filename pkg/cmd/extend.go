@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/access"
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	extendShort = `Change the deadline of a running trace` // Wrap with i18n.T()
+
+	extendLong = `Change the deadline of a running trace.
+
+The deadline is pushed out by the given duration, or brought forward when it is negative. It counts from the start
+of the trace runner in the trace pod, after its images were pulled and its headers fetched, not from the creation of the trace.
+The trace picks the new deadline up within a minute, the time the config map volume of its pod takes to be updated.` // Wrap with templates.LongDesc()
+
+	extendExamples = `
+  # Give a trace 30 more minutes
+  %[1]s trace extend 656ee75a-ee3c-11e8-9e7a-8c164500a77e --by=30m
+
+  # Stop a trace 10 minutes earlier
+  %[1]s trace extend kubectl-trace-d5842929-0b78-11e9-a9fa-40a3cc632df1 --by=-10m`
+
+	extendByRequiredErrString = "--by is required, like --by=30m"
+)
+
+// ExtendOptions ...
+type ExtendOptions struct {
+	genericclioptions.IOStreams
+
+	traceID         *types.UID
+	traceName       *string
+	namespace       string
+	targetNamespace *string
+	traceNamespace  string
+	clientConfig    *rest.Config
+
+	// Flags local to this command
+	by time.Duration
+}
+
+// NewExtendOptions provides an instance of ExtendOptions with default values.
+func NewExtendOptions(streams genericclioptions.IOStreams) *ExtendOptions {
+	return &ExtendOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewExtendCommand provides the extend command wrapping ExtendOptions.
+func NewExtendCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewExtendOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "extend (TRACE_ID | TRACE_NAME) --by=DURATION",
+		Short:        extendShort,
+		Long:         extendLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(extendExamples, "kubectl"), // Wrap with templates.Examples()
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().DurationVar(&o.by, "by", o.by, "How much to push the deadline out by, or bring it forward by when negative")
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the trace is in, the namespace given with --namespace then is the one of the pod it runs against")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating ExtendOptions accordingly.
+func (o *ExtendOptions) Validate(cmd *cobra.Command, args []string) error {
+	if meta.IsObjectName(args[0]) {
+		o.traceName = &args[0]
+	} else {
+		tid := types.UID(args[0])
+		o.traceID = &tid
+	}
+
+	if o.by.Truncate(time.Second) == 0 {
+		return fmt.Errorf(extendByRequiredErrString)
+	}
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *ExtendOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	var err error
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
+	if err != nil {
+		return err
+	}
+
+	o.clientConfig, err = factory.ToRESTConfig()
+	return err
+}
+
+// Run executes the extend command.
+func (o *ExtendOptions) Run() error {
+	if err := checkAccess(o.clientConfig, access.CommandExtend, access.ExtendPermissions(o.namespace)); err != nil {
+		return err
+	}
+
+	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+	coreClient, err := corev1client.NewForConfig(o.clientConfig)
+	if err != nil {
+		return err
+	}
+
+	tc := &tracejob.TraceJobClient{
+		JobClient:    jobsClient.Jobs(o.namespace),
+		ConfigClient: coreClient.ConfigMaps(o.namespace),
+	}
+	tc.WithOutStream(ioutil.Discard)
+
+	deadline, err := tc.ExtendDeadline(tracejob.TraceJobFilter{
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
+	}, o.by)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "trace deadline set to %s from its start\n", deadline)
+	return nil
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/gc"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	gcShort = `Delete the leftover objects of traces` // Wrap with i18n.T()
+
+	gcLong = `Delete the leftover objects of traces.
+
+The config maps and pods of traces whose job is gone, and the jobs of traces whose config map is gone, are collected.
+With --older-than, all the objects of the traces older than it are collected too, but those of the traces still
+running unless --include-running is given.
+
+Nothing is deleted unless --dry-run=false is given, the objects to collect are only listed.
+All the namespaces are looked at, unless a namespace is given.` // Wrap with templates.LongDesc()
+
+	gcExamples = `
+  # List the leftover objects of traces in all the namespaces
+  %[1]s trace gc
+
+  # Delete them
+  %[1]s trace gc --dry-run=false
+
+  # Delete all the objects of the finished traces older than a day in the kubectl-trace-system namespace
+  %[1]s trace gc -n kubectl-trace-system --older-than=24h --dry-run=false`
+
+	gcArgsErrString = "the gc command takes no arguments"
+)
+
+// GCOptions ...
+type GCOptions struct {
+	genericclioptions.IOStreams
+
+	namespace string
+
+	// Flags local to this command
+	dryRun         bool
+	olderThan      int64
+	includeRunning bool
+
+	client kubernetes.Interface
+}
+
+// NewGCOptions provides an instance of GCOptions with default values.
+func NewGCOptions(streams genericclioptions.IOStreams) *GCOptions {
+	return &GCOptions{
+		IOStreams: streams,
+		dryRun:    true,
+	}
+}
+
+// NewGCCommand provides the gc command wrapping GCOptions.
+func NewGCCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewGCOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "gc [--older-than=DURATION] [--dry-run=false]",
+		Short:        gcShort,
+		Long:         gcLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(gcExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", o.dryRun, "Only list the objects to collect instead of deleting them")
+	cmd.Flags().Var(newSecondsValue(o.olderThan, &o.olderThan), "older-than", "Also collect all the objects of the traces older than this, like 24h, or in seconds")
+	cmd.Flags().BoolVar(&o.includeRunning, "include-running", o.includeRunning, "Whether --older-than also collects the traces still running")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating GCOptions accordingly.
+func (o *GCOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf(gcArgsErrString)
+	}
+	if o.olderThan < 0 {
+		return fmt.Errorf("--older-than must not be negative")
+	}
+	if o.includeRunning && o.olderThan == 0 {
+		return fmt.Errorf("--include-running can only be used with --older-than")
+	}
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *GCOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// All the namespaces unless one is given
+	if f := cmd.Flag("namespace"); f != nil && f.Changed {
+		var err error
+		o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	o.client, err = factory.KubernetesClientSet()
+	return err
+}
+
+// Run executes the gc command.
+func (o *GCOptions) Run() error {
+	now := time.Now()
+	objects, err := gc.Find(o.client, gc.Options{
+		Namespace:      o.namespace,
+		OlderThan:      time.Duration(o.olderThan) * time.Second,
+		IncludeRunning: o.includeRunning,
+		Now:            now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(objects) == 0 {
+		fmt.Fprintln(o.Out, "No leftover trace objects found.")
+		return nil
+	}
+
+	if o.dryRun {
+		gcTablePrint(o.Out, objects, now)
+		fmt.Fprintf(o.ErrOut, "%d objects to delete, run again with --dry-run=false to delete them\n", len(objects))
+		return nil
+	}
+
+	return gc.Delete(o.client, objects, func(obj gc.Object) {
+		fmt.Fprintf(o.Out, "%s deleted from namespace %s\n", obj, obj.Namespace)
+	})
+}
+
+func gcTablePrint(o io.Writer, objects []gc.Object, now time.Time) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(o, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, "NAMESPACE\tNAME\tTRACE ID\tAGE\tREASON")
+	for _, obj := range objects {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", obj.Namespace, obj, obj.TraceID, duration.HumanDuration(now.Sub(obj.Created)), obj.Reason)
+	}
+}
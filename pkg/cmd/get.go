@@ -35,11 +35,18 @@ var (
   # Get only a specific trace in a specific namespace
   %[1]s trace get 656ee75a-ee3c-11e8-9e7a-8c164500a77e -n myns
 
+  # Get the traces of pods in the payments namespace, created in a dedicated namespace
+  %[1]s trace get -n payments --trace-namespace=kubectl-trace-system
+
   # Get all traces in all namespaces
-  %[1]s trace get --all-namespaces`
+  %[1]s trace get --all-namespaces
+
+  # Get the traces along with why the finished ones exited
+  %[1]s trace get -n myns -o wide`
 
 	argumentsErr     = fmt.Sprintf("at most one argument for %s command", getCommand)
 	missingTargetErr = fmt.Sprintf("specify either a TRACE_ID or a namespace or all namespaces")
+	getOutputErr     = "--output can only be wide"
 )
 
 // GetOptions ...
@@ -47,14 +54,17 @@ type GetOptions struct {
 	genericclioptions.IOStreams
 	ResourceBuilderFlags *genericclioptions.ResourceBuilderFlags
 
-	namespace string
+	namespace       string
+	targetNamespace *string
 
 	// Local to this command
-	allNamespaces bool
-	traceArg      string
-	clientConfig  *rest.Config
-	traceID       *types.UID
-	traceName     *string
+	traceNamespace string
+	allNamespaces  bool
+	traceArg       string
+	clientConfig   *rest.Config
+	traceID        *types.UID
+	traceName      *string
+	output         string
 }
 
 // NewGetOptions provides an instance of GetOptions with default values.
@@ -94,6 +104,8 @@ func NewGetCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams)
 	}
 
 	o.ResourceBuilderFlags.AddFlags(cmd.Flags())
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the traces are in, the namespace given with --namespace then selects the traces of its pods")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format, wide to add why the finished traces exited")
 
 	return cmd
 }
@@ -110,6 +122,10 @@ func (o *GetOptions) Validate(cmd *cobra.Command, args []string) error {
 		break
 	}
 
+	if len(o.output) > 0 && o.output != "wide" {
+		return fmt.Errorf(getOutputErr)
+	}
+
 	return nil
 }
 
@@ -117,7 +133,7 @@ func (o *GetOptions) Validate(cmd *cobra.Command, args []string) error {
 func (o *GetOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	// Prepare namespace
 	var err error
-	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
 	if err != nil {
 		return err
 	}
@@ -152,12 +168,17 @@ func (o *GetOptions) Run() error {
 		JobClient:    jobsClient.Jobs(o.namespace),
 		ConfigClient: coreClient.ConfigMaps(o.namespace),
 	}
+	// The termination messages are only shown in the wide output
+	if o.output == "wide" {
+		tc.PodClient = coreClient.Pods(o.namespace)
+	}
 
 	tc.WithOutStream(o.Out)
 
 	tf := tracejob.TraceJobFilter{
-		Name: o.traceName,
-		ID:   o.traceID,
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
 	}
 
 	jobs, err := tc.GetJob(tf)
@@ -167,14 +188,17 @@ func (o *GetOptions) Run() error {
 	}
 
 	// TODO: support other output formats via the o flag, like json, yaml. Not sure if a good idea, trace is not a resource in k8s
-	jobsTablePrint(o.Out, jobs)
+	jobsTablePrint(o.Out, jobs, o.output == "wide")
 	return nil
 }
 
 // TODO(fntlnz): This needs better printing, perhaps we could use the humanreadable table from k8s itself
 // to be consistent with the main project.
-func jobsTablePrint(o io.Writer, jobs []tracejob.TraceJob) {
+func jobsTablePrint(o io.Writer, jobs []tracejob.TraceJob, wide bool) {
 	format := "%s\t%s\t%s\t%s\t%s\t"
+	if wide {
+		format += "%s\t"
+	}
 	if len(jobs) == 0 {
 		fmt.Println("No resources found.")
 		return
@@ -187,13 +211,25 @@ func jobsTablePrint(o io.Writer, jobs []tracejob.TraceJob) {
 
 	// TODO(fntlnz): Do the status and age fields, we don't have a way to get them now, so reporting
 	// them as missing.
-	fmt.Fprintf(w, format, "NAMESPACE", "NODE", "NAME", "STATUS", "AGE")
+	header := []interface{}{"NAMESPACE", "NODE", "NAME", "STATUS", "AGE"}
+	if wide {
+		header = append(header, "RESULT")
+	}
+	fmt.Fprintf(w, format, header...)
 	for _, j := range jobs {
 		status := j.Status
 		if status == "" {
 			status = tracejob.TraceJobUnknown
 		}
-		fmt.Fprintf(w, "\n"+format, j.Namespace, j.Hostname, j.Name, status, translateTimestampSince(j.StartTime))
+		row := []interface{}{j.Namespace, j.Hostname, j.Name, status, translateTimestampSince(j.StartTime)}
+		if wide {
+			result := "<none>"
+			if j.TerminationMessage != nil {
+				result = j.TerminationMessage.Summary()
+			}
+			row = append(row, result)
+		}
+		fmt.Fprintf(w, "\n"+format, row...)
 	}
 	fmt.Fprintf(w, "\n")
 }
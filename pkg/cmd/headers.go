@@ -0,0 +1,413 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/headers"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	appsv1client "k8s.io/client-go/kubernetes/typed/apps/v1"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	headersShort = `Prepare the linux headers of the nodes ahead of the traces` // Wrap with i18n.T()
+	headersLong  = `Prepare the linux headers of the nodes ahead of the traces.
+
+Traces fetching linux headers download and build them the first time they run on a node, which takes minutes.
+The headers are kept in a cache on the node, that these commands populate ahead of time with a DaemonSet,
+check and clean up.`
+
+	headersExamples = `
+  # Prepare the headers on all the nodes
+  %[1]s trace headers prepare
+
+  # Prepare the headers on the nodes of a pool, from an internal mirror
+  %[1]s trace headers prepare -l pool=tracing --headers-mirror='https://mirror.internal/linux/v{major}.x/linux-{version}.tar.gz'
+
+  # Check on which nodes the headers are ready
+  %[1]s trace headers status
+
+  # Stop preparing the headers and remove the ones of kernel versions the nodes no longer run
+  %[1]s trace headers clean
+
+  # Remove all the cached headers
+  %[1]s trace headers clean --all`
+
+	headersCleanTimeout = 5 * time.Minute
+)
+
+// NewHeadersCommand provides the headers command and its prepare, status and clean children.
+func NewHeadersCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "headers",
+		Short:   headersShort,
+		Long:    headersLong,                             // Wrap with templates.LongDesc()
+		Example: fmt.Sprintf(headersExamples, "kubectl"), // Wrap with templates.Examples()
+		Run: func(c *cobra.Command, args []string) {
+			cobra.NoArgs(c, args)
+			c.Help()
+		},
+	}
+
+	cmd.AddCommand(NewHeadersPrepareCommand(factory, streams))
+	cmd.AddCommand(NewHeadersStatusCommand(factory, streams))
+	cmd.AddCommand(NewHeadersCleanCommand(factory, streams))
+
+	return cmd
+}
+
+// newCacheClient provides a client for the headers cache of the nodes, working in namespace.
+func newCacheClient(clientConfig *rest.Config, namespace string) (*headers.CacheClient, error) {
+	appsClient, err := appsv1client.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	jobsClient, err := batchv1client.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	coreClient, err := corev1client.NewForConfig(clientConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &headers.CacheClient{
+		DaemonSetClient: appsClient.DaemonSets(namespace),
+		PodClient:       coreClient.Pods(namespace),
+		JobClient:       jobsClient.Jobs(namespace),
+		NodeClient:      coreClient.Nodes(),
+	}, nil
+}
+
+// HeadersPrepareOptions ...
+type HeadersPrepareOptions struct {
+	genericclioptions.IOStreams
+
+	namespace string
+
+	// Flags local to this command
+	selector       string
+	serviceAccount string
+	initImageName  string
+	headersSource  tracejob.HeadersSource
+
+	clientConfig *rest.Config
+}
+
+// NewHeadersPrepareOptions provides an instance of HeadersPrepareOptions with default values.
+func NewHeadersPrepareOptions(streams genericclioptions.IOStreams) *HeadersPrepareOptions {
+	return &HeadersPrepareOptions{
+		IOStreams: streams,
+
+		serviceAccount: "default",
+		initImageName:  InitImageName + ":" + InitImageTag,
+	}
+}
+
+// NewHeadersPrepareCommand provides the headers prepare command wrapping HeadersPrepareOptions.
+func NewHeadersPrepareCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewHeadersPrepareOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "prepare [-l SELECTOR]",
+		Short:        "Deploy a DaemonSet preparing the linux headers on the nodes",
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", o.selector, "Label selector of the nodes to prepare the headers on")
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the DaemonSet")
+	cmd.Flags().StringVar(&o.initImageName, "init-imagename", o.initImageName, "Custom image for the init container responsible to fetch and prepare linux headers")
+	cmd.Flags().StringVar(&o.headersSource.MirrorURL, "headers-mirror", o.headersSource.MirrorURL, "URL template of a mirror to fetch the kernel sources from, with {release}, {version}, {major} and {build_id} placeholders")
+	cmd.Flags().StringVar(&o.headersSource.Image, "headers-image", o.headersSource.Image, "Image with the kernel sources or prepared headers in /linux-headers to fetch the headers from")
+	cmd.Flags().StringVar(&o.headersSource.PVC, "headers-pvc", o.headersSource.PVC, "Persistent volume claim with the kernel sources or prepared headers to fetch the headers from")
+	cmd.Flags().StringVar(&o.headersSource.HostPath, "headers-hostpath", o.headersSource.HostPath, "Directory of the nodes with the kernel sources or prepared headers to fetch the headers from")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating HeadersPrepareOptions accordingly.
+func (o *HeadersPrepareOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("the prepare command does not take arguments")
+	}
+
+	if _, err := labels.Parse(o.selector); err != nil {
+		return err
+	}
+
+	return o.headersSource.Validate()
+}
+
+// Complete completes the setup of the command.
+func (o *HeadersPrepareOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the headers prepare command.
+func (o *HeadersPrepareOptions) Run() error {
+	cc, err := newCacheClient(o.clientConfig, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	ds, err := cc.Prepare(headers.Prepare{
+		Namespace:        o.namespace,
+		ServiceAccount:   o.serviceAccount,
+		InitImageNameTag: o.initImageName,
+		Source:           o.headersSource,
+		NodeSelector:     o.selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(o.Out, "daemonset %s/%s is preparing the headers, check the nodes with headers status\n", ds.Namespace, ds.Name)
+	return nil
+}
+
+// HeadersStatusOptions ...
+type HeadersStatusOptions struct {
+	genericclioptions.IOStreams
+
+	namespace    string
+	clientConfig *rest.Config
+}
+
+// NewHeadersStatusOptions provides an instance of HeadersStatusOptions with default values.
+func NewHeadersStatusOptions(streams genericclioptions.IOStreams) *HeadersStatusOptions {
+	return &HeadersStatusOptions{
+		IOStreams: streams,
+	}
+}
+
+// NewHeadersStatusCommand provides the headers status command wrapping HeadersStatusOptions.
+func NewHeadersStatusCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewHeadersStatusOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "status",
+		Short:        "Report on which nodes the linux headers are ready",
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating HeadersStatusOptions accordingly.
+func (o *HeadersStatusOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("the status command does not take arguments")
+	}
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *HeadersStatusOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the headers status command.
+func (o *HeadersStatusOptions) Run() error {
+	cc, err := newCacheClient(o.clientConfig, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	statuses, err := cc.Status()
+	if err != nil {
+		return err
+	}
+
+	headersStatusPrint(o.Out, statuses)
+	return nil
+}
+
+// HeadersCleanOptions ...
+type HeadersCleanOptions struct {
+	genericclioptions.IOStreams
+
+	namespace string
+
+	// Flags local to this command
+	selector       string
+	all            bool
+	serviceAccount string
+	initImageName  string
+
+	clientConfig *rest.Config
+}
+
+// NewHeadersCleanOptions provides an instance of HeadersCleanOptions with default values.
+func NewHeadersCleanOptions(streams genericclioptions.IOStreams) *HeadersCleanOptions {
+	return &HeadersCleanOptions{
+		IOStreams: streams,
+
+		serviceAccount: "default",
+		initImageName:  InitImageName + ":" + InitImageTag,
+	}
+}
+
+// NewHeadersCleanCommand provides the headers clean command wrapping HeadersCleanOptions.
+func NewHeadersCleanCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewHeadersCleanOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "clean [-l SELECTOR] [--all]",
+		Short:        "Stop preparing the linux headers and remove the stale ones from the nodes",
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", o.selector, "Label selector of the nodes to clean the headers of, the headers keep being prepared on the other nodes")
+	cmd.Flags().BoolVar(&o.all, "all", o.all, "Remove the headers of the kernel the nodes run too, not only the stale ones")
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the clean jobs")
+	cmd.Flags().StringVar(&o.initImageName, "init-imagename", o.initImageName, "Custom image for the clean jobs")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating HeadersCleanOptions accordingly.
+func (o *HeadersCleanOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("the clean command does not take arguments")
+	}
+	if _, err := labels.Parse(o.selector); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *HeadersCleanOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the headers clean command.
+func (o *HeadersCleanOptions) Run() error {
+	cc, err := newCacheClient(o.clientConfig, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	jobs, err := cc.Clean(headers.Clean{
+		Namespace:      o.namespace,
+		ServiceAccount: o.serviceAccount,
+		ImageNameTag:   o.initImageName,
+		NodeSelector:   o.selector,
+		All:            o.all,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), headersCleanTimeout)
+	defer cancel()
+	statuses, err := cc.WaitCleaned(ctx, jobs)
+	if err != nil {
+		return err
+	}
+
+	headersStatusPrint(o.Out, statuses)
+	return nil
+}
+
+func headersStatusPrint(o io.Writer, statuses []headers.NodeStatus) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(o, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "NODE\tKERNEL\tSTATE\tMESSAGE\n")
+	for _, s := range statuses {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Node, s.KernelVersion, s.State, s.Message)
+	}
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 
+	"github.com/iovisor/kubectl-trace/pkg/access"
 	"github.com/iovisor/kubectl-trace/pkg/logs"
 	"github.com/iovisor/kubectl-trace/pkg/meta"
 	"github.com/iovisor/kubectl-trace/pkg/tracejob"
@@ -36,12 +37,14 @@ var (
 // LogOptions ...
 type LogOptions struct {
 	genericclioptions.IOStreams
-	traceID      *types.UID
-	traceName    *string
-	namespace    string
-	clientConfig *rest.Config
-	follow       bool
-	timestamps   bool
+	traceID         *types.UID
+	traceName       *string
+	namespace       string
+	targetNamespace *string
+	traceNamespace  string
+	clientConfig    *rest.Config
+	follow          bool
+	timestamps      bool
 }
 
 // NewLogOptions provides an instance of LogOptions with default values.
@@ -81,6 +84,7 @@ func NewLogCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams)
 	}
 
 	cmd.Flags().BoolVarP(&o.follow, "follow", "f", o.follow, "Specify if the logs should be streamed")
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace the trace is in, the namespace given with --namespace then is the one of the pod it runs against")
 	cmd.Flags().BoolVar(&o.timestamps, "timestamps", o.timestamps, "Include timestamps on each line in the log output")
 	return cmd
 }
@@ -101,7 +105,7 @@ func (o *LogOptions) Validate(cmd *cobra.Command, args []string) error {
 func (o *LogOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	// Prepare namespace
 	var err error
-	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	o.namespace, o.targetNamespace, err = traceNamespaces(factory, o.traceNamespace)
 	if err != nil {
 		return err
 	}
@@ -116,6 +120,10 @@ func (o *LogOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args
 }
 
 func (o *LogOptions) Run() error {
+	if err := checkAccess(o.clientConfig, access.CommandLogs, access.LogsPermissions(o.namespace)); err != nil {
+		return err
+	}
+
 	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
 	if err != nil {
 		return err
@@ -131,8 +139,9 @@ func (o *LogOptions) Run() error {
 	}
 
 	tf := tracejob.TraceJobFilter{
-		Name: o.traceName,
-		ID:   o.traceID,
+		Name:            o.traceName,
+		ID:              o.traceID,
+		TargetNamespace: o.targetNamespace,
 	}
 
 	jobs, err := tc.GetJob(tf)
@@ -0,0 +1,227 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/iovisor/kubectl-trace/pkg/nodeinfo"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	nodeInfoShort = `Report what a node offers to bpftrace programs` // Wrap with i18n.T()
+	nodeInfoLong  = `Report what a node offers to bpftrace programs.
+
+The report is gathered by a short lived trace job on the node and includes the kernel version,
+whether BTF and linux headers are available, the lockdown mode, the cgroup version
+and the bpftrace version of the trace runner image.`
+
+	nodeInfoExamples = `
+  # Report about a specific node
+  %[1]s trace node-info node/kubernetes-node-emt8.c.myproject.internal
+
+  # Report about the node running a pod, as json
+  %[1]s trace node-info pod/nginx -o json`
+
+	nodeInfoCommand     = "node-info"
+	nodeInfoRequiredArg = fmt.Sprintf("%s is a required argument for the %s command", usageString, nodeInfoCommand)
+	nodeInfoOutputErr   = "the only supported output format is json"
+)
+
+// NodeInfoOptions ...
+type NodeInfoOptions struct {
+	genericclioptions.IOStreams
+
+	namespace string
+
+	// Flags local to this command
+	output         string
+	serviceAccount string
+	imageName      string
+
+	resourceArg string
+	target      *traceTarget
+
+	clientConfig *rest.Config
+}
+
+// NewNodeInfoOptions provides an instance of NodeInfoOptions with default values.
+func NewNodeInfoOptions(streams genericclioptions.IOStreams) *NodeInfoOptions {
+	return &NodeInfoOptions{
+		IOStreams: streams,
+
+		serviceAccount: "default",
+		imageName:      ImageName + ":" + ImageTag,
+	}
+}
+
+// NewNodeInfoCommand provides the node-info command wrapping NodeInfoOptions.
+func NewNodeInfoCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewNodeInfoOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          fmt.Sprintf("%s %s", nodeInfoCommand, usageString),
+		Short:        nodeInfoShort,
+		Long:         nodeInfoLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(nodeInfoExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format, one of: json")
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the kubectl-trace job")
+	cmd.Flags().StringVar(&o.imageName, "imagename", o.imageName, "Custom image for the tracerunner")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating NodeInfoOptions accordingly.
+func (o *NodeInfoOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf(nodeInfoRequiredArg)
+	}
+	o.resourceArg = args[0]
+
+	if len(o.output) > 0 && o.output != "json" {
+		return fmt.Errorf(nodeInfoOutputErr)
+	}
+
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *NodeInfoOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Look for the target object
+	o.target, err = resolveTarget(factory, o.namespace, o.resourceArg, "")
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the node-info command.
+func (o *NodeInfoOptions) Run() error {
+	// Only the node matters, not the pod running on it
+	target := &traceTarget{nodeName: o.target.nodeName}
+	r, err := gatherNodeInfo(o.clientConfig, o.namespace, o.serviceAccount, o.imageName, target, o.ErrOut)
+	if err != nil {
+		return err
+	}
+
+	if o.output == "json" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(o.Out, string(b))
+		return nil
+	}
+
+	nodeInfoPrint(o.Out, o.target.nodeName, r, nodeFacts(o.target.node, r), o.target.node.Status.NodeInfo.ContainerRuntimeVersion)
+	return nil
+}
+
+// gatherNodeInfo runs a short lived trace job on the target node to report about it.
+func gatherNodeInfo(clientConfig *rest.Config, namespace, serviceAccount, imageName string, target *traceTarget, errOut io.Writer) (*nodeinfo.Report, error) {
+	tj := newOneShotTraceJob(namespace, serviceAccount, imageName, target)
+	tj.NodeInfo = true
+
+	var out bytes.Buffer
+	streams := genericclioptions.IOStreams{Out: &out, ErrOut: errOut}
+	if err := runOneShotJob(clientConfig, tj, streams); err != nil {
+		return nil, err
+	}
+
+	return nodeinfo.Parse(out.String())
+}
+
+// nodeFacts merges what the node status says with what was detected on the node, the report can be nil.
+func nodeFacts(node *v1.Node, r *nodeinfo.Report) tracejob.NodeFacts {
+	facts := tracejob.NodeFacts{
+		KernelVersion: node.Status.NodeInfo.KernelVersion,
+		OSImage:       node.Status.NodeInfo.OSImage,
+	}
+	if r == nil {
+		return facts
+	}
+	facts.BTF = &r.BTF
+	facts.Headers = &r.Headers
+	if len(facts.KernelVersion) == 0 {
+		facts.KernelVersion = r.KernelVersion
+	}
+	return facts
+}
+
+func nodeInfoPrint(o io.Writer, nodeName string, r *nodeinfo.Report, facts tracejob.NodeFacts, runtimeVersion string) {
+	w := new(tabwriter.Writer)
+	// minwidth, tabwidth, padding, padchar, flags
+	w.Init(o, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+
+	mode, modeReason := tracejob.ResolveHeaderMode(facts)
+
+	// Tell the capabilities a trace without privileges gets on the node
+	caps, capsReason := tracejob.TraceCapabilities(facts.KernelVersion, runtimeVersion)
+	capNames := make([]string, len(caps))
+	for i, c := range caps {
+		capNames[i] = "CAP_" + string(c)
+	}
+
+	headers := "not available"
+	if r.Headers {
+		headers = fmt.Sprintf("available (%s)", r.HeadersPath)
+	}
+
+	fmt.Fprintf(w, "NODE:\t%s\n", nodeName)
+	fmt.Fprintf(w, "KERNEL:\t%s\n", r.KernelVersion)
+	fmt.Fprintf(w, "BTF:\t%s\n", availability(r.BTF))
+	fmt.Fprintf(w, "HEADERS:\t%s\n", headers)
+	fmt.Fprintf(w, "LOCKDOWN:\t%s\n", r.Lockdown)
+	fmt.Fprintf(w, "APPARMOR:\t%s\n", availability(r.AppArmor))
+	fmt.Fprintf(w, "CGROUP:\tv%d\n", r.CgroupVersion)
+	fmt.Fprintf(w, "BPFTRACE:\t%s\n", r.BpftraceVersion)
+	fmt.Fprintf(w, "HEADER MODE:\t%s (%s)\n", mode, modeReason)
+	fmt.Fprintf(w, "SECURITY PROFILE:\t%s with %s (%s)\n", tracejob.SecurityProfileCapabilities, strings.Join(capNames, ", "), capsReason)
+}
+
+func availability(b bool) string {
+	if b {
+		return "available"
+	}
+	return "not available"
+}
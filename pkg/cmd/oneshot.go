@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/iovisor/kubectl-trace/pkg/logs"
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/signals"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+// oneShotDeadline is the maximum time, in seconds, a job run only for its output is allowed to run
+const oneShotDeadline = int64(120)
+
+// newOneShotTraceJob prepares a trace job against target that is only needed for its output.
+func newOneShotTraceJob(namespace, serviceAccount, imageName string, target *traceTarget) tracejob.TraceJob {
+	juid := uuid.NewUUID()
+	return tracejob.TraceJob{
+		Name:           fmt.Sprintf("%s%s", meta.ObjectNamePrefix, string(juid)),
+		Namespace:      namespace,
+		ServiceAccount: serviceAccount,
+		ID:             juid,
+		Hostname:       target.nodeName,
+		PodUID:         target.podUID,
+		ContainerName:  target.container,
+		IsPod:          target.isPod,
+		ImageNameTag:   imageName,
+		Deadline:       oneShotDeadline,
+	}
+}
+
+// runOneShotJob creates the job, streams its output to streams.Out and deletes it once done.
+func runOneShotJob(clientConfig *rest.Config, tj tracejob.TraceJob, streams genericclioptions.IOStreams) error {
+	jobsClient, err := batchv1client.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+
+	coreClient, err := corev1client.NewForConfig(clientConfig)
+	if err != nil {
+		return err
+	}
+
+	tc := &tracejob.TraceJobClient{
+		JobClient:    jobsClient.Jobs(tj.Namespace),
+		ConfigClient: coreClient.ConfigMaps(tj.Namespace),
+	}
+	tc.WithOutStream(ioutil.Discard)
+
+	if _, err := tc.CreateJob(tj); err != nil {
+		return err
+	}
+	defer tc.DeleteJobs(tracejob.TraceJobFilter{ID: &tj.ID})
+
+	ctx := signals.WithStandardSignals(context.Background())
+	l := logs.NewLogs(coreClient, streams)
+	l.WithJobsClient(jobsClient)
+	return l.Stream(ctx, tj.ID, tj.Namespace)
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	probesShort = `List the probes available on a node or in a pod container binary` // Wrap with i18n.T()
+	probesLong  = probesShort
+
+	probesExamples = `
+  # List the kprobes matching a pattern on a specific node
+  %[1]s trace probes node/kubernetes-node-emt8.c.myproject.internal 'kprobe:tcp_*'
+
+  # List all the syscall tracepoints of the node running a pod
+  %[1]s trace probes pod/nginx 'tracepoint:syscalls:*'
+
+  # List the uprobes of a binary in a pod container
+  %[1]s trace probes pod/nginx -c nginx --binary /usr/sbin/nginx 'uprobe:ngx_http_*'
+
+  # List the USDT probes of a binary in a pod container as json
+  %[1]s trace probes pod/nginx --binary /usr/sbin/nginx 'usdt:*' -o json`
+
+	probesCommand             = "probes"
+	probesUsageString         = "(POD | TYPE/NAME) [PATTERN]"
+	probesRequiredArgErr      = fmt.Sprintf("%s is a required argument for the %s command", probesUsageString, probesCommand)
+	probesOutputErr           = "the only supported output format is json"
+	probesBinaryWithoutPodErr = "--binary can only be used when listing probes of a pod"
+	probesBinaryPatternErr    = "with --binary the pattern must be an uprobe, uretprobe or usdt pattern"
+)
+
+// binaryProbeTypes are the probe types that can be listed for a binary.
+var binaryProbeTypes = map[string]bool{
+	"uprobe":    true,
+	"u":         true,
+	"uretprobe": true,
+	"ur":        true,
+	"usdt":      true,
+	"U":         true,
+}
+
+// ProbesOptions ...
+type ProbesOptions struct {
+	genericclioptions.IOStreams
+
+	namespace string
+
+	// Flags local to this command
+	container      string
+	binary         string
+	output         string
+	serviceAccount string
+	imageName      string
+
+	resourceArg string
+	pattern     string
+	target      *traceTarget
+
+	clientConfig *rest.Config
+}
+
+// probe is a single probe in the json output.
+type probe struct {
+	Type  string `json:"type"`
+	Probe string `json:"probe"`
+}
+
+// NewProbesOptions provides an instance of ProbesOptions with default values.
+func NewProbesOptions(streams genericclioptions.IOStreams) *ProbesOptions {
+	return &ProbesOptions{
+		IOStreams: streams,
+
+		serviceAccount: "default",
+		imageName:      ImageName + ":" + ImageTag,
+	}
+}
+
+// NewProbesCommand provides the probes command wrapping ProbesOptions.
+func NewProbesCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewProbesOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          fmt.Sprintf("%s %s [-c CONTAINER] [--binary PATH]", probesCommand, probesUsageString),
+		Short:        probesShort,
+		Long:         probesLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(probesExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.container, "container", "c", o.container, "Specify the container")
+	cmd.Flags().StringVar(&o.binary, "binary", o.binary, "Path of a binary in the pod container to list the uprobes or USDT probes of")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format, one of: json")
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the kubectl-trace job")
+	cmd.Flags().StringVar(&o.imageName, "imagename", o.imageName, "Custom image for the tracerunner")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating ProbesOptions accordingly.
+func (o *ProbesOptions) Validate(cmd *cobra.Command, args []string) error {
+	switch len(args) {
+	case 1:
+		o.resourceArg = args[0]
+	case 2:
+		o.resourceArg = args[0]
+		o.pattern = args[1]
+	default:
+		return fmt.Errorf(probesRequiredArgErr)
+	}
+
+	if len(o.output) > 0 && o.output != "json" {
+		return fmt.Errorf(probesOutputErr)
+	}
+
+	if len(o.binary) == 0 {
+		if len(o.pattern) == 0 {
+			o.pattern = "*"
+		}
+		return nil
+	}
+
+	if !isPodResourceArg(o.resourceArg) {
+		return fmt.Errorf(probesBinaryWithoutPodErr)
+	}
+
+	// Point the pattern to the binary as seen from the host, through the container root
+	typ, rest := "uprobe", "*"
+	if len(o.pattern) > 0 {
+		parts := strings.SplitN(o.pattern, ":", 2)
+		typ = parts[0]
+		if len(parts) == 2 && len(parts[1]) > 0 {
+			rest = parts[1]
+		}
+	}
+	if !binaryProbeTypes[typ] {
+		return fmt.Errorf(probesBinaryPatternErr)
+	}
+	o.pattern = fmt.Sprintf("%s:/proc/$container_pid/root%s:%s", typ, o.binary, rest)
+
+	return nil
+}
+
+// Complete completes the setup of the command.
+func (o *ProbesOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+
+	// Look for the target object
+	o.target, err = resolveTarget(factory, o.namespace, o.resourceArg, o.container)
+	if err != nil {
+		return err
+	}
+
+	// Prepare client
+	o.clientConfig, err = factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Run executes the probes command.
+func (o *ProbesOptions) Run() error {
+	tj := newOneShotTraceJob(o.namespace, o.serviceAccount, o.imageName, o.target)
+	tj.ProbePattern = o.pattern
+
+	if o.output != "json" {
+		return runOneShotJob(o.clientConfig, tj, o.IOStreams)
+	}
+
+	var out bytes.Buffer
+	streams := o.IOStreams
+	streams.Out = &out
+	if err := runOneShotJob(o.clientConfig, tj, streams); err != nil {
+		return err
+	}
+
+	probes := []probe{}
+	for _, l := range strings.Split(out.String(), "\n") {
+		l = strings.TrimSpace(l)
+		if len(l) == 0 {
+			continue
+		}
+		// Anything that is not a probe is a message from bpftrace
+		i := strings.Index(l, ":")
+		if i <= 0 || strings.ContainsAny(l, " \t") {
+			fmt.Fprintln(o.ErrOut, l)
+			continue
+		}
+		probes = append(probes, probe{Type: l[:i], Probe: l})
+	}
+
+	b, err := json.MarshalIndent(probes, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(o.Out, string(b))
+	return nil
+}
@@ -4,16 +4,21 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"strings"
+	"time"
 
+	"github.com/iovisor/kubectl-trace/pkg/access"
 	"github.com/iovisor/kubectl-trace/pkg/attacher"
+	"github.com/iovisor/kubectl-trace/pkg/lint"
+	"github.com/iovisor/kubectl-trace/pkg/logs"
 	"github.com/iovisor/kubectl-trace/pkg/meta"
 	"github.com/iovisor/kubectl-trace/pkg/signals"
 	"github.com/iovisor/kubectl-trace/pkg/tracejob"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
-	"k8s.io/client-go/kubernetes/scheme"
 	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
@@ -56,9 +61,45 @@ var (
   %[1]s trace run pod/nginx nginx -e "tracepoint:syscalls:sys_enter_* { @[probe] = count(); } --init-imagename=quay.io/custom-init-image-name --fetch-headers"
 
   # Run a bpftrace inline program on a pod container with a custom image for the bpftrace container that will run your program in the cluster
-  %[1]s trace run pod/nginx nginx -e "tracepoint:syscalls:sys_enter_* { @[probe] = count(); } --imagename=quay.io/custom-bpftrace-image-name"`
+  %[1]s trace run pod/nginx nginx -e "tracepoint:syscalls:sys_enter_* { @[probe] = count(); } --imagename=quay.io/custom-bpftrace-image-name"
+
+  # Run a bpftrace program on a specific node, fetching linux headers from an internal mirror
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --headers=fetch --headers-mirror='https://mirror.internal/linux/v{major}.x/linux-{version}.tar.gz'
+
+  # Run a bpftrace program on a specific node, picking how to provide linux headers from what the node offers
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --headers=auto
+
+  # Run a bpftrace program on a specific node with only the capabilities it needs instead of a privileged container
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --security-profile=capabilities
+
+  # Run a bpftrace program on a pod of the payments namespace, creating the trace in a dedicated namespace
+  %[1]s trace run -n payments pod/checkout -f read.bt --trace-namespace=kubectl-trace-system
+
+  # Run a bpftrace program on a specific node with more memory, at a high priority, tolerating all the taints
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --limits=memory=2G --priority-class=system-node-critical --toleration=:NoExecute
+
+  # Print the job and the config map a trace would be created with, patched, without creating them
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --patch=mypatch.yaml --patch-type=merge --dry-run=client -o yaml
+
+  # Have the API server validate a trace, admission webhooks and pod security included, without creating it
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --security-profile=capabilities --dry-run=server
+
+  # Run a bpftrace program on a pod container, tracing the container again each time it crashes and restarts
+  %[1]s trace run pod/nginx -c nginx -f read.bt --follow-restarts
+
+  # Trace the next pod of the api deployment from the start of its app container, watching the programs it executes
+  %[1]s trace run -l app=api -c app --wait-for-pod -e 'tracepoint:syscalls:sys_enter_execve /cgroup == $container_cgroup/ { printf("%%s\n", str(args->filename)); }'
+
+  # Run a bpftrace program on a specific node and wait for it to finish, printing program errors if any
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -f read.bt --wait
+
+  # Run a bpftrace program on a specific node for 30 seconds, printing its output and its maps once done
+  %[1]s trace run node/kubernetes-node-emt8.c.myproject.internal -e 'tracepoint:syscalls:sys_enter_* { @[probe] = count(); }' --duration 30s`
 
 	runCommand                             = "run"
+	dryRunNone                             = "none"
+	dryRunClient                           = "client"
+	dryRunServer                           = "server"
 	usageString                            = "(POD | TYPE/NAME)"
 	requiredArgErrString                   = fmt.Sprintf("%s is a required argument for the %s command", usageString, runCommand)
 	containerAsArgOrFlagErrString          = "specify container inline as argument or via its flag"
@@ -67,6 +108,23 @@ var (
 	bpftraceEmptyErrString                 = "the bpftrace programm cannot be empty"
 	bpftracePatchWithoutTypeErrString      = "to use --patch you must also specify the --patch-type argument"
 	bpftracePatchTypeWithoutPatchErrString = "to use --patch-type you must specify the --patch argument"
+	attachAndWaitErrString                 = "specify either --attach or --wait, not both"
+	fetchHeadersModeErrString              = "--fetch-headers can only be used with --headers=fetch"
+	fetchAndDetectHeadersErrString         = "specify either --fetch-headers or --detect-headers, not both"
+	detectHeadersModeErrString             = "--detect-headers can only be used with --headers=auto"
+	bpftraceLintErrString                  = "the bpftrace program has errors (use --skip-lint to submit it anyway):"
+	dryRunErrString                        = "--dry-run must be one of none, client, server"
+	dryRunOutputErrString                  = "--output can only be yaml, along with --dry-run"
+	dryRunAttachErrString                  = "--attach and --wait cannot be used along with --dry-run"
+	durationDeadlineErrString              = "specify either --duration or --deadline, not both"
+	durationAttachErrString                = "--attach and --wait cannot be used along with --duration, which already prints the output of the trace"
+	durationDryRunErrString                = "--duration cannot be used along with --dry-run"
+	followRestartsErrString                = "--follow-restarts can only be used when tracing a pod"
+	selectorWithoutWaitErrString           = "--selector can only be used along with --wait-for-pod"
+	waitForPodTargetErrString              = "--wait-for-pod takes either a pod or a --selector"
+	waitForPodDryRunErrString              = "--wait-for-pod cannot be used along with --dry-run"
+	waitForPodFollowErrString              = "--follow-restarts is not needed with --wait-for-pod, which traces all the containers of the pod, restarts included"
+	waitForPodCapabilitiesErrString        = "--wait-for-pod cannot be used along with --security-profile=capabilities, which does not mount the cgroups of the node the pod is waited for in"
 )
 
 // RunOptions ...
@@ -75,6 +133,7 @@ type RunOptions struct {
 
 	namespace         string
 	explicitNamespace bool
+	traceNamespace    string
 
 	// Flags local to this command
 	container           string
@@ -84,18 +143,47 @@ type RunOptions struct {
 	imageName           string
 	initImageName       string
 	fetchHeaders        bool
+	detectHeaders       bool
+	headers             string
+	headersSource       tracejob.HeadersSource
+	securityProfile     string
+	validate            bool
+	followRestarts      bool
+	waitForPod          bool
+	selector            string
+	skipLint            bool
 	deadline            int64
 	deadlineGracePeriod int64
 
 	resourceArg string
 	attach      bool
+	wait        bool
+	duration    int64
 	isPod       bool
 	podUID      string
 	nodeName    string
+	target      string
+	node        *v1.Node
+	args        []string
 
 	patch     string
 	patchType string
 
+	dryRun string
+	output string
+
+	requests         string
+	limits           string
+	priorityClass    string
+	tolerations      []string
+	imagePullSecrets []string
+	imagePullPolicy  string
+	labels           []string
+	annotations      []string
+	env              []string
+	hostMounts       []string
+	podOptions       tracejob.PodOptions
+
 	clientConfig *rest.Config
 }
 
@@ -107,6 +195,9 @@ func NewRunOptions(streams genericclioptions.IOStreams) *RunOptions {
 		serviceAccount:      "default",
 		imageName:           ImageName + ":" + ImageTag,
 		initImageName:       InitImageName + ":" + InitImageTag,
+		headers:             string(tracejob.HeaderModeHost),
+		securityProfile:     string(tracejob.SecurityProfilePrivileged),
+		validate:            true,
 		deadline:            int64(DefaultDeadline),
 		deadlineGracePeriod: int64(DefaultDeadlineGracePeriod),
 	}
@@ -139,16 +230,46 @@ func NewRunCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams)
 
 	cmd.Flags().StringVarP(&o.container, "container", "c", o.container, "Specify the container")
 	cmd.Flags().BoolVarP(&o.attach, "attach", "a", o.attach, "Whether or not to attach to the trace program once it is created")
+	cmd.Flags().BoolVar(&o.wait, "wait", o.wait, "Whether or not to wait for the trace program to finish and report its outcome")
 	cmd.Flags().StringVarP(&o.eval, "eval", "e", o.eval, "Literal string to be evaluated as a bpftrace program")
 	cmd.Flags().StringVarP(&o.program, "filename", "f", o.program, "File containing a bpftrace program")
+	cmd.Flags().StringArrayVar(&o.args, "arg", o.args, "Positional parameter of the bpftrace program, $1 being the first one, can be repeated")
 	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the kubectl-trace job")
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace to create the trace in, instead of the namespace of the target")
 	cmd.Flags().StringVar(&o.imageName, "imagename", o.imageName, "Custom image for the tracerunner")
 	cmd.Flags().StringVar(&o.initImageName, "init-imagename", o.initImageName, "Custom image for the init container responsible to fetch and prepare linux headers")
-	cmd.Flags().BoolVar(&o.fetchHeaders, "fetch-headers", o.fetchHeaders, "Whether to fetch linux headers or not")
-	cmd.Flags().Int64Var(&o.deadline, "deadline", o.deadline, "Maximum time to allow trace to run in seconds")
-	cmd.Flags().Int64Var(&o.deadlineGracePeriod, "deadline-grace-period", o.deadlineGracePeriod, "Maximum wait time to print maps or histograms after deadline, in seconds")
+	cmd.Flags().BoolVar(&o.fetchHeaders, "fetch-headers", o.fetchHeaders, "Whether to fetch linux headers or not, same as --headers=fetch")
+	cmd.Flags().StringVar(&o.headers, "headers", o.headers, "How to provide linux headers: auto, btf, host or fetch")
+	cmd.Flags().BoolVar(&o.detectHeaders, "detect-headers", o.detectHeaders, "Gather node information first and pick how to provide linux headers, same as --headers=auto")
+	cmd.Flags().MarkDeprecated("detect-headers", "use --headers=auto instead")
+	cmd.Flags().StringVar(&o.headersSource.MirrorURL, "headers-mirror", o.headersSource.MirrorURL, "URL template of a mirror to fetch the kernel sources from, with {release}, {version}, {major} and {build_id} placeholders")
+	cmd.Flags().StringVar(&o.headersSource.Image, "headers-image", o.headersSource.Image, "Image with the kernel sources or prepared headers in /linux-headers to fetch the headers from, {release} is replaced with the node kernel release")
+	cmd.Flags().StringVar(&o.headersSource.PVC, "headers-pvc", o.headersSource.PVC, "Persistent volume claim with the kernel sources or prepared headers to fetch the headers from")
+	cmd.Flags().StringVar(&o.headersSource.HostPath, "headers-hostpath", o.headersSource.HostPath, "Directory of the node with the kernel sources or prepared headers to fetch the headers from")
+	cmd.Flags().StringVar(&o.securityProfile, "security-profile", o.securityProfile, "How much privilege the trace runs with: privileged, or capabilities to run unprivileged with only the capabilities bpftrace needs")
+	cmd.Flags().BoolVar(&o.validate, "validate", o.validate, "Whether to check the program with a bpftrace dry run on the node before starting the trace")
+	cmd.Flags().BoolVar(&o.followRestarts, "follow-restarts", o.followRestarts, "Whether to trace the container again each time it restarts, until the deadline")
+	cmd.Flags().BoolVar(&o.waitForPod, "wait-for-pod", o.waitForPod, "Whether to wait for the pod to be scheduled, and for its container to start, to trace it from its start")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", o.selector, "Selector of the pod to wait for with --wait-for-pod, instead of its name")
+	cmd.Flags().BoolVar(&o.skipLint, "skip-lint", o.skipLint, "Whether to skip checking the program for errors before submitting it")
+	cmd.Flags().Var(newSecondsValue(o.deadline, &o.deadline), "deadline", "Maximum time to allow trace to run, like 90s, 15m or 2h, or in seconds")
+	cmd.Flags().Var(newSecondsValue(o.deadlineGracePeriod, &o.deadlineGracePeriod), "deadline-grace-period", "Maximum wait time to print maps or histograms after deadline, like 30s, or in seconds")
+	cmd.Flags().Var(newSecondsValue(o.duration, &o.duration), "duration", "Run the trace exactly this long, like 30s, printing its output and its maps once done")
 	cmd.Flags().StringVar(&o.patch, "patch", "", "path of YAML or JSON file used to patch the job definition before creation")
 	cmd.Flags().StringVar(&o.patchType, "patch-type", "", "patch strategy to use: json, merge, or strategic")
+	cmd.Flags().StringVar(&o.dryRun, "dry-run", o.dryRun, "Only print the objects of the trace instead of creating them: none, client, or server to have the API server validate them")
+	cmd.Flags().Lookup("dry-run").NoOptDefVal = dryRunClient
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format of --dry-run, only yaml is supported")
+	cmd.Flags().StringVar(&o.requests, "requests", o.requests, "Resource requests of the trace containers, like cpu=100m,memory=100Mi")
+	cmd.Flags().StringVar(&o.limits, "limits", o.limits, "Resource limits of the trace containers, like cpu=1,memory=1G")
+	cmd.Flags().StringVar(&o.priorityClass, "priority-class", o.priorityClass, "Priority class of the trace pod")
+	cmd.Flags().StringSliceVar(&o.tolerations, "toleration", o.tolerations, "Additional toleration of the trace pod, as key=value:Effect, key:Effect or :Effect to tolerate all the taints with the effect")
+	cmd.Flags().StringSliceVar(&o.imagePullSecrets, "image-pull-secret", o.imagePullSecrets, "Secret to pull the trace images with")
+	cmd.Flags().StringVar(&o.imagePullPolicy, "image-pull-policy", o.imagePullPolicy, "Pull policy of the trace images: Always, IfNotPresent or Never")
+	cmd.Flags().StringArrayVar(&o.labels, "label", o.labels, "Additional label of the trace, as key=value, can be repeated")
+	cmd.Flags().StringArrayVar(&o.annotations, "annotation", o.annotations, "Additional annotation of the trace, as key=value, can be repeated")
+	cmd.Flags().StringArrayVar(&o.env, "env", o.env, "Environment variable of the trace container, as NAME=value, can be repeated")
+	cmd.Flags().StringSliceVar(&o.hostMounts, "host-mount", o.hostMounts, "Directory of the node to mount read-only in the trace container, as /host/path or /host/path:/mount/path")
 
 	return cmd
 }
@@ -157,6 +278,11 @@ func NewRunCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams)
 func (o *RunOptions) Validate(cmd *cobra.Command, args []string) error {
 	containerFlagDefined := cmd.Flag("container").Changed
 	switch len(args) {
+	case 0:
+		// The pod waited for can be selected by its labels
+		if !o.waitForPod || len(o.selector) == 0 {
+			return fmt.Errorf(requiredArgErrString)
+		}
 	case 1:
 		o.resourceArg = args[0]
 		break
@@ -182,22 +308,35 @@ func (o *RunOptions) Validate(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf(bpftraceEmptyErrString)
 	}
 
-	havePatch := cmd.Flag("patch").Changed
-	havePatchType := cmd.Flag("patch-type").Changed
-
-	if havePatch && !havePatchType {
-		return fmt.Errorf(bpftracePatchWithoutTypeErrString)
+	if cmd.Flag("duration").Changed {
+		if cmd.Flag("deadline").Changed {
+			return fmt.Errorf(durationDeadlineErrString)
+		}
+		if o.duration <= 0 {
+			return fmt.Errorf("--duration must be positive")
+		}
+		o.deadline = o.duration
 	}
 
-	if !havePatch && havePatchType {
-		return fmt.Errorf(bpftracePatchTypeWithoutPatchErrString)
+	if o.fetchHeaders {
+		if cmd.Flag("headers").Changed && o.headers != string(tracejob.HeaderModeFetch) {
+			return fmt.Errorf(fetchHeadersModeErrString)
+		}
+		o.headers = string(tracejob.HeaderModeFetch)
+	}
+	if o.detectHeaders {
+		if o.fetchHeaders {
+			return fmt.Errorf(fetchAndDetectHeadersErrString)
+		}
+		if cmd.Flag("headers").Changed && o.headers != string(tracejob.HeaderModeAuto) {
+			return fmt.Errorf(detectHeadersModeErrString)
+		}
+		o.headers = string(tracejob.HeaderModeAuto)
+	}
+	if err := o.parsePodOptions(); err != nil {
+		return err
 	}
 
-	return nil
-}
-
-// Complete completes the setup of the command.
-func (o *RunOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
 	// Prepare program
 	if len(o.program) > 0 {
 		b, err := ioutil.ReadFile(o.program)
@@ -209,90 +348,198 @@ func (o *RunOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args
 		o.program = o.eval
 	}
 
-	// Prepare namespace
-	var err error
-	o.namespace, o.explicitNamespace, err = factory.ToRawKubeConfigLoader().Namespace()
-	if err != nil {
-		return err
+	return o.validateOptions()
+}
+
+// validateOptions validates the options however they are given, with flags or in a trace spec.
+func (o *RunOptions) validateOptions() error {
+	// Either can come from the config file
+	havePatch := len(o.patch) > 0
+	havePatchType := len(o.patchType) > 0
+
+	if havePatch && !havePatchType {
+		return fmt.Errorf(bpftracePatchWithoutTypeErrString)
 	}
 
-	// Look for the target object
-	x := factory.
-		NewBuilder().
-		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
-		NamespaceParam(o.namespace).
-		SingleResourceType().
-		ResourceNames("nodes", o.resourceArg). // Search nodes by default
-		Do()
+	if !havePatch && havePatchType {
+		return fmt.Errorf(bpftracePatchTypeWithoutPatchErrString)
+	}
 
-	obj, err := x.Object()
-	if err != nil {
-		return err
+	if o.attach && o.wait {
+		return fmt.Errorf(attachAndWaitErrString)
 	}
 
-	// Check we got a pod or a node
-	o.isPod = false
+	switch o.dryRun {
+	case "", dryRunNone, dryRunClient, dryRunServer:
+	default:
+		return fmt.Errorf(dryRunErrString)
+	}
+	if len(o.output) > 0 && (o.output != "yaml" || !o.isDryRun()) {
+		return fmt.Errorf(dryRunOutputErrString)
+	}
+	if o.isDryRun() && (o.attach || o.wait) {
+		return fmt.Errorf(dryRunAttachErrString)
+	}
+	if o.duration > 0 && (o.attach || o.wait) {
+		return fmt.Errorf(durationAttachErrString)
+	}
+	if o.duration > 0 && o.isDryRun() {
+		return fmt.Errorf(durationDryRunErrString)
+	}
 
-	var node *v1.Node
+	if o.followRestarts && !o.tracesPod() {
+		return fmt.Errorf(followRestartsErrString)
+	}
+	if len(o.selector) > 0 && !o.waitForPod {
+		return fmt.Errorf(selectorWithoutWaitErrString)
+	}
+	if o.waitForPod && (len(o.selector) > 0) == (len(o.resourceArg) > 0) {
+		return fmt.Errorf(waitForPodTargetErrString)
+	}
+	if o.waitForPod && len(o.resourceArg) > 0 && strings.Contains(o.resourceArg, "/") && !isPodResourceArg(o.resourceArg) {
+		return fmt.Errorf(waitForPodTargetErrString)
+	}
+	if o.waitForPod && o.isDryRun() {
+		return fmt.Errorf(waitForPodDryRunErrString)
+	}
+	if o.waitForPod && o.followRestarts {
+		return fmt.Errorf(waitForPodFollowErrString)
+	}
 
-	switch v := obj.(type) {
-	case *v1.Pod:
-		if len(v.Spec.NodeName) == 0 {
-			return fmt.Errorf("cannot attach a trace program to a pod that is not currently scheduled on a node")
-		}
-		o.isPod = true
-		found := false
-		o.podUID = string(v.UID)
-		for _, c := range v.Spec.Containers {
-			// default if no container provided
-			if len(o.container) == 0 {
-				o.container = c.Name
-				found = true
-				break
-			}
-			// check if the provided one exists
-			if c.Name == o.container {
-				found = true
-				break
+	headers, err := tracejob.ParseHeaderMode(o.headers)
+	if err != nil {
+		return err
+	}
+	if err := o.headersSource.ValidateFor(headers); err != nil {
+		return err
+	}
+	profile, err := tracejob.ParseSecurityProfile(o.securityProfile)
+	if err != nil {
+		return err
+	}
+	if o.waitForPod && profile == tracejob.SecurityProfileCapabilities {
+		return fmt.Errorf(waitForPodCapabilitiesErrString)
+	}
+	if err := o.podOptions.Validate(); err != nil {
+		return err
+	}
+
+	if !o.skipLint {
+		problems := lint.Lint(o.program, lint.Options{InPod: o.tracesPod(), BeforeContainer: o.waitForPod, Args: len(o.args)})
+		if len(problems) > 0 {
+			msg := bpftraceLintErrString
+			for _, p := range problems {
+				msg += "\n  " + p.String()
 			}
+			return fmt.Errorf("%s", msg)
 		}
+	}
 
-		if !found {
-			return fmt.Errorf("no containers found for the provided pod/container combination")
-		}
+	return nil
+}
 
-		obj, err = factory.
-			NewBuilder().
-			WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
-			ResourceNames("nodes", v.Spec.NodeName).
-			Do().Object()
+// tracesPod tells whether the trace runs against a pod, waited for or not.
+func (o *RunOptions) tracesPod() bool {
+	return o.waitForPod || isPodResourceArg(o.resourceArg)
+}
 
+// isDryRun tells whether the trace is only to be printed.
+func (o *RunOptions) isDryRun() bool {
+	return o.dryRun == dryRunClient || o.dryRun == dryRunServer
+}
+
+// parsePodOptions parses the flags customizing the trace pod.
+func (o *RunOptions) parsePodOptions() error {
+	var err error
+	po := tracejob.PodOptions{
+		PriorityClassName: o.priorityClass,
+		ImagePullSecrets:  o.imagePullSecrets,
+		ImagePullPolicy:   v1.PullPolicy(o.imagePullPolicy),
+	}
+	if po.Requests, err = tracejob.ParseResourceList(o.requests); err != nil {
+		return err
+	}
+	if po.Limits, err = tracejob.ParseResourceList(o.limits); err != nil {
+		return err
+	}
+	for _, s := range o.tolerations {
+		t, err := tracejob.ParseToleration(s)
 		if err != nil {
 			return err
 		}
+		po.Tolerations = append(po.Tolerations, t)
+	}
+	if po.Labels, err = tracejob.ParseKeyValues(o.labels); err != nil {
+		return err
+	}
+	if po.Annotations, err = tracejob.ParseKeyValues(o.annotations); err != nil {
+		return err
+	}
+	// The environment keeps the order it is given in
+	if _, err := tracejob.ParseKeyValues(o.env); err != nil {
+		return err
+	}
+	for _, kv := range o.env {
+		parts := strings.SplitN(kv, "=", 2)
+		po.Env = append(po.Env, v1.EnvVar{Name: parts[0], Value: parts[1]})
+	}
+	for _, s := range o.hostMounts {
+		m, err := tracejob.ParseHostMount(s)
+		if err != nil {
+			return err
+		}
+		po.HostMounts = append(po.HostMounts, m)
+	}
+
+	o.podOptions = po
+	return nil
+}
 
-		if n, ok := obj.(*v1.Node); ok {
-			node = n
+// isPodResourceArg tells whether the resource argument refers to a pod, the default being a node.
+func isPodResourceArg(arg string) bool {
+	for _, prefix := range []string{"pod/", "pods/", "po/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
 		}
+	}
+	return false
+}
 
-		break
-	case *v1.Node:
-		node = v
-		break
-	default:
-		return fmt.Errorf("first argument must be %s", usageString)
+// Complete completes the setup of the command.
+func (o *RunOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, o.explicitNamespace, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
 	}
 
-	if node == nil {
-		return fmt.Errorf("could not determine on which node to run the trace program")
+	return o.completeTarget(factory)
+}
+
+// completeTarget looks up the target in the namespace and prepares the client.
+func (o *RunOptions) completeTarget(factory cmdutil.Factory) error {
+	if len(o.traceNamespace) == 0 {
+		o.traceNamespace = o.namespace
 	}
 
-	labels := node.GetLabels()
-	val, ok := labels["kubernetes.io/hostname"]
-	if !ok {
-		return fmt.Errorf("label kubernetes.io/hostname not found in node")
+	// Look for the target object, or wait for it
+	var t *traceTarget
+	var err error
+	if o.waitForPod {
+		t, err = o.waitForTarget(factory)
+	} else {
+		t, err = resolveTarget(factory, o.namespace, o.resourceArg, o.container)
 	}
-	o.nodeName = val
+	if err != nil {
+		return err
+	}
+	o.isPod = t.isPod
+	o.podUID = t.podUID
+	o.container = t.container
+	o.nodeName = t.nodeName
+	o.target = t.resource
+	o.node = t.node
 
 	// Prepare client
 	o.clientConfig, err = factory.ToRESTConfig()
@@ -303,8 +550,73 @@ func (o *RunOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args
 	return nil
 }
 
+// waitForTarget waits for the pod given by name or selector to be scheduled, and returns it as the target.
+func (o *RunOptions) waitForTarget(factory cmdutil.Factory) (*traceTarget, error) {
+	client, err := factory.KubernetesClientSet()
+	if err != nil {
+		return nil, err
+	}
+	clientConfig, err := factory.ToRESTConfig()
+	if err != nil {
+		return nil, err
+	}
+	// Better know now than once the pod is there that the trace cannot be created
+	perms := append(o.runPermissions(), access.Permission{Verb: "list", Resource: "pods", Namespace: o.namespace})
+	if err := checkAccess(clientConfig, access.CommandRun, perms); err != nil {
+		return nil, err
+	}
+
+	name := o.resourceArg
+	for _, prefix := range []string{"pod/", "pods/", "po/"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	what := "pod " + name
+	if len(o.selector) > 0 {
+		what = "a pod matching " + o.selector
+	}
+	fmt.Fprintf(o.ErrOut, "waiting for %s to be scheduled\n", what)
+
+	ctx := signals.WithStandardSignals(context.Background())
+	pod, err := waitForPod(ctx, client.CoreV1().Pods(o.namespace), name, o.selector, o.container)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(o.ErrOut, "pod %s scheduled on node %s\n", pod.Name, pod.Spec.NodeName)
+	return podTarget(factory, pod, o.container)
+}
+
+// resolveHeaderMode picks the header mode from the node status and from what a node-info job detects on it.
+// When the job fails, the decision is taken on the node status alone.
+func (o *RunOptions) resolveHeaderMode() (tracejob.HeaderMode, string) {
+	r, err := gatherNodeInfo(o.clientConfig, o.traceNamespace, o.serviceAccount, o.imageName, &traceTarget{nodeName: o.nodeName}, o.ErrOut)
+	if err != nil {
+		fmt.Fprintf(o.ErrOut, "could not gather node information: %s\n", err)
+	}
+	return tracejob.ResolveHeaderMode(nodeFacts(o.node, r))
+}
+
+// runPermissions returns what creating the trace, and following it as asked, needs.
+func (o *RunOptions) runPermissions() []access.Permission {
+	perms := access.RunPermissions(o.traceNamespace, o.attach, o.wait || o.duration > 0)
+	if o.duration > 0 {
+		perms = append(perms, access.LogsPermissions(o.traceNamespace)...)
+	}
+	// The header mode is picked from what a node-info job finds, unless there is no time to run one
+	if o.headers == string(tracejob.HeaderModeAuto) && !o.isDryRun() && !o.waitForPod {
+		perms = append(perms, access.NodeInfoPermissions(o.traceNamespace)...)
+	}
+	return perms
+}
+
 // Run executes the run command.
 func (o *RunOptions) Run() error {
+	// A client dry run creates nothing, there is no permission to check
+	if o.dryRun != dryRunClient {
+		if err := checkAccess(o.clientConfig, access.CommandRun, o.runPermissions()); err != nil {
+			return err
+		}
+	}
+
 	juid := uuid.NewUUID()
 	jobsClient, err := batchv1client.NewForConfig(o.clientConfig)
 	if err != nil {
@@ -317,29 +629,85 @@ func (o *RunOptions) Run() error {
 	}
 
 	tc := &tracejob.TraceJobClient{
-		JobClient:    jobsClient.Jobs(o.namespace),
-		ConfigClient: coreClient.ConfigMaps(o.namespace),
+		JobClient:    jobsClient.Jobs(o.traceNamespace),
+		ConfigClient: coreClient.ConfigMaps(o.traceNamespace),
+		PodClient:    coreClient.Pods(o.traceNamespace),
+	}
+
+	headers, headersReason := tracejob.HeaderMode(o.headers), ""
+	if headers == tracejob.HeaderModeAuto {
+		if o.isDryRun() {
+			// Gathering node information would run a job on the node, decide on the node status alone,
+			// and keep the output for the objects
+			headers, headersReason = tracejob.ResolveHeaderMode(nodeFacts(o.node, nil))
+			fmt.Fprintf(o.IOStreams.ErrOut, "using %s headers: %s\n", headers, headersReason)
+		} else if o.waitForPod {
+			// Nor wait for it while the pod starts
+			headers, headersReason = tracejob.ResolveHeaderMode(nodeFacts(o.node, nil))
+			fmt.Fprintf(o.IOStreams.Out, "using %s headers: %s\n", headers, headersReason)
+		} else {
+			headers, headersReason = o.resolveHeaderMode()
+			fmt.Fprintf(o.IOStreams.Out, "using %s headers: %s\n", headers, headersReason)
+		}
+	}
+	// The source is only for fetching, the auto mode may have picked another way
+	headersSource := o.headersSource
+	if headers != tracejob.HeaderModeFetch {
+		headersSource = tracejob.HeadersSource{}
+	}
+
+	targetNamespace := ""
+	if o.isPod {
+		targetNamespace = o.namespace
 	}
 
 	tj := tracejob.TraceJob{
 		Name:                fmt.Sprintf("%s%s", meta.ObjectNamePrefix, string(juid)),
-		Namespace:           o.namespace,
+		Namespace:           o.traceNamespace,
+		TargetNamespace:     targetNamespace,
 		ServiceAccount:      o.serviceAccount,
 		ID:                  juid,
 		Hostname:            o.nodeName,
+		Target:              o.target,
 		Program:             o.program,
+		Args:                o.args,
 		PodUID:              o.podUID,
 		ContainerName:       o.container,
 		IsPod:               o.isPod,
 		ImageNameTag:        o.imageName,
 		InitImageNameTag:    o.initImageName,
-		FetchHeaders:        o.fetchHeaders,
+		Headers:             headers,
+		HeadersReason:       headersReason,
+		HeadersSource:       headersSource,
+		KernelVersion:       o.node.Status.NodeInfo.KernelVersion,
+		RuntimeVersion:      o.node.Status.NodeInfo.ContainerRuntimeVersion,
+		SecurityProfile:     tracejob.SecurityProfile(o.securityProfile),
+		AppArmor:            appArmorEnabled(o.node),
+		Validate:            o.validate,
+		FollowRestarts:      o.followRestarts,
+		WaitForContainer:    o.waitForPod,
 		Deadline:            o.deadline,
 		DeadlineGracePeriod: o.deadlineGracePeriod,
+		PodOptions:          o.podOptions,
 		Patch:               o.patch,
 		PatchType:           o.patchType,
 	}
 
+	switch o.dryRun {
+	case dryRunClient:
+		job, cm, err := tracejob.RenderJob(tj)
+		if err != nil {
+			return err
+		}
+		return printDryRun(o.IOStreams, []runtime.Object{cm, job}, o.output)
+	case dryRunServer:
+		job, cm, err := tc.DryRunJob(tj)
+		if err != nil {
+			return err
+		}
+		return printDryRun(o.IOStreams, []runtime.Object{cm, job}, o.output)
+	}
+
 	job, err := tc.CreateJob(tj)
 	if err != nil {
 		return err
@@ -347,13 +715,41 @@ func (o *RunOptions) Run() error {
 
 	fmt.Fprintf(o.IOStreams.Out, "trace %s created\n", tj.ID)
 
+	ctx := signals.WithStandardSignals(context.Background())
 	if o.attach {
-		ctx := context.Background()
-		ctx = signals.WithStandardSignals(ctx)
 		a := attacher.NewAttacher(coreClient, o.clientConfig, o.IOStreams)
 		a.WithContext(ctx)
 		a.AttachJob(tj.ID, job.Namespace)
 	}
 
+	if o.duration > 0 {
+		// The trace is interrupted at its deadline and prints its maps, its whole output is streamed
+		// before reporting its outcome
+		fmt.Fprintf(o.IOStreams.ErrOut, "tracing for %s\n", time.Duration(o.duration)*time.Second)
+		l := logs.NewLogs(coreClient, o.IOStreams)
+		l.WithJobsClient(jobsClient)
+		if err := l.Stream(ctx, tj.ID, job.Namespace); err != nil {
+			return err
+		}
+	}
+
+	if o.wait || o.duration > 0 {
+		res, err := tc.WaitJob(ctx, tracejob.TraceJobFilter{ID: &tj.ID})
+		if err != nil {
+			return err
+		}
+		if res.Status == tracejob.TraceJobFailed {
+			if res.TerminationMessage != nil {
+				return fmt.Errorf("trace %s failed: %s", tj.ID, res.TerminationMessage)
+			}
+			return fmt.Errorf("trace %s failed", tj.ID)
+		}
+		if res.TerminationMessage != nil {
+			fmt.Fprintf(o.IOStreams.Out, "trace %s completed: %s\n", tj.ID, res.TerminationMessage.Summary())
+		} else {
+			fmt.Fprintf(o.IOStreams.Out, "trace %s completed\n", tj.ID)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/access"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/stretchr/testify/assert"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+)
+
+func TestRunPermissionsAutoHeaders(t *testing.T) {
+	nodeInfo := access.Permission{Verb: "get", Resource: "pods", Subresource: "log", Namespace: "default"}
+
+	o := NewRunOptions(genericclioptions.NewTestIOStreamsDiscard())
+	o.traceNamespace = "default"
+	assert.NotContains(t, o.runPermissions(), nodeInfo)
+
+	o.headers = string(tracejob.HeaderModeAuto)
+	assert.Subset(t, o.runPermissions(), access.NodeInfoPermissions("default"))
+
+	// A dry run decides on the node status alone
+	o.dryRun = dryRunServer
+	assert.NotContains(t, o.runPermissions(), nodeInfo)
+}
+
+func TestValidateWaitForPodCapabilities(t *testing.T) {
+	o := NewRunOptions(genericclioptions.NewTestIOStreamsDiscard())
+	o.resourceArg = "pod/api-1"
+	o.waitForPod = true
+	o.program = "tracepoint:syscalls:sys_enter_openat { @[comm] = count(); }"
+	o.securityProfile = string(tracejob.SecurityProfileCapabilities)
+	assert.EqualError(t, o.validateOptions(), waitForPodCapabilitiesErrString)
+
+	o.securityProfile = string(tracejob.SecurityProfilePrivileged)
+	assert.Nil(t, o.validateOptions())
+}
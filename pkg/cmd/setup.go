@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/iovisor/kubectl-trace/pkg/setup"
+	"github.com/iovisor/kubectl-trace/pkg/version"
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	setupShort = `Set up a namespace, service account and RBAC for kubectl trace` // Wrap with i18n.T()
+	setupLong  = `Set up a namespace, service account and RBAC for kubectl trace.
+
+Creates, or updates, the namespace the traces run in, with the pod security labels they need,
+the service account the trace pods run as, and the roles granting what kubectl trace needs to run traces.
+The roles are bound to the given users and groups.
+
+The objects are labeled with the version of kubectl trace that set them up,
+run setup again after upgrading to keep them up to date.
+When no namespace is given, kubectl-trace-system is used.`
+
+	setupExamples = `
+  # Set up the kubectl-trace-system namespace for the members of the sre group
+  %[1]s trace setup --group=sre
+
+  # Set up another namespace for a user
+  %[1]s trace setup -n tracing --user=jane@example.com
+
+  # Print the objects instead of creating them
+  %[1]s trace setup --group=sre --dry-run -o yaml
+
+  # Remove what setup created
+  %[1]s trace teardown`
+
+	teardownShort = `Remove the namespace, service account and RBAC set up for kubectl trace` // Wrap with i18n.T()
+	teardownLong  = `Remove the namespace, service account and RBAC set up for kubectl trace.
+
+Deletes the objects setup creates, including the namespace and all the traces in it when setup created it.
+A namespace that existed before setup is kept, only the labels setup added to it are reverted.
+When no namespace is given, kubectl-trace-system is used.`
+
+	teardownExamples = `
+  # Remove the kubectl-trace-system namespace and its roles
+  %[1]s trace teardown
+
+  # Print the objects that would be removed
+  %[1]s trace teardown -n tracing --dry-run -o yaml`
+
+	setupOutputErrString = "the only supported output format is yaml"
+	setupDryRunErrString = "--output can only be used along with --dry-run"
+	setupArgsErrString   = "the %s command does not take arguments"
+
+	// setupDefaultVersion is the version label of objects set up by builds without a git commit.
+	setupDefaultVersion = "dev"
+)
+
+// SetupOptions ...
+type SetupOptions struct {
+	genericclioptions.IOStreams
+
+	// Flags local to this command
+	serviceAccount string
+	users          []string
+	groups         []string
+	dryRun         bool
+	output         string
+
+	objects []runtime.Object
+	client  kubernetes.Interface
+}
+
+// NewSetupOptions provides an instance of SetupOptions with default values.
+func NewSetupOptions(streams genericclioptions.IOStreams) *SetupOptions {
+	return &SetupOptions{
+		IOStreams: streams,
+
+		serviceAccount: setup.DefaultServiceAccount,
+	}
+}
+
+// NewSetupCommand provides the setup command wrapping SetupOptions.
+func NewSetupCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewSetupOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "setup [--user USER] [--group GROUP]",
+		Short:        setupShort,
+		Long:         setupLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(setupExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return validateSetupArgs(c, args, o.dryRun, o.output)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account the trace pods run as")
+	cmd.Flags().StringSliceVar(&o.users, "user", o.users, "Users to grant running traces in the namespace")
+	cmd.Flags().StringSliceVar(&o.groups, "group", o.groups, "Groups to grant running traces in the namespace")
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", o.dryRun, "Only print the objects instead of creating them")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format of --dry-run, only yaml is supported")
+
+	return cmd
+}
+
+// validateSetupArgs validates the arguments and flags shared by setup and teardown.
+func validateSetupArgs(cmd *cobra.Command, args []string, dryRun bool, output string) error {
+	if len(args) > 0 {
+		return fmt.Errorf(setupArgsErrString, cmd.Name())
+	}
+	if output != "" && output != "yaml" {
+		return fmt.Errorf(setupOutputErrString)
+	}
+	if output != "" && !dryRun {
+		return fmt.Errorf(setupDryRunErrString)
+	}
+	return nil
+}
+
+// setupOptions completes o with the namespace given to cmd, or the default one, and the version of the binary.
+func setupOptions(factory cmdutil.Factory, cmd *cobra.Command, o setup.Options) (setup.Options, error) {
+	o.Namespace = setup.DefaultNamespace
+	if f := cmd.Flag("namespace"); f != nil && f.Changed {
+		var err error
+		o.Namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+		if err != nil {
+			return o, err
+		}
+	}
+
+	o.Version = version.GitCommit()
+	if o.Version == "" {
+		o.Version = setupDefaultVersion
+	}
+
+	return o, nil
+}
+
+// Complete completes the setup of the command.
+func (o *SetupOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	so, err := setupOptions(factory, cmd, setup.Options{
+		ServiceAccount: o.serviceAccount,
+		Users:          o.users,
+		Groups:         o.groups,
+	})
+	if err != nil {
+		return err
+	}
+	o.objects = setup.Manifests(so)
+
+	if o.dryRun {
+		return nil
+	}
+
+	// Prepare client
+	o.client, err = factory.KubernetesClientSet()
+	return err
+}
+
+// Run executes the setup command.
+func (o *SetupOptions) Run() error {
+	if o.dryRun {
+		return printDryRun(o.IOStreams, o.objects, o.output)
+	}
+
+	if len(o.users) == 0 && len(o.groups) == 0 {
+		fmt.Fprintln(o.ErrOut, "no --user or --group given, the roles are not bound to anyone")
+	}
+
+	return setup.Apply(o.client, o.objects, func(obj runtime.Object, action string) {
+		fmt.Fprintf(o.Out, "%s %s\n", setup.Name(obj), action)
+	})
+}
+
+// printDryRun prints the objects as yaml, or their names when no output format is given.
+func printDryRun(streams genericclioptions.IOStreams, objects []runtime.Object, output string) error {
+	if output == "yaml" {
+		return setup.PrintYAML(streams.Out, objects)
+	}
+	for _, obj := range objects {
+		fmt.Fprintf(streams.Out, "%s (dry run)\n", setup.Name(obj))
+	}
+	return nil
+}
+
+// TeardownOptions ...
+type TeardownOptions struct {
+	genericclioptions.IOStreams
+
+	// Flags local to this command
+	serviceAccount string
+	dryRun         bool
+	output         string
+
+	objects []runtime.Object
+	client  kubernetes.Interface
+}
+
+// NewTeardownOptions provides an instance of TeardownOptions with default values.
+func NewTeardownOptions(streams genericclioptions.IOStreams) *TeardownOptions {
+	return &TeardownOptions{
+		IOStreams: streams,
+
+		serviceAccount: setup.DefaultServiceAccount,
+	}
+}
+
+// NewTeardownCommand provides the teardown command wrapping TeardownOptions.
+func NewTeardownCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewTeardownOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "teardown",
+		Short:        teardownShort,
+		Long:         teardownLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(teardownExamples, "kubectl"), // Wrap with templates.Examples()
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return validateSetupArgs(c, args, o.dryRun, o.output)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account the trace pods run as")
+	cmd.Flags().BoolVar(&o.dryRun, "dry-run", o.dryRun, "Only print the objects instead of deleting them")
+	cmd.Flags().StringVarP(&o.output, "output", "o", o.output, "Output format of --dry-run, only yaml is supported")
+
+	return cmd
+}
+
+// Complete completes the setup of the command.
+func (o *TeardownOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	so, err := setupOptions(factory, cmd, setup.Options{
+		ServiceAccount: o.serviceAccount,
+	})
+	if err != nil {
+		return err
+	}
+	o.objects = setup.TeardownManifests(so)
+
+	if o.dryRun {
+		return nil
+	}
+
+	// Prepare client
+	o.client, err = factory.KubernetesClientSet()
+	return err
+}
+
+// Run executes the teardown command.
+func (o *TeardownOptions) Run() error {
+	if o.dryRun {
+		return printDryRun(o.IOStreams, o.objects, o.output)
+	}
+
+	return setup.Delete(o.client, o.objects, func(obj runtime.Object, action string) {
+		fmt.Fprintf(o.Out, "%s %s\n", setup.Name(obj), action)
+	})
+}
@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+// traceTarget is what a trace job runs against: a node and, optionally, a pod container on it.
+type traceTarget struct {
+	node     *v1.Node
+	nodeName string
+	// resource is the target as TYPE/NAME
+	resource  string
+	isPod     bool
+	podUID    string
+	container string
+}
+
+// resolveTarget looks up the node, or the pod and its container, referred to by resourceArg.
+// An empty container selects the first container of the pod.
+func resolveTarget(factory cmdutil.Factory, namespace, resourceArg, container string) (*traceTarget, error) {
+	x := factory.
+		NewBuilder().
+		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
+		NamespaceParam(namespace).
+		SingleResourceType().
+		ResourceNames("nodes", resourceArg). // Search nodes by default
+		Do()
+
+	obj, err := x.Object()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check we got a pod or a node
+	switch v := obj.(type) {
+	case *v1.Pod:
+		if len(v.Spec.NodeName) == 0 {
+			return nil, fmt.Errorf("cannot attach a trace program to a pod that is not currently scheduled on a node")
+		}
+		return podTarget(factory, v, container)
+	case *v1.Node:
+		t := &traceTarget{resource: "node/" + v.Name}
+		return t, t.setNode(v)
+	default:
+		return nil, fmt.Errorf("first argument must be %s", usageString)
+	}
+}
+
+// podTarget returns the container of a pod scheduled on a node as the target.
+// An empty container selects the first container of the pod.
+func podTarget(factory cmdutil.Factory, pod *v1.Pod, container string) (*traceTarget, error) {
+	t := &traceTarget{
+		isPod:     true,
+		resource:  "pod/" + pod.Name,
+		podUID:    string(pod.UID),
+		container: container,
+	}
+	found := false
+	for _, c := range pod.Spec.Containers {
+		// default if no container provided
+		if len(t.container) == 0 {
+			t.container = c.Name
+			found = true
+			break
+		}
+		// check if the provided one exists
+		if c.Name == t.container {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return nil, fmt.Errorf("no containers found for the provided pod/container combination")
+	}
+
+	obj, err := factory.
+		NewBuilder().
+		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
+		ResourceNames("nodes", pod.Spec.NodeName).
+		Do().Object()
+
+	if err != nil {
+		return nil, err
+	}
+
+	n, ok := obj.(*v1.Node)
+	if !ok {
+		return nil, fmt.Errorf("could not determine on which node to run the trace program")
+	}
+	return t, t.setNode(n)
+}
+
+// setNode sets the node the trace runs on.
+func (t *traceTarget) setNode(node *v1.Node) error {
+	t.node = node
+	labels := node.GetLabels()
+	val, ok := labels["kubernetes.io/hostname"]
+	if !ok {
+		return fmt.Errorf("label kubernetes.io/hostname not found in node")
+	}
+	t.nodeName = val
+	return nil
+}
+
+// podPollInterval is how often the pods are listed while waiting for one.
+const podPollInterval = 500 * time.Millisecond
+
+// waitForPod waits for the pod of the given name, or for a pod matching the selector, to be scheduled on a node
+// and returns it. Pods being deleted, and those whose container already started, are passed over: they would be
+// traced too late, a pod of the same name created again is waited for instead.
+func waitForPod(ctx context.Context, client corev1client.PodInterface, name, selector, container string) (*v1.Pod, error) {
+	opts := metav1.ListOptions{LabelSelector: selector}
+	if len(name) > 0 {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	}
+
+	var pod *v1.Pod
+	err := wait.PollImmediateUntil(podPollInterval, func() (bool, error) {
+		pl, err := client.List(ctx, opts)
+		if err != nil {
+			return false, err
+		}
+		for i, p := range pl.Items {
+			if len(p.Spec.NodeName) == 0 || p.DeletionTimestamp != nil || containerStarted(&p, container) {
+				continue
+			}
+			pod = &pl.Items[i]
+			return true, nil
+		}
+		return false, nil
+	}, ctx.Done())
+	return pod, err
+}
+
+// containerStarted tells whether the container of the pod, the first one if empty, has started already.
+func containerStarted(pod *v1.Pod, container string) bool {
+	if len(container) == 0 && len(pod.Spec.Containers) > 0 {
+		container = pod.Spec.Containers[0].Name
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.Name == container {
+			return cs.State.Running != nil || cs.State.Terminated != nil || cs.RestartCount > 0
+		}
+	}
+	return false
+}
+
+// appArmorEnabled tells whether the kubelet of the node enforces AppArmor profiles, as it reports
+// in the message of the ready condition.
+func appArmorEnabled(node *v1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == v1.NodeReady {
+			return strings.Contains(c.Message, "AppArmor enabled")
+		}
+	}
+	return false
+}
+
+// traceNamespaces returns the namespace the traces are in, and the namespace of the pods to select the traces of.
+// Without a trace namespace the traces are in the namespace given to cmd. With one, they are in the trace namespace
+// and a namespace given explicitly to cmd selects the traces running against its pods.
+func traceNamespaces(factory cmdutil.Factory, traceNamespace string) (string, *string, error) {
+	namespace, explicit, err := factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(traceNamespace) == 0 {
+		return namespace, nil, nil
+	}
+	if !explicit {
+		return traceNamespace, nil, nil
+	}
+	return traceNamespace, &namespace, nil
+}
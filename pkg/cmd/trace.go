@@ -52,8 +52,9 @@ func NewTraceCommand(streams genericclioptions.IOStreams) *cobra.Command {
 		Short:                 `Execute and manage bpftrace programs`, // Wrap with i18n.T()
 		Long:                  traceLong,                              // Wrap with templates.LongDesc()
 		Example:               fmt.Sprintf(traceExamples, "kubectl"),  // Wrap with templates.Examples()
-		PersistentPreRun: func(c *cobra.Command, args []string) {
+		PersistentPreRunE: func(c *cobra.Command, args []string) error {
 			c.SetOutput(streams.ErrOut)
+			return applyConfig(c, kubeContext(o.configFlags))
 		},
 		Run: func(c *cobra.Command, args []string) {
 			cobra.NoArgs(c, args)
@@ -70,11 +71,24 @@ func NewTraceCommand(streams genericclioptions.IOStreams) *cobra.Command {
 	f := cmdutil.NewFactory(matchVersionFlags)
 
 	cmd.AddCommand(NewRunCommand(f, streams))
+	cmd.AddCommand(NewApplyCommand(f, streams))
+	cmd.AddCommand(NewExportCommand(f, streams))
 	cmd.AddCommand(NewGetCommand(f, streams))
+	cmd.AddCommand(NewDescribeCommand(f, streams))
 	cmd.AddCommand(NewAttachCommand(f, streams))
 	cmd.AddCommand(NewDeleteCommand(f, streams))
+	cmd.AddCommand(NewExtendCommand(f, streams))
+	cmd.AddCommand(NewTriggerCommand(f, streams))
+	cmd.AddCommand(NewGCCommand(f, streams))
 	cmd.AddCommand(NewVersionCommand(streams))
 	cmd.AddCommand(NewLogCommand(f, streams))
+	cmd.AddCommand(NewProbesCommand(f, streams))
+	cmd.AddCommand(NewNodeInfoCommand(f, streams))
+	cmd.AddCommand(NewHeadersCommand(f, streams))
+	cmd.AddCommand(NewSetupCommand(f, streams))
+	cmd.AddCommand(NewTeardownCommand(f, streams))
+	cmd.AddCommand(NewAuthCommand(f, streams))
+	cmd.AddCommand(NewConfigCommand(streams))
 
 	// Override help on all the commands tree
 	walk(cmd, func(c *cobra.Command) {
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -9,19 +11,35 @@ import (
 	"os/exec"
 	"os/signal"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/fntlnz/mountinfo"
+	"github.com/iovisor/kubectl-trace/pkg/lint"
+	"github.com/iovisor/kubectl-trace/pkg/nodeinfo"
+	"github.com/iovisor/kubectl-trace/pkg/termination"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
 	"github.com/spf13/cobra"
 )
 
 type TraceRunnerOptions struct {
-	podUID             string
-	containerName      string
-	inPod              bool
-	programPath        string
-	bpftraceBinaryPath string
+	podUID                 string
+	containerName          string
+	inPod                  bool
+	programPath            string
+	programArgs            []string
+	bpftraceBinaryPath     string
+	validate               bool
+	listPattern            string
+	nodeInfo               bool
+	terminationMessagePath string
+	deadlineFile           string
+	gracePeriod            time.Duration
+	followRestarts         bool
+	waitForContainer       bool
 }
 
 func NewTraceRunnerOptions() *TraceRunnerOptions {
@@ -49,8 +67,17 @@ func NewTraceRunnerCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&o.containerName, "container", "c", o.containerName, "Specify the container")
 	cmd.Flags().StringVarP(&o.podUID, "poduid", "p", o.podUID, "Specify the pod UID")
 	cmd.Flags().StringVarP(&o.programPath, "program", "f", "program.bt", "Specify the bpftrace program path")
+	cmd.Flags().StringArrayVar(&o.programArgs, "arg", o.programArgs, "Positional parameter of the bpftrace program, can be repeated")
 	cmd.Flags().StringVarP(&o.bpftraceBinaryPath, "bpftracebinary", "b", "/usr/bin/bpftrace", "Specify the bpftrace binary path")
 	cmd.Flags().BoolVar(&o.inPod, "inpod", false, "Whether or not run this bpftrace in a pod's container process namespace")
+	cmd.Flags().BoolVar(&o.validate, "validate", false, "Whether or not to check the program with a bpftrace dry run before starting it")
+	cmd.Flags().StringVar(&o.listPattern, "list", o.listPattern, "List the probes matching the pattern instead of running the program")
+	cmd.Flags().BoolVar(&o.nodeInfo, "node-info", o.nodeInfo, "Report what the node offers to bpftrace programs instead of running the program")
+	cmd.Flags().StringVar(&o.terminationMessagePath, "termination-message-path", termination.DefaultPath, "Specify where to write the termination message")
+	cmd.Flags().StringVar(&o.deadlineFile, "deadline-file", o.deadlineFile, "File with the deadline of the program in seconds from its start, read again as it changes")
+	cmd.Flags().BoolVar(&o.followRestarts, "follow-restarts", o.followRestarts, "Whether to trace the container again when it restarts, until the deadline, when inpod=true")
+	cmd.Flags().BoolVar(&o.waitForContainer, "wait-for-container", o.waitForContainer, "Whether to wait for the container to start instead of failing when it is not running, when inpod=true")
+	cmd.Flags().DurationVar(&o.gracePeriod, "grace-period", 30*time.Second, "Time the program is given to print its maps once interrupted, before it is killed")
 	return cmd
 }
 
@@ -59,6 +86,15 @@ func (o *TraceRunnerOptions) Validate(cmd *cobra.Command, args []string) error {
 	if o.inPod == true && (len(o.containerName) == 0 || len(o.podUID) == 0) {
 		return fmt.Errorf("poduid and container must be specified when inpod=true")
 	}
+	if o.followRestarts && !o.inPod {
+		return fmt.Errorf("follow-restarts can only be used when inpod=true")
+	}
+	if o.waitForContainer && !o.inPod {
+		return fmt.Errorf("wait-for-container can only be used when inpod=true")
+	}
+	if o.waitForContainer && o.followRestarts {
+		return fmt.Errorf("follow-restarts is not needed with wait-for-container, which traces all the containers of the pod")
+	}
 	return nil
 }
 
@@ -68,61 +104,683 @@ func (o *TraceRunnerOptions) Complete(cmd *cobra.Command, args []string) error {
 }
 
 func (o *TraceRunnerOptions) Run() error {
+	if len(o.listPattern) > 0 {
+		return o.listProbes()
+	}
+
+	if o.nodeInfo {
+		return o.reportNodeInfo()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	start := time.Now()
+	deadlineCh := make(chan struct{})
+	if len(o.deadlineFile) > 0 {
+		go o.watchDeadline(ctx, start, deadlineCh)
+	}
+
+	m := termination.Message{BpftraceVersion: o.bpftraceVersion()}
+	recorder := &termination.OutputRecorder{}
+	var started *containerStart
 	programPath := o.programPath
-	if o.inPod == true {
-		pid, err := findPidByPodContainer(o.podUID, o.containerName)
+	switch {
+	case o.inPod && o.waitForContainer:
+		// The program starts before the container, matching the containers of the pod by the cgroup kubelet
+		// creates for the pod before any of them. It is validated against the pod of the trace runner itself.
+		// Without the cgroups of the pods the wait could only end with the deadline.
+		root, err := kubepodsCgroupRoot()
 		if err != nil {
+			m.Reason = termination.ReasonTargetNotFound
+			m.Errors = []termination.ProgramError{{Message: fmt.Sprintf("cannot wait for pod %s: %s", o.podUID, err)}}
+			o.writeTerminationMessage(m)
 			return err
 		}
-		if pid == nil {
-			return fmt.Errorf("pid not found")
+		if o.validate {
+			own, err := cgroupV2Path(strconv.Itoa(os.Getpid()))
+			if err != nil {
+				return err
+			}
+			standIn, err := o.podProgram(path.Dir(own))
+			if err != nil {
+				return err
+			}
+			if err := o.validateProgram(standIn, m); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("waiting for the cgroup of pod %s\n", o.podUID)
+		var podCgroup string
+		podCgroup, m.Reason = o.waitFor(func() (string, error) { return o.podCgroup(root) }, sigCh, deadlineCh)
+		if len(podCgroup) == 0 {
+			m.RuntimeSeconds = int64(time.Since(start) / time.Second)
+			fmt.Printf("\npod %s never started: %s\n", o.podUID, m.Summary())
+			o.writeTerminationMessage(m)
+			return nil
 		}
-		if len(*pid) == 0 {
-			return fmt.Errorf("invalid pid found")
+		m.Cgroup = strings.TrimPrefix(podCgroup, root)
+		programPath, err = o.podProgram(podCgroup)
+		if err != nil {
+			return err
 		}
-		f, err := ioutil.ReadFile(programPath)
+		started = o.watchContainerStart(ctx, recorder)
+	case o.inPod:
+		pid, err := o.containerPid()
 		if err != nil {
+			m.Reason = termination.ReasonTargetNotFound
+			m.Errors = []termination.ProgramError{{Message: err.Error()}}
+			o.writeTerminationMessage(m)
 			return err
 		}
-		programPath = path.Join(os.TempDir(), "program-container.bt")
-		r := strings.Replace(string(f), "$container_pid", *pid, -1)
-		if err := ioutil.WriteFile(programPath, []byte(r), 0755); err != nil {
+		m.PID = pid
+		m.Cgroup = processCgroup(pid)
+		programPath, err = o.containerProgram(pid)
+		if err != nil {
+			return err
+		}
+		if o.validate {
+			if err := o.validateProgram(programPath, m); err != nil {
+				return err
+			}
+		}
+	case o.validate:
+		if err := o.validateProgram(programPath, m); err != nil {
 			return err
 		}
 	}
 
 	fmt.Println("if your program has maps to print, send a SIGINT using Ctrl-C, if you want to interrupt the execution send SIGINT two times")
+	var reason termination.Reason
+	var err error
+	for {
+		// When following restarts, the program is interrupted once the traced process is gone,
+		// and started again against the new one
+		var targetExited <-chan struct{}
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		if o.followRestarts {
+			targetExited = watchProcess(watchCtx, m.PID)
+		}
+		reason, err = o.runProgram(programPath, recorder, sigCh, deadlineCh, targetExited)
+		stopWatching()
+		if reason != reasonTargetExited {
+			break
+		}
+
+		fmt.Printf("\n--- traced process %s exited, waiting for the container to restart ---\n", m.PID)
+		var pid string
+		pid, reason = o.waitFor(o.containerPid, sigCh, deadlineCh)
+		if len(pid) == 0 {
+			break
+		}
+		m.PID = pid
+		m.Cgroup = processCgroup(pid)
+		m.Restarts++
+		programPath, err = o.containerProgram(pid)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("--- container restarted, tracing process %s ---\n", pid)
+	}
+
+	m.Reason = reason
+	m.RuntimeSeconds = int64(time.Since(start) / time.Second)
+	recorder.Fill(&m)
+	if started != nil {
+		started.fill(&m)
+	}
+	fmt.Printf("\nprogram exited: %s\n", m.Summary())
+	o.writeTerminationMessage(m)
+	return err
+}
+
+// reasonTargetExited is why the program is interrupted when the traced process exits while following restarts.
+// It is not reported, the program is started again.
+const reasonTargetExited termination.Reason = "TargetExited"
+
+// runProgram runs the program until it exits, is interrupted or killed, and returns why it stopped.
+func (o *TraceRunnerOptions) runProgram(programPath string, recorder *termination.OutputRecorder, sigCh <-chan os.Signal, deadlineCh, targetExited <-chan struct{}) (termination.Reason, error) {
 	ctx, cancel := context.WithCancel(context.Background())
-	sigCh := make(chan os.Signal, 1)
+	defer cancel()
+
+	// The output is read for the attached probes and the lost events, bpftrace would buffer it
+	// through the pipe that takes unless asked to flush every line
+	c := exec.CommandContext(ctx, o.bpftraceBinaryPath, append([]string{"-B", "line", programPath}, o.programArgs...)...)
+	c.Stdout = io.MultiWriter(os.Stdout, recorder.Stream())
+	c.Stdin = os.Stdin
+	c.Stderr = io.MultiWriter(os.Stderr, recorder.Stream())
+	if err := c.Start(); err != nil {
+		return termination.ReasonFailed, err
+	}
+
+	reasonCh := make(chan termination.Reason, 1)
+	go func() {
+		reasonCh <- o.supervise(ctx, cancel, c.Process, sigCh, deadlineCh, targetExited)
+	}()
+
+	err := c.Wait()
+	cancel()
+	reason := <-reasonCh
+	if reason == termination.ReasonCompleted && err != nil {
+		reason = termination.ReasonFailed
+	}
+	return reason, err
+}
+
+// containerProgram writes the program with $container_pid replaced with pid, and $container_cgroup with
+// the id of its cgroup, and returns its path.
+func (o *TraceRunnerOptions) containerProgram(pid string) (string, error) {
+	f, err := ioutil.ReadFile(o.programPath)
+	if err != nil {
+		return "", err
+	}
+	r := strings.Replace(string(f), lint.ContainerPidVariable, pid, -1)
+	if strings.Contains(r, lint.ContainerCgroupVariable) {
+		cgroup, err := cgroupV2Path(pid)
+		if err != nil {
+			return "", err
+		}
+		r = strings.Replace(r, lint.ContainerCgroupVariable, fmt.Sprintf("cgroupid(%q)", cgroup), -1)
+	}
+	return writeContainerProgram(r)
+}
+
+// podProgram writes the program with $container_cgroup replaced with a filter on the cgroups of the containers
+// of the pod whose cgroup is podCgroup, and returns its path.
+func (o *TraceRunnerOptions) podProgram(podCgroup string) (string, error) {
+	f, err := ioutil.ReadFile(o.programPath)
+	if err != nil {
+		return "", err
+	}
+	release, err := ioutil.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return "", err
+	}
+	r := strings.Replace(string(f), lint.ContainerCgroupVariable, podCgroupFilter(podCgroup, strings.TrimSpace(string(release))), -1)
+	// Without BTF, the kernel structures the filter goes through come from the headers
+	if _, err := os.Stat("/sys/kernel/btf/vmlinux"); err != nil {
+		r = withIncludes(r, cgroupFilterIncludes)
+	}
+	return writeContainerProgram(r)
+}
+
+// writeContainerProgram writes the program prepared for the container and returns its path.
+func writeContainerProgram(program string) (string, error) {
+	programPath := path.Join(os.TempDir(), "program-container.bt")
+	if err := ioutil.WriteFile(programPath, []byte(program), 0755); err != nil {
+		return "", err
+	}
+	return programPath, nil
+}
+
+// cgroupFilterIncludes are the headers defining the kernel structures the pod cgroup filter goes through.
+var cgroupFilterIncludes = []string{"linux/sched.h", "linux/cgroup-defs.h", "linux/kernfs.h"}
+
+// podCgroupFilter returns what $container_cgroup is replaced with when the trace starts before the container:
+// the cgroup of the current task when it is a child of the pod cgroup, as those of the containers are, and 0 otherwise,
+// so that cgroup == $container_cgroup matches the processes of the containers of the pod from their start.
+func podCgroupFilter(podCgroup, release string) string {
+	parent := "((struct task_struct *)curtask)->cgroups->dfl_cgrp->kn->parent->id"
+	// The id of the kernfs nodes is a plain integer since linux 5.5
+	if !tracejob.KernelAtLeast(release, 5, 5) {
+		parent += ".id"
+	}
+	return fmt.Sprintf("(%s == cgroupid(%q) ? cgroup : 0)", parent, podCgroup)
+}
+
+// withIncludes adds the includes at the top of the program, after its shebang if any.
+func withIncludes(program string, headers []string) string {
+	includes := ""
+	for _, h := range headers {
+		includes += fmt.Sprintf("#include <%s>\n", h)
+	}
+	if strings.HasPrefix(program, "#!") {
+		if i := strings.Index(program, "\n"); i >= 0 {
+			return program[:i+1] + includes + program[i+1:]
+		}
+	}
+	return includes + program
+}
+
+// podCgroup returns the path of the cgroup v2 of the traced pod under root, kubelet creates it before any of its containers.
+func (o *TraceRunnerOptions) podCgroup(root string) (string, error) {
+	p := findPodCgroup(root, o.podUID)
+	if len(p) == 0 {
+		return "", fmt.Errorf("cgroup of pod %s not found", o.podUID)
+	}
+	return p, nil
+}
+
+// kubepodsCgroupRoot returns where the cgroup v2 hierarchy is mounted, when the cgroups kubelet creates for the pods
+// are found there. They are not when the cgroupfs of the node is not mounted, the trace pod only seeing its own cgroups.
+func kubepodsCgroupRoot() (string, error) {
+	root, err := cgroupV2Root()
+	if err != nil {
+		return "", err
+	}
+	if !hasKubepodsCgroup(root) {
+		return "", fmt.Errorf("the cgroups of the pods are not found under %s, the cgroup hierarchy of the node is not mounted", root)
+	}
+	return root, nil
+}
 
-	signal.Notify(sigCh, os.Signal(syscall.SIGINT))
+// hasKubepodsCgroup tells whether the kubepods hierarchy is under dir, like kubepods or kubepods.slice.
+func hasKubepodsCgroup(dir string) bool {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), "kubepods") {
+			return true
+		}
+	}
+	return false
+}
 
+// findPodCgroup looks for the cgroup of the pod in the kubepods hierarchy under dir. Kubelet names it after
+// the pod UID, like kubepods/burstable/pod<uid>, or kubepods-burstable-pod<uid>.slice with the dashes of the
+// UID replaced with underscores when cgroups are managed by systemd.
+func findPodCgroup(dir, podUID string) string {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), ".slice")
+		switch {
+		case strings.HasSuffix(name, "pod"+podUID), strings.HasSuffix(name, "pod"+strings.Replace(podUID, "-", "_", -1)):
+			return path.Join(dir, e.Name())
+		case strings.HasPrefix(name, "kubepods"), name == "burstable", name == "besteffort":
+			if p := findPodCgroup(path.Join(dir, e.Name()), podUID); len(p) > 0 {
+				return p
+			}
+		}
+	}
+	return ""
+}
+
+// waitFor looks for something until it is there, like the process of the traced container. It returns what
+// was found, or why it gave up waiting: the deadline of the trace or a signal.
+func (o *TraceRunnerOptions) waitFor(find func() (string, error), sigCh <-chan os.Signal, deadlineCh <-chan struct{}) (string, termination.Reason) {
+	ticker := time.NewTicker(containerPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if found, err := find(); err == nil {
+			return found, ""
+		}
+
+		select {
+		case <-deadlineCh:
+			return "", termination.ReasonDeadlineExceeded
+		case sig := <-sigCh:
+			if sig == syscall.SIGTERM {
+				return "", termination.ReasonTerminated
+			}
+			return "", termination.ReasonInterrupted
+		case <-ticker.C:
+		}
+	}
+}
+
+// containerStart is what is known of the start of the traced container, when the trace starts before it.
+type containerStart struct {
+	mu  sync.Mutex
+	pid string
+	// beforeAttaching is true when the container was running before the probes were attached
+	beforeAttaching bool
+}
+
+// watchContainerStart looks for the process of the traced container while the program runs, to tell whether
+// the container started after the probes were attached.
+func (o *TraceRunnerOptions) watchContainerStart(ctx context.Context, recorder *termination.OutputRecorder) *containerStart {
+	cs := &containerStart{}
 	go func() {
-		killable := false
-		defer cancel()
+		ticker := time.NewTicker(containerPollInterval)
+		defer ticker.Stop()
+
+		// A container not there yet while the probes were being attached starts after them
+		missedAfterAttaching := false
+		for {
+			attaching := recorder.Attaching()
+			if pid, err := o.containerPid(); err == nil {
+				cs.mu.Lock()
+				cs.pid = pid
+				cs.beforeAttaching = !missedAfterAttaching
+				cs.mu.Unlock()
+				if missedAfterAttaching {
+					fmt.Printf("--- container %s started, process %s ---\n", o.containerName, pid)
+				} else {
+					fmt.Printf("--- container %s was already running when the probes were attached, its start is not traced ---\n", o.containerName)
+				}
+				return
+			}
+			if attaching {
+				missedAfterAttaching = true
+			}
 
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return cs
+}
+
+// fill sets the process of the container, once found, and whether it started before the trace in m.
+func (cs *containerStart) fill(m *termination.Message) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if len(cs.pid) == 0 {
+		return
+	}
+	m.PID = cs.pid
+	m.Cgroup = processCgroup(cs.pid)
+	m.ContainerStarted = cs.beforeAttaching
+}
+
+// processPollInterval is how often the traced process is looked at when following restarts.
+const processPollInterval = time.Second
+
+// containerPollInterval is how often the process of the traced container is looked for while it is not there,
+// often for the trace to start right after the container.
+const containerPollInterval = 100 * time.Millisecond
+
+// watchProcess returns a channel closed once the process is gone, a process of the same pid
+// started since not being the same process.
+func watchProcess(ctx context.Context, pid string) <-chan struct{} {
+	exited := make(chan struct{})
+	started, err := processStartTime(pid)
+	if err != nil {
+		close(exited)
+		return exited
+	}
+
+	go func() {
+		ticker := time.NewTicker(processPollInterval)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-sigCh:
-				if !killable {
-					killable = true
-					fmt.Println("\nfirst SIGINT received, now if your program had maps and did not free them it should print them out")
-					continue
-				}
+			case <-ticker.C:
+			}
+			if s, err := processStartTime(pid); err != nil || s != started {
+				close(exited)
 				return
 			}
 		}
 	}()
+	return exited
+}
+
+// processStartTime returns the start time of the process, in clock ticks since boot.
+func processStartTime(pid string) (string, error) {
+	b, err := ioutil.ReadFile(path.Join("/proc", pid, "stat"))
+	if err != nil {
+		return "", err
+	}
+	// The command name may contain spaces, the fields are counted after it: the start time is the 22nd field,
+	// the 20th after the command name
+	stat := string(b)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	if len(fields) < 20 {
+		return "", fmt.Errorf("invalid stat for process %s", pid)
+	}
+	return fields[19], nil
+}
+
+// writeTerminationMessage writes m for kubernetes to report it in the pod status, the trace goes on if it cannot.
+func (o *TraceRunnerOptions) writeTerminationMessage(m termination.Message) {
+	if err := termination.Write(o.terminationMessagePath, m); err != nil {
+		fmt.Fprintf(os.Stderr, "could not write termination message: %s\n", err)
+	}
+}
+
+// bpftraceVersion returns the version bpftrace reports, empty if it cannot tell.
+func (o *TraceRunnerOptions) bpftraceVersion() string {
+	out, err := exec.Command(o.bpftraceBinaryPath, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// cgroupV2Path returns the path of the cgroup v2 of the process, for bpftrace to get its id.
+func cgroupV2Path(pid string) (string, error) {
+	b, err := ioutil.ReadFile(path.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+	cgroup := ""
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		if strings.HasPrefix(l, "0::") {
+			cgroup = strings.TrimPrefix(l, "0::")
+		}
+	}
+	if len(cgroup) == 0 {
+		return "", fmt.Errorf("process %s is not in a cgroup v2, %s needs one", pid, lint.ContainerCgroupVariable)
+	}
+
+	root, err := cgroupV2Root()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, cgroup), nil
+}
+
+// cgroupV2Root returns where the cgroup v2 hierarchy is mounted.
+func cgroupV2Root() (string, error) {
+	// The unified hierarchy is mounted on its own, or along with the v1 hierarchies
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		if _, err := os.Stat(path.Join(root, "cgroup.controllers")); err == nil {
+			return root, nil
+		}
+	}
+	return "", fmt.Errorf("the cgroup v2 hierarchy is not mounted, %s needs it", lint.ContainerCgroupVariable)
+}
+
+// processCgroup returns the cgroup of the process, the one of the unified hierarchy if any, empty if it cannot tell.
+func processCgroup(pid string) string {
+	b, err := ioutil.ReadFile(path.Join("/proc", pid, "cgroup"))
+	if err != nil {
+		return ""
+	}
+	cgroup := ""
+	for _, l := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		// hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(l, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && len(parts[1]) == 0 {
+			return parts[2]
+		}
+		if len(cgroup) == 0 {
+			cgroup = parts[2]
+		}
+	}
+	return cgroup
+}
+
+// supervise interrupts the program when the deadline is reached, when the pod is deleted or when the traced process
+// exits, so that it prints its maps, and kills it if it has not exited after the grace period.
+// A second SIGINT kills it right away. It returns why the program stopped once ctx is done.
+func (o *TraceRunnerOptions) supervise(ctx context.Context, kill context.CancelFunc, p *os.Process, sigCh <-chan os.Signal, deadlineCh, targetExited <-chan struct{}) termination.Reason {
+	reason := termination.ReasonCompleted
+	interrupted := false
+	var grace <-chan time.Time
+	interrupt := func(r termination.Reason) {
+		// Only the first reason counts, the program is already printing its maps
+		if grace != nil {
+			return
+		}
+		reason = r
+		p.Signal(os.Interrupt)
+		grace = time.After(o.gracePeriod)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return reason
+		case <-deadlineCh:
+			// Closed, it would be selected again and again
+			deadlineCh = nil
+			interrupt(termination.ReasonDeadlineExceeded)
+		case <-targetExited:
+			targetExited = nil
+			interrupt(reasonTargetExited)
+		case sig := <-sigCh:
+			if sig == syscall.SIGTERM {
+				fmt.Printf("\nSIGTERM received, interrupting the program, it has %s to print its maps\n", o.gracePeriod)
+				interrupt(termination.ReasonTerminated)
+				continue
+			}
+			// With a terminal attached, bpftrace receives the SIGINT too
+			if !interrupted {
+				fmt.Println("\nfirst SIGINT received, now if your program had maps and did not free them it should print them out")
+				interrupted = true
+				if grace == nil {
+					reason = termination.ReasonInterrupted
+				}
+				continue
+			}
+			kill()
+		case <-grace:
+			fmt.Printf("\nprogram still running %s after being interrupted, killing it\n", o.gracePeriod)
+			kill()
+		}
+	}
+}
+
+// watchDeadline closes deadlineCh once the deadline of the program has passed.
+// The deadline file is read again until then, the last valid deadline read being the one enforced.
+func (o *TraceRunnerOptions) watchDeadline(ctx context.Context, start time.Time, deadlineCh chan<- struct{}) {
+	var deadline time.Duration
+	var readErr error
+	ticker := time.NewTicker(tracejob.DeadlinePollInterval)
+	defer ticker.Stop()
+
+	for {
+		d, err := tracejob.ReadDeadline(o.deadlineFile)
+		if err != nil {
+			// Report the errors once, not every time the file is read
+			if readErr == nil || readErr.Error() != err.Error() {
+				fmt.Fprintf(os.Stderr, "could not read the deadline: %s\n", err)
+			}
+			readErr = err
+		} else if d != deadline {
+			if deadline != 0 {
+				fmt.Printf("\ndeadline changed to %s from the start\n", d)
+			}
+			deadline = d
+		}
+
+		if deadline > 0 && time.Since(start) >= deadline {
+			fmt.Printf("\ndeadline of %s reached, interrupting the program, it has %s to print its maps\n", deadline, o.gracePeriod)
+			close(deadlineCh)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// containerPid returns the pid of the target container in the root pid namespace.
+func (o *TraceRunnerOptions) containerPid() (string, error) {
+	pid, err := findPidByPodContainer(o.podUID, o.containerName)
+	if err != nil {
+		return "", err
+	}
+	if pid == nil {
+		return "", fmt.Errorf("pid not found")
+	}
+	if len(*pid) == 0 {
+		return "", fmt.Errorf("invalid pid found")
+	}
+	return *pid, nil
+}
 
-	c := exec.CommandContext(ctx, o.bpftraceBinaryPath, programPath)
+// listProbes prints the probes matching the list pattern, one per line.
+func (o *TraceRunnerOptions) listProbes() error {
+	pattern := o.listPattern
+	if o.inPod == true {
+		pid, err := o.containerPid()
+		if err != nil {
+			return err
+		}
+		pattern = strings.Replace(pattern, "$container_pid", pid, -1)
+	}
+
+	c := exec.Command(o.bpftraceBinaryPath, "-l", pattern)
 	c.Stdout = os.Stdout
-	c.Stdin = os.Stdin
 	c.Stderr = os.Stderr
 	return c.Run()
 }
 
+// reportNodeInfo prints the node report as a single json line.
+func (o *TraceRunnerOptions) reportNodeInfo() error {
+	r, err := nodeinfo.Gather("/", o.bpftraceBinaryPath)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
+
+// validateProgram asks bpftrace to compile the program and attach its probes without running it,
+// so that program errors are reported right away and not retried by the job.
+func (o *TraceRunnerOptions) validateProgram(programPath string, m termination.Message) error {
+	var out bytes.Buffer
+	c := exec.Command(o.bpftraceBinaryPath, append([]string{"--dry-run", programPath}, o.programArgs...)...)
+	c.Stdout = &out
+	c.Stderr = &out
+	if err := c.Run(); err == nil {
+		return nil
+	}
+
+	fmt.Print(out.String())
+	m.Reason = termination.ReasonCompileError
+	m.Errors = termination.ParseProgramErrors(out.String())
+	if len(m.Errors) == 0 {
+		m.Errors = append(m.Errors, termination.ProgramError{Message: strings.TrimSpace(out.String())})
+	}
+	o.writeTerminationMessage(m)
+	return exitError{error: fmt.Errorf("program validation failed"), code: termination.CompileErrorExitCode}
+}
+
+// exitError is an error trace-runner exits with a specific code for.
+type exitError struct {
+	error
+	code int
+}
+
+// ExitCode returns the code trace-runner exits with for an error of its command.
+func ExitCode(err error) int {
+	if e, ok := err.(exitError); ok {
+		return e.code
+	}
+	return 1
+}
+
 func findPidByPodContainer(podUID, containerName string) (*string, error) {
 	d, err := os.Open("/proc")
 
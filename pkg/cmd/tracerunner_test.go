@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPodCgroup(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	for _, d := range []string{
+		"system.slice/kubelet.service",
+		"kubepods/burstable/pod1d5d4b8e-0f6e-4d8a-9a2b-7c6f5e4d3c2b/abcdef",
+		"kubepods/pod0a1b2c3d-0000-1111-2222-333344445555",
+		"kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9f8e7d6c_5b4a_3928_1706_f5e4d3c2b1a0.slice/cri-containerd-abcdef.scope",
+	} {
+		require.Nil(t, os.MkdirAll(path.Join(root, d), 0755))
+	}
+
+	assert.Equal(t, path.Join(root, "kubepods/burstable/pod1d5d4b8e-0f6e-4d8a-9a2b-7c6f5e4d3c2b"), findPodCgroup(root, "1d5d4b8e-0f6e-4d8a-9a2b-7c6f5e4d3c2b"))
+	assert.Equal(t, path.Join(root, "kubepods/pod0a1b2c3d-0000-1111-2222-333344445555"), findPodCgroup(root, "0a1b2c3d-0000-1111-2222-333344445555"))
+	assert.Equal(t, path.Join(root, "kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod9f8e7d6c_5b4a_3928_1706_f5e4d3c2b1a0.slice"), findPodCgroup(root, "9f8e7d6c-5b4a-3928-1706-f5e4d3c2b1a0"))
+	assert.Empty(t, findPodCgroup(root, "00000000-0000-0000-0000-000000000000"))
+	assert.True(t, hasKubepodsCgroup(root))
+
+	// The cgroups of a container that does not see those of the node
+	assert.False(t, hasKubepodsCgroup(path.Join(root, "system.slice")))
+}
+
+func TestPodCgroupFilter(t *testing.T) {
+	assert.Equal(t, `(((struct task_struct *)curtask)->cgroups->dfl_cgrp->kn->parent->id == cgroupid("/sys/fs/cgroup/kubepods/podabc") ? cgroup : 0)`, podCgroupFilter("/sys/fs/cgroup/kubepods/podabc", "5.10.0-8-amd64"))
+	assert.Equal(t, `(((struct task_struct *)curtask)->cgroups->dfl_cgrp->kn->parent->id.id == cgroupid("/sys/fs/cgroup/kubepods/podabc") ? cgroup : 0)`, podCgroupFilter("/sys/fs/cgroup/kubepods/podabc", "5.4.0-1029-gke"))
+}
+
+func TestWithIncludes(t *testing.T) {
+	headers := []string{"linux/sched.h", "linux/kernfs.h"}
+	assert.Equal(t, "#include <linux/sched.h>\n#include <linux/kernfs.h>\nBEGIN { }\n", withIncludes("BEGIN { }\n", headers))
+	assert.Equal(t, "#!/usr/bin/env bpftrace\n#include <linux/sched.h>\n#include <linux/kernfs.h>\nBEGIN { }\n", withIncludes("#!/usr/bin/env bpftrace\nBEGIN { }\n", headers))
+}
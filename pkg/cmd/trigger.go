@@ -0,0 +1,387 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/access"
+	"github.com/iovisor/kubectl-trace/pkg/signals"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/iovisor/kubectl-trace/pkg/trigger"
+	"github.com/spf13/cobra"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+)
+
+var (
+	triggerShort = `Start traces when the pods meet a condition` // Wrap with i18n.T()
+
+	triggerLong = `Start traces when the pods meet a condition.
+
+The pods matching the selector are watched until the command is interrupted. Each time one of them
+meets one of the conditions, the program is run on it, or on its node with --target=node, for --duration.
+
+The conditions are:
+  restart      a container of the pod restarted
+  not-ready    the pod went from ready to not ready
+  oom-killed   a container of the pod was killed for running out of memory
+  event:REASON an event with that reason was reported about the pod, like event:BackOff or event:Unhealthy
+
+The events are looked at every --interval, the other conditions as soon as the pods change.
+
+A pod is traced at most once per --cooldown, and no more than --max-concurrent traces run at once,
+the conditions met past these limits are reported and passed over.` // Wrap with templates.LongDesc()
+
+	triggerExamples = `
+  # Trace the node of an api pod for a minute when it goes not ready or restarts
+  %[1]s trace trigger -l app=api --on not-ready,restart --target=node -f read.bt --duration 60s
+
+  # Trace the app container of an api pod when it fails its liveness or readiness probes
+  %[1]s trace trigger -l app=api -c app --on event:Unhealthy -f read.bt
+
+  # Trace the pods of the payments namespace killed for running out of memory, two at most at once
+  %[1]s trace trigger -n payments -l tier=backend --on oom-killed -f read.bt --max-concurrent 2 --cooldown 30m`
+
+	triggerSelectorErrString = "--selector is required, the pods to watch are selected by their labels"
+	triggerTargetErrString   = "--target must be one of pod, node"
+)
+
+// TriggerOptions ...
+type TriggerOptions struct {
+	genericclioptions.IOStreams
+
+	namespace      string
+	traceNamespace string
+
+	selector      string
+	on            []string
+	conditions    []trigger.Condition
+	target        string
+	duration      int64
+	cooldown      int64
+	maxConcurrent int
+	interval      int64
+
+	// Flags passed on to the traces
+	container           string
+	eval                string
+	program             string
+	args                []string
+	serviceAccount      string
+	imageName           string
+	initImageName       string
+	headers             string
+	securityProfile     string
+	validate            bool
+	followRestarts      bool
+	skipLint            bool
+	deadlineGracePeriod int64
+}
+
+// NewTriggerOptions provides an instance of TriggerOptions with default values.
+func NewTriggerOptions(streams genericclioptions.IOStreams) *TriggerOptions {
+	ro := NewRunOptions(streams)
+	return &TriggerOptions{
+		IOStreams: streams,
+
+		target:        "pod",
+		duration:      60,
+		cooldown:      600,
+		maxConcurrent: 1,
+		interval:      5,
+
+		serviceAccount:      ro.serviceAccount,
+		imageName:           ro.imageName,
+		initImageName:       ro.initImageName,
+		headers:             ro.headers,
+		securityProfile:     ro.securityProfile,
+		validate:            ro.validate,
+		deadlineGracePeriod: ro.deadlineGracePeriod,
+	}
+}
+
+// NewTriggerCommand provides the trigger command wrapping TriggerOptions.
+func NewTriggerCommand(factory cmdutil.Factory, streams genericclioptions.IOStreams) *cobra.Command {
+	o := NewTriggerOptions(streams)
+
+	cmd := &cobra.Command{
+		Use:          "trigger -l SELECTOR --on CONDITION (-e PROGRAM | -f FILE)",
+		Short:        triggerShort,
+		Long:         triggerLong,                             // Wrap with templates.LongDesc()
+		Example:      fmt.Sprintf(triggerExamples, "kubectl"), // Wrap with templates.Examples()
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		PreRunE: func(c *cobra.Command, args []string) error {
+			return o.Validate(c, args)
+		},
+		RunE: func(c *cobra.Command, args []string) error {
+			if err := o.Complete(factory, c, args); err != nil {
+				return err
+			}
+			if err := o.Run(factory); err != nil {
+				fmt.Fprintln(o.ErrOut, err.Error())
+				return nil
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", o.selector, "Selector of the pods to watch")
+	cmd.Flags().StringSliceVar(&o.on, "on", o.on, "Conditions to start a trace on: restart, not-ready, oom-killed or event:REASON, can be repeated")
+	cmd.Flags().StringVar(&o.target, "target", o.target, "What to trace when a pod meets a condition: pod, or node for the node of the pod")
+	cmd.Flags().Var(newSecondsValue(o.duration, &o.duration), "duration", "How long each trace runs, like 60s or 5m, or in seconds")
+	cmd.Flags().Var(newSecondsValue(o.cooldown, &o.cooldown), "cooldown", "Minimum time between two traces of the same pod, like 10m, or in seconds")
+	cmd.Flags().IntVar(&o.maxConcurrent, "max-concurrent", o.maxConcurrent, "Maximum number of traces running at once")
+	cmd.Flags().Var(newSecondsValue(o.interval, &o.interval), "interval", "How often the events of the pods are looked at, like 5s, or in seconds")
+	cmd.Flags().StringVarP(&o.container, "container", "c", o.container, "Container to trace, and to watch for restarts and OOM kills, all of them being watched when not set")
+	cmd.Flags().StringVarP(&o.eval, "eval", "e", o.eval, "Literal string to be evaluated as a bpftrace program")
+	cmd.Flags().StringVarP(&o.program, "filename", "f", o.program, "File containing a bpftrace program")
+	cmd.Flags().StringArrayVar(&o.args, "arg", o.args, "Positional parameter of the bpftrace program, $1 being the first one, can be repeated")
+	cmd.Flags().StringVar(&o.serviceAccount, "serviceaccount", o.serviceAccount, "Service account to use to set in the pod spec of the kubectl-trace jobs")
+	cmd.Flags().StringVar(&o.traceNamespace, "trace-namespace", o.traceNamespace, "Namespace to create the traces in, instead of the namespace of the pods")
+	cmd.Flags().StringVar(&o.imageName, "imagename", o.imageName, "Custom image for the tracerunner")
+	cmd.Flags().StringVar(&o.initImageName, "init-imagename", o.initImageName, "Custom image for the init container responsible to fetch and prepare linux headers")
+	cmd.Flags().StringVar(&o.headers, "headers", o.headers, "How to provide linux headers: auto, btf, host or fetch")
+	cmd.Flags().StringVar(&o.securityProfile, "security-profile", o.securityProfile, "How much privilege the traces run with: privileged, or capabilities to run unprivileged with only the capabilities bpftrace needs")
+	cmd.Flags().BoolVar(&o.validate, "validate", o.validate, "Whether to check the program with a bpftrace dry run on the node before starting each trace")
+	cmd.Flags().BoolVar(&o.followRestarts, "follow-restarts", o.followRestarts, "Whether to trace the container again each time it restarts, until the end of the trace")
+	cmd.Flags().BoolVar(&o.skipLint, "skip-lint", o.skipLint, "Whether to skip checking the program for errors before watching the pods")
+	cmd.Flags().Var(newSecondsValue(o.deadlineGracePeriod, &o.deadlineGracePeriod), "deadline-grace-period", "Maximum wait time to print maps or histograms after the duration, like 30s, or in seconds")
+
+	return cmd
+}
+
+// Validate validates the arguments and flags populating TriggerOptions accordingly.
+func (o *TriggerOptions) Validate(cmd *cobra.Command, args []string) error {
+	if len(o.selector) == 0 {
+		return fmt.Errorf(triggerSelectorErrString)
+	}
+
+	var err error
+	o.conditions, err = trigger.ParseConditions(o.on)
+	if err != nil {
+		return err
+	}
+
+	if o.target != "pod" && o.target != "node" {
+		return fmt.Errorf(triggerTargetErrString)
+	}
+	if o.duration <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	if o.interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+	if o.maxConcurrent <= 0 {
+		return fmt.Errorf("--max-concurrent must be positive")
+	}
+
+	if !cmd.Flag("eval").Changed && !cmd.Flag("filename").Changed {
+		return fmt.Errorf(bpftraceMissingErrString)
+	}
+	if cmd.Flag("eval").Changed == cmd.Flag("filename").Changed {
+		return fmt.Errorf(bpftraceDoubleErrString)
+	}
+	if (cmd.Flag("eval").Changed && len(o.eval) == 0) || (cmd.Flag("filename").Changed && len(o.program) == 0) {
+		return fmt.Errorf(bpftraceEmptyErrString)
+	}
+
+	// Prepare program
+	if len(o.program) > 0 {
+		b, err := ioutil.ReadFile(o.program)
+		if err != nil {
+			return fmt.Errorf("error opening program file")
+		}
+		o.program = string(b)
+	} else {
+		o.program = o.eval
+	}
+
+	// The traces are checked like the run command would, before any pod is watched
+	return o.runOptions(o.target + "/").validateOptions()
+}
+
+// Complete completes the setup of the command.
+func (o *TriggerOptions) Complete(factory cmdutil.Factory, cmd *cobra.Command, args []string) error {
+	// Prepare namespace
+	var err error
+	o.namespace, _, err = factory.ToRawKubeConfigLoader().Namespace()
+	if err != nil {
+		return err
+	}
+	if len(o.traceNamespace) == 0 {
+		o.traceNamespace = o.namespace
+	}
+	return nil
+}
+
+// runOptions makes the options the run command would have to trace the resource.
+func (o *TriggerOptions) runOptions(resourceArg string) *RunOptions {
+	ro := NewRunOptions(o.IOStreams)
+	ro.namespace = o.namespace
+	ro.traceNamespace = o.traceNamespace
+	ro.resourceArg = resourceArg
+	ro.container = o.container
+	ro.program = o.program
+	ro.args = o.args
+	ro.serviceAccount = o.serviceAccount
+	ro.imageName = o.imageName
+	ro.initImageName = o.initImageName
+	ro.headers = o.headers
+	ro.securityProfile = o.securityProfile
+	ro.validate = o.validate
+	ro.followRestarts = o.followRestarts
+	ro.skipLint = o.skipLint
+	ro.deadline = o.duration
+	ro.deadlineGracePeriod = o.deadlineGracePeriod
+	return ro
+}
+
+// Run watches the pods until interrupted, starting a trace each time one of them meets a condition.
+func (o *TriggerOptions) Run(factory cmdutil.Factory) error {
+	clientConfig, err := factory.ToRESTConfig()
+	if err != nil {
+		return err
+	}
+	detector := trigger.NewDetector(o.conditions, o.container, time.Now())
+	perms := access.TriggerPermissions(o.namespace, o.traceNamespace, detector.WatchesEvents())
+	if o.headers == string(tracejob.HeaderModeAuto) {
+		perms = append(perms, access.NodeInfoPermissions(o.traceNamespace)...)
+	}
+	if err := checkAccess(clientConfig, access.CommandTrigger, perms); err != nil {
+		return err
+	}
+	client, err := factory.KubernetesClientSet()
+	if err != nil {
+		return err
+	}
+
+	limiter := &trigger.Limiter{
+		Cooldown:      time.Duration(o.cooldown) * time.Second,
+		MaxConcurrent: o.maxConcurrent,
+		TraceDuration: time.Duration(o.duration+o.deadlineGracePeriod) * time.Second,
+	}
+
+	// The traces are created while the pods keep being watched, picking their header mode can take a node-info job
+	var creating sync.WaitGroup
+	ctx := signals.WithStandardSignals(context.Background())
+
+	// The pods are watched rather than listed, not to miss a condition that goes away quickly, like a not ready flip
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(o.namespace),
+		informers.WithTweakListOptions(func(lo *metav1.ListOptions) {
+			lo.LabelSelector = o.selector
+		}))
+	podInformer := informerFactory.Core().V1().Pods().Informer()
+	podInformer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
+		fmt.Fprintf(o.ErrOut, "could not watch pods: %s\n", err)
+	})
+	onPod := func(obj interface{}) {
+		if pod, ok := obj.(*apiv1.Pod); ok {
+			for _, f := range detector.Pod(pod) {
+				o.fire(factory, limiter, f, &creating)
+			}
+		}
+	}
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    onPod,
+		UpdateFunc: func(_, obj interface{}) { onPod(obj) },
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*apiv1.Pod); ok {
+				detector.Forget(pod.UID)
+			}
+		},
+	})
+	informerFactory.Start(ctx.Done())
+	if cache.WaitForCacheSync(ctx.Done(), podInformer.HasSynced) {
+		fmt.Fprintf(o.ErrOut, "watching pods matching %s in namespace %s for %s\n", o.selector, o.namespace, strings.Join(o.on, ", "))
+	}
+
+	// The events are listed at each interval, they stay around with a count of the times they happened
+	if detector.WatchesEvents() {
+		err = wait.PollImmediateUntil(time.Duration(o.interval)*time.Second, func() (bool, error) {
+			for _, f := range o.detectEvents(ctx, client, detector) {
+				o.fire(factory, limiter, f, &creating)
+			}
+			return false, nil
+		}, ctx.Done())
+	}
+	<-ctx.Done()
+
+	// Leaving the traces being created half done would need them cleaned up
+	creating.Wait()
+
+	// Watching only stops once interrupted
+	if err == wait.ErrWaitTimeout {
+		return nil
+	}
+	return err
+}
+
+// detectEvents lists the events of the pods with the reasons of the conditions, and returns the conditions
+// they met since they were last listed. The errors listing them are reported, they are listed again at the next interval.
+func (o *TriggerOptions) detectEvents(ctx context.Context, client kubernetes.Interface, detector *trigger.Detector) []trigger.Firing {
+	events := []apiv1.Event{}
+	for _, reason := range detector.Reasons() {
+		el, err := client.CoreV1().Events(o.namespace).List(ctx, metav1.ListOptions{
+			FieldSelector: fields.AndSelectors(
+				fields.OneTermEqualSelector("involvedObject.kind", "Pod"),
+				fields.OneTermEqualSelector("reason", reason),
+			).String(),
+		})
+		if err != nil {
+			fmt.Fprintf(o.ErrOut, "could not list events: %s\n", err)
+			return nil
+		}
+		events = append(events, el.Items...)
+	}
+	return detector.Events(events)
+}
+
+// fire starts creating a trace of the pod that met a condition, or of its node, when the limits allow it.
+// The trace counts against the limits once created.
+func (o *TriggerOptions) fire(factory cmdutil.Factory, limiter *trigger.Limiter, f trigger.Firing, creating *sync.WaitGroup) {
+	fmt.Fprintf(o.Out, "%s\n", f)
+
+	resourceArg := "pod/" + f.Pod.Name
+	if o.target == "node" {
+		if len(f.Pod.Spec.NodeName) == 0 {
+			fmt.Fprintf(o.Out, "not tracing: the pod is not scheduled on a node\n")
+			return
+		}
+		resourceArg = "node/" + f.Pod.Spec.NodeName
+	}
+
+	if ok, reason := limiter.Allow(f.Pod.UID, time.Now()); !ok {
+		fmt.Fprintf(o.Out, "not tracing: %s\n", reason)
+		return
+	}
+
+	creating.Add(1)
+	go func() {
+		defer creating.Done()
+		ro := o.runOptions(resourceArg)
+		err := ro.completeTarget(factory)
+		if err == nil {
+			err = ro.Run()
+		}
+		limiter.Done(f.Pod.UID, time.Now(), err == nil)
+		if err != nil {
+			fmt.Fprintf(o.ErrOut, "could not trace %s: %s\n", resourceArg, err)
+		}
+	}()
+}
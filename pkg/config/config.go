@@ -0,0 +1,213 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/client-go/util/homedir"
+	"sigs.k8s.io/yaml"
+)
+
+// EnvVar is the environment variable pointing at the config file, instead of the default path.
+const EnvVar = "KUBECTL_TRACE_CONFIG"
+
+// Keys are the flags the config file can give defaults for.
+var Keys = []string{
+	"imagename",
+	"init-imagename",
+	"serviceaccount",
+	"trace-namespace",
+	"deadline",
+	"deadline-grace-period",
+	"headers",
+	"security-profile",
+	"patch",
+	"patch-type",
+	"requests",
+	"limits",
+	"priority-class",
+	"toleration",
+	"image-pull-secret",
+	"image-pull-policy",
+	"label",
+	"annotation",
+	"env",
+	"host-mount",
+}
+
+// Values are the defaults of a flag, one per time the flag would be given on the command line.
+// They are written as a single value when there is only one.
+type Values []string
+
+// MarshalJSON implements json.Marshaler.
+func (v Values) MarshalJSON() ([]byte, error) {
+	if len(v) == 1 {
+		return json.Marshal(v[0])
+	}
+	return json.Marshal([]string(v))
+}
+
+// Config holds the defaults of the flags, for all the kube contexts and for some of them.
+type Config struct {
+	Defaults map[string]Values            `json:"defaults,omitempty"`
+	Contexts map[string]map[string]Values `json:"contexts,omitempty"`
+}
+
+// Path returns where the config file is, ~/.kube/kubectl-trace.yaml unless EnvVar tells otherwise.
+func Path() string {
+	if p := os.Getenv(EnvVar); len(p) > 0 {
+		return p
+	}
+	return filepath.Join(homedir.HomeDir(), ".kube", "kubectl-trace.yaml")
+}
+
+// Load reads the config file at path. A file that does not exist is an empty config.
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Values are read as they are written, so that numbers do not need quoting
+	raw := struct {
+		Defaults map[string]interface{}            `json:"defaults"`
+		Contexts map[string]map[string]interface{} `json:"contexts"`
+	}{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+
+	if c.Defaults, err = stringValues(raw.Defaults); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %s", path, err)
+	}
+	for name, values := range raw.Contexts {
+		v, err := stringValues(values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s, context %s: %s", path, name, err)
+		}
+		if c.Contexts == nil {
+			c.Contexts = map[string]map[string]Values{}
+		}
+		c.Contexts[name] = v
+	}
+	return c, nil
+}
+
+// Save writes the config file at path, creating its directory when needed.
+func (c *Config) Save(path string) error {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// For returns the defaults in a kube context, those of the context overriding the ones of all the contexts.
+func (c *Config) For(context string) map[string]Values {
+	values := map[string]Values{}
+	for k, v := range c.Defaults {
+		values[k] = v
+	}
+	for k, v := range c.Contexts[context] {
+		values[k] = v
+	}
+	return values
+}
+
+// Set sets the defaults of a key, for all the contexts when context is empty.
+// No value, or a single empty one, removes the key.
+func (c *Config) Set(context, key string, value ...string) error {
+	if !isKey(key) {
+		return fmt.Errorf("unknown key %q, must be one of %s", key, strings.Join(Keys, ", "))
+	}
+
+	values := c.Defaults
+	if len(context) > 0 {
+		values = c.Contexts[context]
+	}
+	if values == nil {
+		values = map[string]Values{}
+	}
+
+	if len(value) == 0 || len(value) == 1 && len(value[0]) == 0 {
+		delete(values, key)
+	} else {
+		values[key] = Values(value)
+	}
+
+	if len(context) == 0 {
+		c.Defaults = values
+		return nil
+	}
+	if c.Contexts == nil {
+		c.Contexts = map[string]map[string]Values{}
+	}
+	c.Contexts[context] = values
+	if len(values) == 0 {
+		delete(c.Contexts, context)
+	}
+	return nil
+}
+
+func isKey(key string) bool {
+	for _, k := range Keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValues(raw map[string]interface{}) (map[string]Values, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	keys := []string{}
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	values := map[string]Values{}
+	for _, k := range keys {
+		if !isKey(k) {
+			return nil, fmt.Errorf("unknown key %q", k)
+		}
+		switch v := raw[k].(type) {
+		case string:
+			values[k] = Values{v}
+		case float64:
+			values[k] = Values{strconv.FormatFloat(v, 'f', -1, 64)}
+		case bool:
+			values[k] = Values{strconv.FormatBool(v)}
+		case []interface{}:
+			// Each item is given as if the flag was repeated
+			items := Values{}
+			for _, i := range v {
+				s, ok := i.(string)
+				if !ok {
+					return nil, fmt.Errorf("key %q must be a list of strings", k)
+				}
+				items = append(items, s)
+			}
+			values[k] = items
+		default:
+			return nil, fmt.Errorf("key %q must be a value or a list of values", k)
+		}
+	}
+	return values, nil
+}
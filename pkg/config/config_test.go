@@ -0,0 +1,89 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMissing(t *testing.T) {
+	c, err := Load(filepath.Join(os.TempDir(), "kubectl-trace-missing.yaml"))
+	require.Nil(t, err)
+	assert.Empty(t, c.For("kind-kind"))
+}
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubectl-trace.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(`
+defaults:
+  imagename: quay.io/myorg/kubectl-trace-bpftrace:v1
+  deadline: 600
+contexts:
+  production:
+    serviceaccount: kubectl-trace
+    deadline: 120
+    toleration:
+    - :NoExecute
+    - dedicated=tracing:NoSchedule
+`), 0644))
+
+	c, err := Load(path)
+	require.Nil(t, err)
+
+	assert.Equal(t, map[string]Values{
+		"imagename": {"quay.io/myorg/kubectl-trace-bpftrace:v1"},
+		"deadline":  {"600"},
+	}, c.For("staging"))
+	assert.Equal(t, map[string]Values{
+		"imagename":      {"quay.io/myorg/kubectl-trace-bpftrace:v1"},
+		"deadline":       {"120"},
+		"serviceaccount": {"kubectl-trace"},
+		"toleration":     {":NoExecute", "dedicated=tracing:NoSchedule"},
+	}, c.For("production"))
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubectl-trace.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte("defaults:\n  image: bpftrace\n"), 0644))
+
+	_, err = Load(path)
+	assert.EqualError(t, err, "invalid config file "+path+`: unknown key "image"`)
+}
+
+func TestSetAndSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-config")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "nested", "kubectl-trace.yaml")
+	c := &Config{}
+	require.Nil(t, c.Set("", "deadline", "600"))
+	require.Nil(t, c.Set("production", "serviceaccount", "kubectl-trace"))
+	require.Nil(t, c.Set("production", "env", "A=1", "B=2,3"))
+	err = c.Set("", "image", "bpftrace")
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), `unknown key "image", must be one of imagename, init-imagename,`)
+	require.Nil(t, c.Save(path))
+
+	loaded, err := Load(path)
+	require.Nil(t, err)
+	assert.Equal(t, c, loaded)
+
+	// An empty value removes the key, and the context along with its last key
+	require.Nil(t, loaded.Set("production", "serviceaccount", ""))
+	require.Nil(t, loaded.Set("production", "env"))
+	assert.Nil(t, loaded.Contexts["production"])
+	assert.Equal(t, map[string]Values{"deadline": {"600"}}, loaded.For("production"))
+}
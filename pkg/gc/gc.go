@@ -0,0 +1,191 @@
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// OrphanGracePeriod is how old an object missing its counterpart must be to be collected,
+// so that the objects of a trace being created are left alone.
+const OrphanGracePeriod = time.Minute
+
+// These are the kinds of the objects of a trace.
+const (
+	KindJob       = "Job"
+	KindConfigMap = "ConfigMap"
+	KindPod       = "Pod"
+)
+
+// Options tells where to look for the objects to collect, and which.
+type Options struct {
+	// Namespace is where to look, all the namespaces when empty.
+	Namespace string
+	// OlderThan also collects the objects of the traces older than it, when it is positive.
+	OlderThan time.Duration
+	// IncludeRunning collects the traces older than OlderThan even when their job is still running.
+	IncludeRunning bool
+	// Now is when the ages are computed from.
+	Now time.Time
+}
+
+// Object is a trace object to collect.
+type Object struct {
+	Kind      string
+	Namespace string
+	Name      string
+	TraceID   string
+	Created   time.Time
+	Reason    string
+}
+
+// String returns the kind and name of the object.
+func (o Object) String() string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(o.Kind), o.Name)
+}
+
+// trace is what there is of a trace in a namespace.
+type trace struct {
+	job       bool
+	configMap bool
+	running   bool
+}
+
+type traceKey struct {
+	namespace string
+	id        string
+}
+
+// Find returns the objects to collect: the config maps and pods of traces without a job, the jobs of traces without a
+// config map, and with OlderThan all the objects created before it, but those of the traces still running unless
+// IncludeRunning is set. They are sorted by namespace, kind and name.
+func Find(client kubernetes.Interface, o Options) ([]Object, error) {
+	ctx := context.Background()
+	lo := metav1.ListOptions{LabelSelector: meta.TraceIDLabelKey}
+
+	all := []Object{}
+	traces := map[traceKey]*trace{}
+	add := func(kind string, om metav1.ObjectMeta) {
+		id := om.Labels[meta.TraceIDLabelKey]
+		all = append(all, Object{
+			Kind:      kind,
+			Namespace: om.Namespace,
+			Name:      om.Name,
+			TraceID:   id,
+			Created:   om.CreationTimestamp.Time,
+		})
+		k := traceKey{om.Namespace, id}
+		if traces[k] == nil {
+			traces[k] = &trace{}
+		}
+		switch kind {
+		case KindJob:
+			traces[k].job = true
+		case KindConfigMap:
+			traces[k].configMap = true
+		}
+	}
+
+	jobs, err := client.BatchV1().Jobs(o.Namespace).List(ctx, lo)
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range jobs.Items {
+		add(KindJob, j.ObjectMeta)
+		traces[traceKey{j.Namespace, j.Labels[meta.TraceIDLabelKey]}].running = !jobFinished(j)
+	}
+	cms, err := client.CoreV1().ConfigMaps(o.Namespace).List(ctx, lo)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range cms.Items {
+		add(KindConfigMap, c.ObjectMeta)
+	}
+	pods, err := client.CoreV1().Pods(o.Namespace).List(ctx, lo)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pods.Items {
+		add(KindPod, p.ObjectMeta)
+	}
+
+	collected := []Object{}
+	for _, obj := range all {
+		age := o.Now.Sub(obj.Created)
+		t := traces[traceKey{obj.Namespace, obj.TraceID}]
+		switch {
+		case o.OlderThan > 0 && age > o.OlderThan && (o.IncludeRunning || !t.running):
+			obj.Reason = fmt.Sprintf("older than %s", o.OlderThan)
+		case age <= OrphanGracePeriod:
+			continue
+		case obj.Kind != KindJob && !t.job:
+			obj.Reason = "job not found"
+		case obj.Kind == KindJob && !t.configMap:
+			obj.Reason = "config map not found"
+		default:
+			continue
+		}
+		collected = append(collected, obj)
+	}
+
+	sort.SliceStable(collected, func(i, j int) bool {
+		a, b := collected[i], collected[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return collected, nil
+}
+
+// jobFinished tells whether a job completed or failed.
+func jobFinished(j batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == corev1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete deletes the objects, reporting each one deleted. The objects already gone are not errors,
+// deleting a job deletes its pods and, through its owner reference, its config map.
+func Delete(client kubernetes.Interface, objects []Object, report func(Object)) error {
+	ctx := context.Background()
+	dp := metav1.DeletePropagationForeground
+	failed := []string{}
+	for _, obj := range objects {
+		var err error
+		switch obj.Kind {
+		case KindJob:
+			err = client.BatchV1().Jobs(obj.Namespace).Delete(ctx, obj.Name, metav1.DeleteOptions{PropagationPolicy: &dp})
+		case KindConfigMap:
+			err = client.CoreV1().ConfigMaps(obj.Namespace).Delete(ctx, obj.Name, metav1.DeleteOptions{})
+		case KindPod:
+			err = client.CoreV1().Pods(obj.Namespace).Delete(ctx, obj.Name, metav1.DeleteOptions{})
+		default:
+			err = fmt.Errorf("unknown kind %q", obj.Kind)
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			failed = append(failed, fmt.Sprintf("%s in namespace %s: %s", obj, obj.Namespace, err))
+			continue
+		}
+		report(obj)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("could not delete:\n  - %s", strings.Join(failed, "\n  - "))
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package gc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var now = time.Date(2020, 11, 2, 12, 0, 0, 0, time.UTC)
+
+func objectMeta(namespace, name, id string, age time.Duration) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Namespace:         namespace,
+		Name:              name,
+		Labels:            map[string]string{meta.TraceIDLabelKey: id},
+		CreationTimestamp: metav1.NewTime(now.Add(-age)),
+	}
+}
+
+func newTestClient() *fake.Clientset {
+	return fake.NewSimpleClientset([]runtime.Object{
+		// A complete trace
+		&batchv1.Job{
+			ObjectMeta: objectMeta("default", "kubectl-trace-1", "1", 2*time.Hour),
+			Status:     batchv1.JobStatus{Conditions: []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: apiv1.ConditionTrue}}},
+		},
+		&apiv1.ConfigMap{ObjectMeta: objectMeta("default", "kubectl-trace-1", "1", 2*time.Hour)},
+		&apiv1.Pod{ObjectMeta: objectMeta("default", "kubectl-trace-1-abcde", "1", 2*time.Hour)},
+		// A config map left behind
+		&apiv1.ConfigMap{ObjectMeta: objectMeta("default", "kubectl-trace-2", "2", time.Hour)},
+		// A job without its config map
+		&batchv1.Job{ObjectMeta: objectMeta("tracing", "kubectl-trace-3", "3", time.Hour)},
+		// A trace still running
+		&batchv1.Job{ObjectMeta: objectMeta("default", "kubectl-trace-5", "5", 3*time.Hour)},
+		&apiv1.ConfigMap{ObjectMeta: objectMeta("default", "kubectl-trace-5", "5", 3*time.Hour)},
+		// A trace being created
+		&apiv1.ConfigMap{ObjectMeta: objectMeta("tracing", "kubectl-trace-4", "4", time.Second)},
+		// Not a trace
+		&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"}},
+	}...)
+}
+
+func names(objects []Object) []string {
+	n := []string{}
+	for _, o := range objects {
+		n = append(n, o.Namespace+" "+o.String()+" "+o.Reason)
+	}
+	return n
+}
+
+func TestFindOrphans(t *testing.T) {
+	objects, err := Find(newTestClient(), Options{Now: now})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"default configmap/kubectl-trace-2 job not found",
+		"tracing job/kubectl-trace-3 config map not found",
+	}, names(objects))
+
+	objects, err = Find(newTestClient(), Options{Namespace: "tracing", Now: now})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"tracing job/kubectl-trace-3 config map not found"}, names(objects))
+}
+
+func TestFindOlderThan(t *testing.T) {
+	objects, err := Find(newTestClient(), Options{Namespace: "default", OlderThan: 90 * time.Minute, Now: now})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"default configmap/kubectl-trace-1 older than 1h30m0s",
+		"default configmap/kubectl-trace-2 job not found",
+		"default job/kubectl-trace-1 older than 1h30m0s",
+		"default pod/kubectl-trace-1-abcde older than 1h30m0s",
+	}, names(objects))
+
+	objects, err = Find(newTestClient(), Options{Namespace: "default", OlderThan: 150 * time.Minute, IncludeRunning: true, Now: now})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"default configmap/kubectl-trace-2 job not found",
+		"default configmap/kubectl-trace-5 older than 2h30m0s",
+		"default job/kubectl-trace-5 older than 2h30m0s",
+	}, names(objects))
+}
+
+func TestDelete(t *testing.T) {
+	client := newTestClient()
+	objects, err := Find(client, Options{Now: now})
+	require.Nil(t, err)
+
+	// Objects already gone are not errors
+	objects = append(objects, Object{Kind: KindPod, Namespace: "default", Name: "gone"})
+
+	deleted := []string{}
+	require.Nil(t, Delete(client, objects, func(o Object) {
+		deleted = append(deleted, o.String())
+	}))
+	assert.Equal(t, []string{"configmap/kubectl-trace-2", "job/kubectl-trace-3", "pod/gone"}, deleted)
+
+	cms, err := client.CoreV1().ConfigMaps("default").List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	assert.Len(t, cms.Items, 3)
+}
@@ -0,0 +1,505 @@
+package headers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	appsv1typed "k8s.io/client-go/kubernetes/typed/apps/v1"
+	batchv1typed "k8s.io/client-go/kubernetes/typed/batch/v1"
+	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// CacheClient manages the kernel headers cache that the fetch headers init container keeps on the nodes.
+type CacheClient struct {
+	DaemonSetClient appsv1typed.DaemonSetInterface
+	PodClient       corev1typed.PodInterface
+	JobClient       batchv1typed.JobInterface
+	NodeClient      corev1typed.NodeInterface
+}
+
+const (
+	// DaemonSetName is the name of the DaemonSet preparing the headers on the nodes.
+	DaemonSetName = meta.ObjectNamePrefix + "headers"
+
+	cleanJobPrefix = meta.ObjectNamePrefix + "headers-clean-"
+	prepareLabel   = "prepare"
+	cleanLabel     = "clean"
+)
+
+// NodeState is the state of the headers cache of a node.
+type NodeState string
+
+// These are the valid states of the headers cache of a node.
+const (
+	NodeStatePreparing NodeState = "Preparing"
+	NodeStateReady     NodeState = "Ready"
+	NodeStateCleaned   NodeState = "Cleaned"
+	NodeStateFailed    NodeState = "Failed"
+)
+
+// NodeStatus is the state of the headers cache of a node, along with what it is for.
+type NodeStatus struct {
+	Node          string
+	KernelVersion string
+	State         NodeState
+	Message       string
+}
+
+// Prepare describes how to prepare the headers on the nodes.
+type Prepare struct {
+	Namespace        string
+	ServiceAccount   string
+	InitImageNameTag string
+	Source           tracejob.HeadersSource
+	// NodeSelector is a label selector of the nodes, all of them when empty.
+	NodeSelector string
+}
+
+// Clean describes which cached headers to remove from the nodes.
+type Clean struct {
+	Namespace      string
+	ServiceAccount string
+	ImageNameTag   string
+	// NodeSelector is a label selector of the nodes, all of them when empty. The DaemonSet keeps preparing
+	// the headers on the other nodes.
+	NodeSelector string
+	// All removes the headers of the running kernel too, not only the stale ones.
+	All bool
+}
+
+// cleanScript removes the cached headers of the kernel versions the node is not running,
+// or all of them when ALL is set. The links in modules_dir tell which generated headers are in use.
+const cleanScript = `set -e
+release="$(uname -r)"
+keep=""
+if [ -z "${ALL}" ] && [ -L "/cache/modules_dir/${release}/source" ]; then
+  keep="$(basename "$(readlink "/cache/modules_dir/${release}/source")")"
+fi
+for d in /cache/modules_dir/*; do
+  [ -e "${d}" ] || [ -L "${d}" ] || continue
+  [ -n "${keep}" ] && [ "$(basename "${d}")" = "${release}" ] && continue
+  echo "removing ${d}"
+  rm -rf "${d}"
+done
+for d in /cache/generated/*; do
+  [ -e "${d}" ] || continue
+  [ -n "${keep}" ] && [ "$(basename "${d}")" = "${keep}" ] && continue
+  echo "removing ${d}"
+  rm -rf "${d}"
+done
+[ -n "${keep}" ] || rm -f /cache/modules_dir/.installed
+`
+
+// Prepare creates the DaemonSet that prepares the headers on the selected nodes, or updates it if it exists.
+// Its pods become ready once the headers for the kernel of their node are in the cache.
+func (c *CacheClient) Prepare(p Prepare) (*appsv1.DaemonSet, error) {
+	labels := map[string]string{
+		meta.HeadersCacheLabelKey: prepareLabel,
+	}
+
+	affinity, err := nodeAffinity(p.NodeSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := apiv1.PodSpec{
+		ServiceAccountName: p.ServiceAccount,
+		Affinity:           affinity,
+		Volumes: []apiv1.Volume{
+			apiv1.Volume{
+				Name: "modules-host",
+				VolumeSource: apiv1.VolumeSource{
+					HostPath: &apiv1.HostPathVolumeSource{
+						Path: "/lib/modules",
+					},
+				},
+			},
+		},
+		Containers: []apiv1.Container{
+			apiv1.Container{
+				Name:  "kubectl-trace-headers",
+				Image: p.InitImageNameTag,
+				// Nothing left to do once the init container is done, only stay around to report readiness
+				Command: []string{"/bin/sh", "-c", "trap 'exit 0' TERM; while true; do sleep 3600 & wait $!; done"},
+				Resources: apiv1.ResourceRequirements{
+					Requests: apiv1.ResourceList{
+						apiv1.ResourceCPU:    resource.MustParse("10m"),
+						apiv1.ResourceMemory: resource.MustParse("10Mi"),
+					},
+					Limits: apiv1.ResourceList{
+						apiv1.ResourceCPU:    resource.MustParse("100m"),
+						apiv1.ResourceMemory: resource.MustParse("50Mi"),
+					},
+				},
+				VolumeMounts: []apiv1.VolumeMount{
+					apiv1.VolumeMount{
+						Name:      "modules-dir",
+						MountPath: "/lib/modules",
+						ReadOnly:  true,
+					},
+				},
+				// The links point to directories not mounted here, it is enough for them to exist
+				ReadinessProbe: &apiv1.Probe{
+					Handler: apiv1.Handler{
+						Exec: &apiv1.ExecAction{
+							Command: []string{"/bin/sh", "-c", `test -e /lib/modules/.installed && test -L "/lib/modules/$(uname -r)/build"`},
+						},
+					},
+					PeriodSeconds: 10,
+				},
+			},
+		},
+		Tolerations: []apiv1.Toleration{
+			apiv1.Toleration{
+				Effect:   apiv1.TaintEffectNoSchedule,
+				Operator: apiv1.TolerationOpExists,
+			},
+		},
+	}
+
+	// The image is the same on all the nodes, so it cannot depend on their kernel
+	if err := tracejob.AddFetchHeaders(&spec, p.InitImageNameTag, p.Source, ""); err != nil {
+		return nil, err
+	}
+
+	ds := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      DaemonSetName,
+			Namespace: p.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: spec,
+			},
+		},
+	}
+
+	existing, err := c.DaemonSetClient.Get(context.Background(), DaemonSetName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return c.DaemonSetClient.Create(context.Background(), ds, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Spec = ds.Spec
+	return c.DaemonSetClient.Update(context.Background(), existing, metav1.UpdateOptions{})
+}
+
+// Status reports the state of the headers cache of the nodes the DaemonSet runs on.
+func (c *CacheClient) Status() ([]NodeStatus, error) {
+	if _, err := c.DaemonSetClient.Get(context.Background(), DaemonSetName, metav1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, fmt.Errorf("headers are not being prepared, use headers prepare first")
+		}
+		return nil, err
+	}
+
+	pl, err := c.PodClient.List(context.Background(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", meta.HeadersCacheLabelKey, prepareLabel),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := []NodeStatus{}
+	for _, p := range pl.Items {
+		if len(p.Spec.NodeName) == 0 {
+			continue
+		}
+		s := NodeStatus{Node: p.Spec.NodeName}
+		s.State, s.Message = podState(p)
+		s.KernelVersion, err = c.kernelVersion(p.Spec.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Node < statuses[j].Node })
+	return statuses, nil
+}
+
+// Clean stops preparing the headers and starts removing them from the cache of the selected nodes,
+// with a job for each node. Use WaitCleaned to know how it went.
+func (c *CacheClient) Clean(cl Clean) ([]batchv1.Job, error) {
+	nl, err := c.NodeClient.List(context.Background(), metav1.ListOptions{LabelSelector: cl.NodeSelector})
+	if err != nil {
+		return nil, err
+	}
+
+	// Cleaning some of the nodes only excludes them from the DaemonSet, it goes on preparing the others
+	if len(cl.NodeSelector) > 0 {
+		if err := c.excludeNodes(nl.Items); err != nil {
+			return nil, err
+		}
+	} else {
+		fg := metav1.DeletePropagationForeground
+		err := c.DaemonSetClient.Delete(context.Background(), DaemonSetName, metav1.DeleteOptions{PropagationPolicy: &fg})
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+	}
+
+	all := ""
+	if cl.All {
+		all = "1"
+	}
+
+	jobs := []batchv1.Job{}
+	for _, n := range nl.Items {
+		labels := map[string]string{
+			meta.HeadersCacheLabelKey: cleanLabel,
+		}
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      cleanJobPrefix + strings.SplitN(string(uuid.NewUUID()), "-", 2)[0],
+				Namespace: cl.Namespace,
+				Labels:    labels,
+			},
+			Spec: batchv1.JobSpec{
+				BackoffLimit: int32Ptr(0),
+				Template: apiv1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: labels,
+					},
+					Spec: apiv1.PodSpec{
+						ServiceAccountName: cl.ServiceAccount,
+						NodeName:           n.Name,
+						RestartPolicy:      "Never",
+						Volumes: []apiv1.Volume{
+							apiv1.Volume{
+								Name: "cache",
+								VolumeSource: apiv1.VolumeSource{
+									HostPath: &apiv1.HostPathVolumeSource{
+										Path: tracejob.HeadersCachePath,
+									},
+								},
+							},
+						},
+						Containers: []apiv1.Container{
+							apiv1.Container{
+								Name:    "kubectl-trace-headers-clean",
+								Image:   cl.ImageNameTag,
+								Command: []string{"/bin/sh", "-c", cleanScript},
+								Env: []apiv1.EnvVar{
+									apiv1.EnvVar{Name: "ALL", Value: all},
+								},
+								VolumeMounts: []apiv1.VolumeMount{
+									apiv1.VolumeMount{
+										Name:      "cache",
+										MountPath: "/cache",
+									},
+								},
+							},
+						},
+						Tolerations: []apiv1.Toleration{
+							apiv1.Toleration{
+								Effect:   apiv1.TaintEffectNoSchedule,
+								Operator: apiv1.TolerationOpExists,
+							},
+						},
+					},
+				},
+			},
+		}
+
+		created, err := c.JobClient.Create(context.Background(), job, metav1.CreateOptions{})
+		if err != nil {
+			return jobs, err
+		}
+		jobs = append(jobs, *created)
+	}
+
+	return jobs, nil
+}
+
+// excludeNodes keeps the DaemonSet, if any, from running on the nodes.
+func (c *CacheClient) excludeNodes(nodes []apiv1.Node) error {
+	ds, err := c.DaemonSetClient.Get(context.Background(), DaemonSetName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	spec := &ds.Spec.Template.Spec
+	if spec.Affinity == nil {
+		spec.Affinity = &apiv1.Affinity{}
+	}
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &apiv1.NodeAffinity{}
+	}
+	na := spec.Affinity.NodeAffinity
+	if na.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		na.RequiredDuringSchedulingIgnoredDuringExecution = &apiv1.NodeSelector{}
+	}
+	ns := na.RequiredDuringSchedulingIgnoredDuringExecution
+	if len(ns.NodeSelectorTerms) == 0 {
+		ns.NodeSelectorTerms = []apiv1.NodeSelectorTerm{{}}
+	}
+
+	// The terms are ORed, each of them excludes the nodes. A field requirement takes a single node name.
+	for i := range ns.NodeSelectorTerms {
+		term := &ns.NodeSelectorTerms[i]
+		for _, n := range nodes {
+			term.MatchFields = append(term.MatchFields, apiv1.NodeSelectorRequirement{
+				Key:      "metadata.name",
+				Operator: apiv1.NodeSelectorOpNotIn,
+				Values:   []string{n.Name},
+			})
+		}
+	}
+
+	_, err = c.DaemonSetClient.Update(context.Background(), ds, metav1.UpdateOptions{})
+	return err
+}
+
+// nodeAffinity returns the affinity for the nodes matching the label selector, nil when it is empty.
+func nodeAffinity(selector string) (*apiv1.Affinity, error) {
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	ls, err := metav1.ParseToLabelSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	term := apiv1.NodeSelectorTerm{}
+	keys := []string{}
+	for k := range ls.MatchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		term.MatchExpressions = append(term.MatchExpressions, apiv1.NodeSelectorRequirement{
+			Key:      k,
+			Operator: apiv1.NodeSelectorOpIn,
+			Values:   []string{ls.MatchLabels[k]},
+		})
+	}
+	for _, e := range ls.MatchExpressions {
+		term.MatchExpressions = append(term.MatchExpressions, apiv1.NodeSelectorRequirement{
+			Key:      e.Key,
+			Operator: apiv1.NodeSelectorOperator(e.Operator),
+			Values:   e.Values,
+		})
+	}
+
+	return &apiv1.Affinity{
+		NodeAffinity: &apiv1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
+				NodeSelectorTerms: []apiv1.NodeSelectorTerm{term},
+			},
+		},
+	}, nil
+}
+
+// WaitCleaned waits for the clean jobs to finish, reports how it went on each node and deletes them.
+func (c *CacheClient) WaitCleaned(ctx context.Context, jobs []batchv1.Job) ([]NodeStatus, error) {
+	statuses := make([]NodeStatus, len(jobs))
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		done := true
+		for i, j := range jobs {
+			if len(statuses[i].State) > 0 {
+				continue
+			}
+			current, err := c.JobClient.Get(context.Background(), j.Name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			statuses[i].Node = j.Spec.Template.Spec.NodeName
+			statuses[i].State, statuses[i].Message = jobState(*current)
+			if len(statuses[i].State) == 0 {
+				done = false
+			}
+		}
+		return done, nil
+	}, ctx.Done())
+
+	bg := metav1.DeletePropagationBackground
+	for _, j := range jobs {
+		derr := c.JobClient.Delete(context.Background(), j.Name, metav1.DeleteOptions{PropagationPolicy: &bg})
+		if derr != nil && !errors.IsNotFound(derr) && err == nil {
+			err = derr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range statuses {
+		if statuses[i].KernelVersion, err = c.kernelVersion(statuses[i].Node); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Node < statuses[j].Node })
+	return statuses, nil
+}
+
+func (c *CacheClient) kernelVersion(nodeName string) (string, error) {
+	n, err := c.NodeClient.Get(context.Background(), nodeName, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return n.Status.NodeInfo.KernelVersion, nil
+}
+
+// podState tells the state of the cache from a pod of the DaemonSet.
+func podState(p apiv1.Pod) (NodeState, string) {
+	for _, c := range p.Status.Conditions {
+		if c.Type == apiv1.PodReady && c.Status == apiv1.ConditionTrue {
+			return NodeStateReady, ""
+		}
+	}
+
+	// The init container is restarted when it fails, so the failure may be the last one
+	for _, s := range p.Status.InitContainerStatuses {
+		for _, t := range []*apiv1.ContainerStateTerminated{s.State.Terminated, s.LastTerminationState.Terminated} {
+			if t != nil && t.ExitCode != 0 {
+				return NodeStateFailed, fmt.Sprintf("%s exited with code %d after %d restarts", s.Name, t.ExitCode, s.RestartCount)
+			}
+		}
+	}
+
+	return NodeStatePreparing, ""
+}
+
+// jobState tells the state of the cache from a clean job, it is empty while the job runs.
+func jobState(j batchv1.Job) (NodeState, string) {
+	for _, c := range j.Status.Conditions {
+		if c.Status != apiv1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return NodeStateCleaned, ""
+		case batchv1.JobFailed:
+			return NodeStateFailed, c.Message
+		}
+	}
+	return "", ""
+}
+
+func int32Ptr(i int32) *int32 { return &i }
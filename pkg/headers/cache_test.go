@@ -0,0 +1,210 @@
+package headers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestClient(objects ...runtime.Object) *CacheClient {
+	cs := fake.NewSimpleClientset(objects...)
+	return &CacheClient{
+		DaemonSetClient: cs.AppsV1().DaemonSets("default"),
+		PodClient:       cs.CoreV1().Pods("default"),
+		JobClient:       cs.BatchV1().Jobs("default"),
+		NodeClient:      cs.CoreV1().Nodes(),
+	}
+}
+
+func newTestNode(name, kernelVersion string, labels map[string]string) *apiv1.Node {
+	return &apiv1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status: apiv1.NodeStatus{
+			NodeInfo: apiv1.NodeSystemInfo{KernelVersion: kernelVersion},
+		},
+	}
+}
+
+func newTestPod(name, node string, status apiv1.PodStatus) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{meta.HeadersCacheLabelKey: prepareLabel},
+		},
+		Spec:   apiv1.PodSpec{NodeName: node},
+		Status: status,
+	}
+}
+
+func TestPrepare(t *testing.T) {
+	c := newTestClient()
+
+	ds, err := c.Prepare(Prepare{
+		Namespace:        "default",
+		InitImageNameTag: "quay.io/iovisor/kubectl-trace-init:latest",
+		Source:           tracejob.HeadersSource{PVC: "linux-headers"},
+		NodeSelector:     "pool=tracing,kernel in (5.4,5.10)",
+	})
+	require.Nil(t, err)
+
+	spec := ds.Spec.Template.Spec
+	assert.Equal(t, DaemonSetName, ds.Name)
+	require.NotNil(t, spec.Affinity)
+	assert.Equal(t, []apiv1.NodeSelectorTerm{{
+		MatchExpressions: []apiv1.NodeSelectorRequirement{
+			{Key: "pool", Operator: apiv1.NodeSelectorOpIn, Values: []string{"tracing"}},
+			{Key: "kernel", Operator: apiv1.NodeSelectorOpIn, Values: []string{"5.10", "5.4"}},
+		},
+	}}, spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms)
+	require.Len(t, spec.InitContainers, 1)
+	assert.Equal(t, "quay.io/iovisor/kubectl-trace-init:latest", spec.InitContainers[0].Image)
+	assert.Equal(t, "HEADERS_SOURCE_DIR", spec.InitContainers[0].Env[0].Name)
+	require.NotNil(t, spec.Containers[0].ReadinessProbe)
+
+	// Preparing again updates the DaemonSet
+	ds, err = c.Prepare(Prepare{
+		Namespace:        "default",
+		InitImageNameTag: "quay.io/iovisor/kubectl-trace-init:v2",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "quay.io/iovisor/kubectl-trace-init:v2", ds.Spec.Template.Spec.InitContainers[0].Image)
+	assert.Nil(t, ds.Spec.Template.Spec.Affinity)
+}
+
+func TestPrepareHeadersImagePerKernel(t *testing.T) {
+	_, err := newTestClient().Prepare(Prepare{
+		Namespace: "default",
+		Source:    tracejob.HeadersSource{Image: "registry.internal/linux-headers:{release}"},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestStatusNotPrepared(t *testing.T) {
+	_, err := newTestClient().Status()
+	assert.EqualError(t, err, "headers are not being prepared, use headers prepare first")
+}
+
+func TestStatus(t *testing.T) {
+	c := newTestClient(
+		newTestNode("node-a", "5.4.0-1029-gke", nil),
+		newTestNode("node-b", "4.19.112+", nil),
+		newTestNode("node-c", "4.19.112+", nil),
+		newTestPod("ready", "node-a", apiv1.PodStatus{
+			Conditions: []apiv1.PodCondition{
+				apiv1.PodCondition{Type: apiv1.PodReady, Status: apiv1.ConditionTrue},
+			},
+		}),
+		newTestPod("failed", "node-b", apiv1.PodStatus{
+			InitContainerStatuses: []apiv1.ContainerStatus{
+				apiv1.ContainerStatus{
+					Name:         "kubectl-trace-init",
+					RestartCount: 3,
+					State: apiv1.ContainerState{
+						Waiting: &apiv1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+					},
+					LastTerminationState: apiv1.ContainerState{
+						Terminated: &apiv1.ContainerStateTerminated{ExitCode: 22},
+					},
+				},
+			},
+		}),
+		newTestPod("preparing", "node-c", apiv1.PodStatus{}),
+		newTestPod("pending", "", apiv1.PodStatus{}),
+	)
+	_, err := c.Prepare(Prepare{Namespace: "default"})
+	require.Nil(t, err)
+
+	statuses, err := c.Status()
+	require.Nil(t, err)
+
+	assert.Equal(t, []NodeStatus{
+		{Node: "node-a", KernelVersion: "5.4.0-1029-gke", State: NodeStateReady},
+		{Node: "node-b", KernelVersion: "4.19.112+", State: NodeStateFailed, Message: "kubectl-trace-init exited with code 22 after 3 restarts"},
+		{Node: "node-c", KernelVersion: "4.19.112+", State: NodeStatePreparing},
+	}, statuses)
+}
+
+func TestClean(t *testing.T) {
+	c := newTestClient(
+		newTestNode("node-a", "5.4.0-1029-gke", map[string]string{"pool": "tracing"}),
+		newTestNode("node-b", "4.19.112+", map[string]string{"pool": "tracing"}),
+		newTestNode("node-c", "4.19.112+", nil),
+	)
+	_, err := c.Prepare(Prepare{Namespace: "default"})
+	require.Nil(t, err)
+
+	jobs, err := c.Clean(Clean{Namespace: "default", ImageNameTag: "quay.io/iovisor/kubectl-trace-init:latest", NodeSelector: "pool=tracing", All: true})
+	require.Nil(t, err)
+
+	require.Len(t, jobs, 2)
+	nodes := []string{}
+	for _, j := range jobs {
+		nodes = append(nodes, j.Spec.Template.Spec.NodeName)
+		assert.Equal(t, "1", j.Spec.Template.Spec.Containers[0].Env[0].Value)
+	}
+	assert.ElementsMatch(t, []string{"node-a", "node-b"}, nodes)
+
+	// The headers keep being prepared on the other nodes
+	ds, err := c.DaemonSetClient.Get(context.Background(), DaemonSetName, metav1.GetOptions{})
+	require.Nil(t, err)
+	terms := ds.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+	require.Len(t, terms, 1)
+	excluded := []string{}
+	for _, r := range terms[0].MatchFields {
+		assert.Equal(t, "metadata.name", r.Key)
+		assert.Equal(t, apiv1.NodeSelectorOpNotIn, r.Operator)
+		excluded = append(excluded, r.Values...)
+	}
+	assert.ElementsMatch(t, []string{"node-a", "node-b"}, excluded)
+
+	// Cleaning all the nodes stops preparing headers
+	jobs, err = c.Clean(Clean{Namespace: "default", ImageNameTag: "quay.io/iovisor/kubectl-trace-init:latest"})
+	require.Nil(t, err)
+	assert.Len(t, jobs, 3)
+	_, err = c.Status()
+	assert.NotNil(t, err, "cleaning all the nodes stops preparing headers")
+}
+
+func TestWaitCleaned(t *testing.T) {
+	c := newTestClient(
+		newTestNode("node-a", "5.4.0-1029-gke", nil),
+		newTestNode("node-b", "4.19.112+", nil),
+	)
+	jobs, err := c.Clean(Clean{Namespace: "default"})
+	require.Nil(t, err)
+	require.Len(t, jobs, 2)
+
+	for i, j := range jobs {
+		condition := batchv1.JobCondition{Type: batchv1.JobComplete, Status: apiv1.ConditionTrue}
+		if i == 1 {
+			condition = batchv1.JobCondition{Type: batchv1.JobFailed, Status: apiv1.ConditionTrue, Message: "BackoffLimitExceeded"}
+		}
+		j.Status.Conditions = append(j.Status.Conditions, condition)
+		_, err := c.JobClient.UpdateStatus(context.Background(), &j, metav1.UpdateOptions{})
+		require.Nil(t, err)
+	}
+
+	statuses, err := c.WaitCleaned(context.Background(), jobs)
+	require.Nil(t, err)
+
+	require.Len(t, statuses, 2)
+	states := map[NodeState]int{}
+	for _, s := range statuses {
+		states[s.State]++
+	}
+	assert.Equal(t, map[NodeState]int{NodeStateCleaned: 1, NodeStateFailed: 1}, states)
+
+	jl, err := c.JobClient.List(context.Background(), metav1.ListOptions{})
+	require.Nil(t, err)
+	assert.Empty(t, jl.Items)
+}
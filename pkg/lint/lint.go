@@ -0,0 +1,486 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Options tunes the checks to the way the program is going to be run.
+type Options struct {
+	// InPod is true when the program runs against a pod and trace-runner substitutes the container variables.
+	InPod bool
+	// BeforeContainer is true when the program starts before the container of the pod, whose pid is not known then.
+	BeforeContainer bool
+	// Args is the number of positional parameters passed to the program, $1 to $Args can be used.
+	Args int
+}
+
+// Problem is an issue found in a bpftrace program.
+type Problem struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%d:%d: %s", p.Line, p.Column, p.Message)
+}
+
+// ContainerPidVariable is substituted by trace-runner with the pid of the target container.
+const ContainerPidVariable = "$container_pid"
+
+// ContainerCgroupVariable is substituted by trace-runner with the cgroup id of the target container.
+// When the trace starts before the container, it matches the cgroups of all the containers of the pod instead.
+const ContainerCgroupVariable = "$container_cgroup"
+
+// unsafeBuiltins require bpftrace --unsafe, which trace-runner never passes.
+var unsafeBuiltins = map[string]bool{
+	"system":   true,
+	"signal":   true,
+	"override": true,
+}
+
+// probeAliases maps the short probe types to their full name.
+var probeAliases = map[string]string{
+	"k":      "kprobe",
+	"kr":     "kretprobe",
+	"u":      "uprobe",
+	"ur":     "uretprobe",
+	"U":      "usdt",
+	"t":      "tracepoint",
+	"s":      "software",
+	"h":      "hardware",
+	"p":      "profile",
+	"i":      "interval",
+	"f":      "kfunc",
+	"fr":     "kretfunc",
+	"fentry": "kfunc",
+	"fexit":  "kretfunc",
+	"w":      "watchpoint",
+	"aw":     "asyncwatchpoint",
+}
+
+// probeArity is the minimum and maximum number of colon separated fields for each probe type.
+var probeArity = map[string][2]int{
+	"kprobe":          {2, 3},
+	"kretprobe":       {2, 3},
+	"uprobe":          {3, 4},
+	"uretprobe":       {3, 4},
+	"usdt":            {2, 4},
+	"tracepoint":      {3, 3},
+	"rawtracepoint":   {2, 2},
+	"software":        {2, 3},
+	"hardware":        {2, 3},
+	"profile":         {3, 3},
+	"interval":        {3, 3},
+	"kfunc":           {2, 3},
+	"kretfunc":        {2, 3},
+	"watchpoint":      {4, 4},
+	"asyncwatchpoint": {4, 4},
+}
+
+var timeUnits = map[string]bool{
+	"s":  true,
+	"ms": true,
+	"us": true,
+	"hz": true,
+}
+
+var numberRegexp = regexp.MustCompile(`^[0-9]+$`)
+
+var commentRegexp = regexp.MustCompile(`//[^\n]*|/\*(?s:.*?)\*/`)
+
+var closers = map[byte]byte{
+	'}': '{',
+	')': '(',
+	']': '[',
+}
+
+type position struct {
+	offset int
+	line   int
+	column int
+}
+
+type linter struct {
+	opts     Options
+	src      string
+	pos      position
+	problems []Problem
+}
+
+// Lint checks the program for mistakes that would otherwise only be reported once it runs on a node.
+// It is not a full bpftrace parser: it verifies bracket balance, probe definitions,
+// variables that trace-runner does not substitute and builtins that trace-runner does not allow.
+func Lint(program string, opts Options) []Problem {
+	l := &linter{
+		opts: opts,
+		src:  program,
+		pos:  position{line: 1, column: 1},
+	}
+	l.run()
+	return l.problems
+}
+
+func (l *linter) report(at position, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{
+		Line:    at.line,
+		Column:  at.column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *linter) eof() bool {
+	return l.pos.offset >= len(l.src)
+}
+
+func (l *linter) peek(n int) byte {
+	if l.pos.offset+n >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos.offset+n]
+}
+
+func (l *linter) advance() {
+	if l.src[l.pos.offset] == '\n' {
+		l.pos.line++
+		l.pos.column = 1
+	} else {
+		l.pos.column++
+	}
+	l.pos.offset++
+}
+
+func (l *linter) run() {
+	var stack []position
+	header := position{offset: -1}
+
+	for !l.eof() {
+		c := l.peek(0)
+		at := l.pos
+
+		switch {
+		case c == '/' && l.peek(1) == '/':
+			for !l.eof() && l.peek(0) != '\n' {
+				l.advance()
+			}
+			continue
+		case c == '/' && l.peek(1) == '*':
+			l.skipBlockComment(at)
+			continue
+		case c == '#' && len(stack) == 0 && header.offset < 0:
+			// preprocessor directives like #include
+			for !l.eof() && l.peek(0) != '\n' {
+				l.advance()
+			}
+			continue
+		}
+
+		if len(stack) == 0 && header.offset < 0 && !isSpace(c) && c != ';' && c != '{' {
+			header = at
+		}
+
+		switch {
+		case c == '"':
+			l.skipString(at)
+			continue
+		case c == '$':
+			l.checkVariable(at)
+			continue
+		case isIdentStart(c):
+			l.checkIdentifier(at, len(stack) > 0)
+			continue
+		}
+
+		switch c {
+		case '{', '(', '[':
+			if c == '{' && len(stack) == 0 {
+				l.checkHeader(header, at)
+				header = position{offset: -1}
+			}
+			stack = append(stack, at)
+		case '}', ')', ']':
+			stack = l.closeBracket(stack, at)
+		}
+		l.advance()
+	}
+
+	for _, open := range stack {
+		l.report(open, "'%c' is never closed", l.src[open.offset])
+	}
+	if header.offset >= 0 && len(stack) == 0 {
+		l.report(header, "probe definition without an action block")
+	}
+}
+
+// closeBracket pops the bracket closed at the given position, reporting the ones left open in between.
+func (l *linter) closeBracket(stack []position, at position) []position {
+	opener := closers[l.src[at.offset]]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if l.src[stack[i].offset] != opener {
+			continue
+		}
+		for _, open := range stack[i+1:] {
+			l.report(open, "'%c' is never closed", l.src[open.offset])
+		}
+		return stack[:i]
+	}
+	l.report(at, "unexpected '%c'", l.src[at.offset])
+	return stack
+}
+
+func (l *linter) skipBlockComment(at position) {
+	l.advance()
+	l.advance()
+	for !l.eof() {
+		if l.peek(0) == '*' && l.peek(1) == '/' {
+			l.advance()
+			l.advance()
+			return
+		}
+		l.advance()
+	}
+	l.report(at, "comment is never closed")
+}
+
+func (l *linter) skipString(at position) {
+	l.advance()
+	for !l.eof() {
+		c := l.peek(0)
+		switch {
+		case c == '\\':
+			l.advance()
+			if !l.eof() {
+				l.advance()
+			}
+			continue
+		case c == '"':
+			l.advance()
+			return
+		case c == '\n':
+			l.report(at, "string is never closed")
+			return
+		case c == '$':
+			// trace-runner replaces its variables everywhere, strings included
+			if strings.HasPrefix(l.src[l.pos.offset:], "$container_") {
+				l.checkVariable(l.pos)
+				continue
+			}
+		}
+		l.advance()
+	}
+	l.report(at, "string is never closed")
+}
+
+func (l *linter) checkVariable(at position) {
+	l.advance()
+	start := l.pos.offset
+	for !l.eof() && (isIdentStart(l.peek(0)) || isDigit(l.peek(0))) {
+		l.advance()
+	}
+	if start == l.pos.offset && l.peek(0) == '#' {
+		l.advance()
+	}
+	name := "$" + l.src[start:l.pos.offset]
+
+	switch {
+	case name == "$":
+		l.report(at, "'$' must be followed by a variable name")
+	case name == ContainerPidVariable || name == ContainerCgroupVariable:
+		if !l.opts.InPod {
+			l.report(at, "%s is only available when tracing a pod", name)
+		} else if l.opts.BeforeContainer && name == ContainerPidVariable {
+			l.report(at, "%s is not known when the trace starts before the container, use %s instead", name, ContainerCgroupVariable)
+		}
+	case strings.HasPrefix(name, "$container_"):
+		l.report(at, "%s is not a variable provided by trace-runner, only %s and %s are", name, ContainerPidVariable, ContainerCgroupVariable)
+	case name == "$#":
+	case isDigit(name[1]):
+		n, err := strconv.Atoi(name[1:])
+		if err != nil || n == 0 {
+			l.report(at, "%s is not a positional parameter, they start at $1", name)
+		} else if n > l.opts.Args {
+			l.report(at, "positional parameter %s is not given, %s", name, argsGiven(l.opts.Args))
+		}
+	}
+}
+
+// argsGiven tells how many positional parameters are passed, for the problems.
+func argsGiven(n int) string {
+	switch n {
+	case 0:
+		return "none is passed with --arg"
+	case 1:
+		return "only 1 is passed with --arg"
+	}
+	return fmt.Sprintf("only %d are passed with --arg", n)
+}
+
+func (l *linter) checkIdentifier(at position, inBlock bool) {
+	start := l.pos.offset
+	for !l.eof() && (isIdentStart(l.peek(0)) || isDigit(l.peek(0))) {
+		l.advance()
+	}
+	if !inBlock {
+		return
+	}
+
+	name := l.src[start:l.pos.offset]
+	if !unsafeBuiltins[name] {
+		return
+	}
+
+	rest := strings.TrimLeft(l.src[l.pos.offset:], " \t")
+	if strings.HasPrefix(rest, "(") {
+		l.report(at, "%s() is an unsafe builtin and trace-runner does not run bpftrace with --unsafe", name)
+	}
+}
+
+// checkHeader validates the probes and predicate found between header and the opening brace at block.
+func (l *linter) checkHeader(header, block position) {
+	if header.offset < 0 {
+		l.report(block, "action block without a probe")
+		return
+	}
+
+	text := commentRegexp.ReplaceAllString(l.src[header.offset:block.offset], "")
+	trimmed := strings.TrimSpace(text)
+	for _, kw := range []string{"struct", "union", "enum"} {
+		if strings.HasPrefix(trimmed, kw+" ") {
+			return
+		}
+	}
+
+	// A predicate starts with a slash that does not belong to a probe, so it is preceded by a space
+	probes := trimmed
+	if i := predicateStart(trimmed); i >= 0 {
+		probes = strings.TrimSpace(trimmed[:i])
+		predicate := strings.TrimSpace(trimmed[i:])
+		if len(predicate) < 2 || !strings.HasSuffix(predicate, "/") {
+			l.report(header, "predicate must be enclosed in slashes")
+		}
+	}
+
+	if len(probes) == 0 {
+		l.report(header, "action block without a probe")
+		return
+	}
+
+	for _, p := range splitOutsideQuotes(probes, ',') {
+		p = strings.TrimSpace(p)
+		if msg := checkProbe(p); msg != "" {
+			l.report(header, "%s", msg)
+		}
+	}
+}
+
+func predicateStart(header string) int {
+	inQuotes := false
+	for i := 0; i < len(header); i++ {
+		switch header[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case '/':
+			if !inQuotes && i > 0 && isSpace(header[i-1]) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func checkProbe(probe string) string {
+	if len(probe) == 0 {
+		return "empty probe in probe list"
+	}
+	if probe == "BEGIN" || probe == "END" {
+		return ""
+	}
+
+	fields := splitOutsideQuotes(probe, ':')
+	if len(fields) == 1 {
+		return fmt.Sprintf("invalid probe %q, expected TYPE:... or BEGIN/END", probe)
+	}
+
+	typ := fields[0]
+	if full, ok := probeAliases[typ]; ok {
+		typ = full
+	}
+	arity, ok := probeArity[typ]
+	if !ok {
+		return fmt.Sprintf("unknown probe type %q in %q", fields[0], probe)
+	}
+	if len(fields) < arity[0] || len(fields) > arity[1] {
+		return fmt.Sprintf("invalid %s probe %q, %s", typ, probe, probeUsage(typ))
+	}
+	for i, f := range fields[1:] {
+		// software and hardware probes have an optional trailing count
+		if len(f) == 0 && !((typ == "software" || typ == "hardware") && i == len(fields)-2) {
+			return fmt.Sprintf("invalid %s probe %q, %s", typ, probe, probeUsage(typ))
+		}
+	}
+
+	if typ == "interval" || typ == "profile" {
+		if !timeUnits[fields[1]] {
+			return fmt.Sprintf("invalid %s probe %q, unit must be one of s, ms, us or hz", typ, probe)
+		}
+		if !numberRegexp.MatchString(fields[2]) {
+			return fmt.Sprintf("invalid %s probe %q, rate must be a number", typ, probe)
+		}
+	}
+	return ""
+}
+
+func probeUsage(typ string) string {
+	switch typ {
+	case "kprobe", "kretprobe", "kfunc", "kretfunc":
+		return fmt.Sprintf("expected %s:FUNCTION", typ)
+	case "uprobe", "uretprobe":
+		return fmt.Sprintf("expected %s:PATH:FUNCTION", typ)
+	case "usdt":
+		return "expected usdt:PATH:[PROVIDER:]PROBE"
+	case "tracepoint":
+		return "expected tracepoint:CATEGORY:NAME"
+	case "rawtracepoint":
+		return "expected rawtracepoint:NAME"
+	case "software", "hardware":
+		return fmt.Sprintf("expected %s:EVENT[:COUNT]", typ)
+	case "profile", "interval":
+		return fmt.Sprintf("expected %s:UNIT:RATE", typ)
+	case "watchpoint", "asyncwatchpoint":
+		return fmt.Sprintf("expected %s:ADDRESS:LENGTH:MODE", typ)
+	}
+	return ""
+}
+
+func splitOutsideQuotes(s string, sep byte) []string {
+	var parts []string
+	inQuotes := false
+	last := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			inQuotes = !inQuotes
+		case sep:
+			if !inQuotes {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	return append(parts, s[last:])
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
@@ -0,0 +1,205 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintValidPrograms(t *testing.T) {
+	tests := []struct {
+		name    string
+		program string
+		opts    Options
+	}{
+		{
+			name:    "kprobe",
+			program: `kprobe:do_sys_open { printf("%s: %s\n", comm, str(arg1)) }`,
+		},
+		{
+			name:    "tracepoint with wildcard",
+			program: `tracepoint:syscalls:sys_enter_* { @[probe] = count(); }`,
+		},
+		{
+			name:    "positional parameters",
+			program: `kprobe:do_sys_open { printf("%s %d %d\n", comm, $1, $#); }`,
+			opts:    Options{Args: 1},
+		},
+		{
+			name: "begin end and interval",
+			program: `BEGIN { printf("tracing\n"); }
+interval:s:1 { print(@); clear(@); }
+END { clear(@); }`,
+		},
+		{
+			name:    "multiple probes with predicate",
+			program: `kprobe:vfs_read, kprobe:vfs_write /pid == 1/ { @[func] = count(); }`,
+		},
+		{
+			name:    "uretprobe on container binary",
+			program: `uretprobe:/proc/$container_pid/exe:"main.counterValue" { printf("%d\n", retval) }`,
+			opts:    Options{InPod: true},
+		},
+		{
+			name: "include struct and comments",
+			program: `#include <linux/sched.h>
+struct foo { int x; };
+// count opens
+/* per process */
+kprobe:do_sys_open // trailing comment
+{
+	$name = str(arg1); // scratch variables are fine
+	@[comm, $name] = count();
+}`,
+		},
+		{
+			name:    "short aliases",
+			program: `t:syscalls:sys_enter_openat, k:vfs_read, ur:/bin/bash:readline, p:hz:99 { @ = count(); }`,
+		},
+		{
+			name:    "software probe without count",
+			program: `software:faults: { @ = count(); }`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Empty(t, Lint(tt.program, tt.opts))
+		})
+	}
+}
+
+func TestLintProblems(t *testing.T) {
+	tests := []struct {
+		name     string
+		program  string
+		opts     Options
+		expected []Problem
+	}{
+		{
+			name:    "missing closing brace",
+			program: "kprobe:do_sys_open {\n\tprintf(\"hello\\n\");\n",
+			expected: []Problem{
+				{Line: 1, Column: 20, Message: "'{' is never closed"},
+			},
+		},
+		{
+			name:    "mismatched parenthesis",
+			program: `kprobe:do_sys_open { printf("hello\n"]; }`,
+			expected: []Problem{
+				{Line: 1, Column: 38, Message: "unexpected ']'"},
+				{Line: 1, Column: 28, Message: "'(' is never closed"},
+			},
+		},
+		{
+			name:    "unknown probe type",
+			program: `kprob:do_sys_open { }`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: `unknown probe type "kprob" in "kprob:do_sys_open"`},
+			},
+		},
+		{
+			name:    "tracepoint missing name",
+			program: `tracepoint:syscalls { }`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: `invalid tracepoint probe "tracepoint:syscalls", expected tracepoint:CATEGORY:NAME`},
+			},
+		},
+		{
+			name:    "uprobe missing function",
+			program: `uprobe:/bin/bash: { }`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: `invalid uprobe probe "uprobe:/bin/bash:", expected uprobe:PATH:FUNCTION`},
+			},
+		},
+		{
+			name:    "invalid interval unit",
+			program: `interval:m:1 { }`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: `invalid interval probe "interval:m:1", unit must be one of s, ms, us or hz`},
+			},
+		},
+		{
+			name:    "missing probe",
+			program: `{ @ = count(); }`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: "action block without a probe"},
+			},
+		},
+		{
+			name:    "container pid on a node",
+			program: `uprobe:/proc/$container_pid/exe:main { }`,
+			expected: []Problem{
+				{Line: 1, Column: 14, Message: "$container_pid is only available when tracing a pod"},
+			},
+		},
+		{
+			name:    "container cgroup",
+			program: `tracepoint:syscalls:sys_enter_execve /cgroup == $container_cgroup/ { printf("%s\n", comm); }`,
+			opts:    Options{InPod: true},
+		},
+		{
+			name:    "container pid before the container",
+			program: `uprobe:/proc/$container_pid/exe:main /cgroup == $container_cgroup/ { }`,
+			opts:    Options{InPod: true, BeforeContainer: true},
+			expected: []Problem{
+				{Line: 1, Column: 14, Message: "$container_pid is not known when the trace starts before the container, use $container_cgroup instead"},
+			},
+		},
+		{
+			name:    "unknown container variable",
+			program: `kprobe:do_sys_open /pid == $container_id/ { printf("$container_name\n"); }`,
+			opts:    Options{InPod: true},
+			expected: []Problem{
+				{Line: 1, Column: 28, Message: "$container_id is not a variable provided by trace-runner, only $container_pid and $container_cgroup are"},
+				{Line: 1, Column: 53, Message: "$container_name is not a variable provided by trace-runner, only $container_pid and $container_cgroup are"},
+			},
+		},
+		{
+			name:    "positional parameters",
+			program: `kprobe:do_sys_open /pid == $1/ { printf("%d %d %s\n", $#, $0, str($3)); }`,
+			opts:    Options{Args: 2},
+			expected: []Problem{
+				{Line: 1, Column: 59, Message: "$0 is not a positional parameter, they start at $1"},
+				{Line: 1, Column: 67, Message: "positional parameter $3 is not given, only 2 are passed with --arg"},
+			},
+		},
+		{
+			name:    "positional parameters without args",
+			program: `kprobe:do_sys_open /pid == $1/ { printf("%d\n", $#); }`,
+			expected: []Problem{
+				{Line: 1, Column: 28, Message: "positional parameter $1 is not given, none is passed with --arg"},
+			},
+		},
+		{
+			name:    "unsafe builtins",
+			program: "kprobe:do_sys_open {\n\tsystem(\"ls\");\n\tsignal(\"KILL\");\n}",
+			expected: []Problem{
+				{Line: 2, Column: 2, Message: "system() is an unsafe builtin and trace-runner does not run bpftrace with --unsafe"},
+				{Line: 3, Column: 2, Message: "signal() is an unsafe builtin and trace-runner does not run bpftrace with --unsafe"},
+			},
+		},
+		{
+			name:    "unterminated string",
+			program: "kprobe:do_sys_open { printf(\"hello); }\n",
+			expected: []Problem{
+				{Line: 1, Column: 29, Message: "string is never closed"},
+				{Line: 1, Column: 20, Message: "'{' is never closed"},
+				{Line: 1, Column: 28, Message: "'(' is never closed"},
+			},
+		},
+		{
+			name:    "probe without action",
+			program: `kprobe:do_sys_open`,
+			expected: []Problem{
+				{Line: 1, Column: 1, Message: "probe definition without an action block"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Lint(tt.program, tt.opts))
+		})
+	}
+}
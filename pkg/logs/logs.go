@@ -2,13 +2,17 @@ package logs
 
 import (
 	"context"
+	"time"
 
 	"github.com/iovisor/kubectl-trace/pkg/meta"
+	tbatchv1 "k8s.io/client-go/kubernetes/typed/batch/v1"
 	tcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 
 	"fmt"
@@ -20,6 +24,7 @@ import (
 type Logs struct {
 	genericclioptions.IOStreams
 	coreV1Client tcorev1.CoreV1Interface
+	jobsClient   tbatchv1.JobsGetter
 }
 
 func NewLogs(client tcorev1.CoreV1Interface, streams genericclioptions.IOStreams) *Logs {
@@ -29,6 +34,20 @@ func NewLogs(client tcorev1.CoreV1Interface, streams genericclioptions.IOStreams
 	}
 }
 
+// WithJobsClient has Stream give up waiting once the job of the trace failed.
+func (l *Logs) WithJobsClient(client tbatchv1.JobsGetter) {
+	l.jobsClient = client
+}
+
+// waitingErrorReasons are the reasons a container waits for that it is not going to get over by itself.
+var waitingErrorReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
 const (
 	podNotFoundError              = "no trace found to get logs from with the given selector"
 	podPhaseNotAcceptedError      = "cannot get logs from a completed trace; current phase is %s"
@@ -68,11 +87,109 @@ func (l *Logs) Run(jobID types.UID, namespace string, follow bool, timestamps bo
 
 	logsRequest := l.coreV1Client.Pods(namespace).GetLogs(pod.Name, logOptions)
 
-	return consumeRequest(logsRequest, l.IOStreams.Out)
+	return consumeRequest(context.Background(), logsRequest, l.IOStreams.Out)
+}
+
+// Stream waits for the trace container to start and follows its logs until it terminates.
+// Unlike Run, it also returns the logs of traces that already failed. It fails when the trace pod cannot start,
+// or when the job of the trace failed if it knows the jobs.
+func (l *Logs) Stream(ctx context.Context, jobID types.UID, namespace string) error {
+	selector := fmt.Sprintf("%s=%s", meta.TraceIDLabelKey, jobID)
+	var pod *corev1.Pod
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		pl, err := l.coreV1Client.Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+		for i, p := range pl.Items {
+			if len(p.Status.ContainerStatuses) == 0 {
+				continue
+			}
+			state := p.Status.ContainerStatuses[0].State
+			if state.Running != nil || state.Terminated != nil {
+				pod = &pl.Items[i]
+				return true, nil
+			}
+		}
+		// The job retries a pod that failed, only the failure of the job is final when it is known
+		for i := range pl.Items {
+			if err := podStartError(&pl.Items[i], l.jobsClient == nil); err != nil {
+				return false, err
+			}
+		}
+		if l.jobsClient != nil {
+			return false, l.jobError(ctx, namespace, selector)
+		}
+		return false, nil
+	}, ctx.Done())
+	if err != nil {
+		return err
+	}
+
+	if len(pod.Spec.Containers) != 1 {
+		return fmt.Errorf(invalidPodContainersSizeError)
+	}
+
+	logsRequest := l.coreV1Client.Pods(namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+		Container: pod.Spec.Containers[0].Name,
+		Follow:    true,
+	})
+
+	return consumeRequest(ctx, logsRequest, l.IOStreams.Out)
+}
+
+// podStartError tells why the trace pod is not going to start, if it is not. A failed pod counts only when failed is.
+func podStartError(pod *corev1.Pod, failed bool) error {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
+			return fmt.Errorf("trace pod %s cannot be scheduled: %s", pod.Name, c.Message)
+		}
+	}
+
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, cs := range statuses {
+		if w := cs.State.Waiting; w != nil && waitingErrorReasons[w.Reason] {
+			return fmt.Errorf("container %s of trace pod %s cannot start: %s: %s", cs.Name, pod.Name, w.Reason, w.Message)
+		}
+	}
+
+	if !failed || pod.Status.Phase != corev1.PodFailed {
+		return nil
+	}
+	for _, cs := range pod.Status.InitContainerStatuses {
+		if t := cs.State.Terminated; t != nil && t.ExitCode != 0 {
+			return fmt.Errorf("init container %s of trace pod %s failed with exit code %d: %s", cs.Name, pod.Name, t.ExitCode, reasonMessage(t.Reason, t.Message))
+		}
+	}
+	return fmt.Errorf("trace pod %s failed: %s", pod.Name, reasonMessage(pod.Status.Reason, pod.Status.Message))
+}
+
+// reasonMessage joins a reason and a message, either of which may be empty.
+func reasonMessage(reason, message string) string {
+	if len(reason) > 0 && len(message) > 0 {
+		return reason + ": " + message
+	}
+	return reason + message
+}
+
+// jobError tells why the job of the trace failed, if it did.
+func (l *Logs) jobError(ctx context.Context, namespace, selector string) error {
+	jl, err := l.jobsClient.Jobs(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return err
+	}
+	for _, j := range jl.Items {
+		for _, c := range j.Status.Conditions {
+			if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return fmt.Errorf("trace job %s failed: %s", j.Name, reasonMessage(c.Reason, c.Message))
+			}
+		}
+	}
+	return nil
 }
 
-func consumeRequest(request *rest.Request, out io.Writer) error {
-	readCloser, err := request.Stream(context.Background())
+func consumeRequest(ctx context.Context, request *rest.Request, out io.Writer) error {
+	readCloser, err := request.Stream(ctx)
 	if err != nil {
 		return err
 	}
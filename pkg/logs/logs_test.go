@@ -0,0 +1,101 @@
+package logs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testPod(status corev1.PodStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kubectl-trace-1234-abcd",
+			Namespace: "default",
+			Labels:    map[string]string{meta.TraceIDLabelKey: "1234"},
+		},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "kubectl-trace-1234"}}},
+		Status: status,
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		objects  []runtime.Object
+		expected string
+	}{
+		{
+			name: "unschedulable",
+			objects: []runtime.Object{testPod(corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{
+					Type:    corev1.PodScheduled,
+					Status:  corev1.ConditionFalse,
+					Reason:  corev1.PodReasonUnschedulable,
+					Message: "0/3 nodes are available",
+				}},
+			})},
+			expected: "trace pod kubectl-trace-1234-abcd cannot be scheduled: 0/3 nodes are available",
+		},
+		{
+			name: "image pull",
+			objects: []runtime.Object{testPod(corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "kubectl-trace-1234",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "Back-off pulling image"}},
+				}},
+			})},
+			expected: "container kubectl-trace-1234 of trace pod kubectl-trace-1234-abcd cannot start: ImagePullBackOff: Back-off pulling image",
+		},
+		{
+			name: "init container failed",
+			objects: []runtime.Object{
+				testPod(corev1.PodStatus{
+					Phase: corev1.PodFailed,
+					InitContainerStatuses: []corev1.ContainerStatus{{
+						Name:  "kubectl-trace-init",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 22, Reason: "Error"}},
+					}},
+				}),
+				&batchv1.Job{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kubectl-trace-1234",
+						Namespace: "default",
+						Labels:    map[string]string{meta.TraceIDLabelKey: "1234"},
+					},
+					Status: batchv1.JobStatus{
+						Conditions: []batchv1.JobCondition{{
+							Type:    batchv1.JobFailed,
+							Status:  corev1.ConditionTrue,
+							Reason:  "BackoffLimitExceeded",
+							Message: "Job has reached the specified backoff limit",
+						}},
+					},
+				},
+			},
+			expected: "trace job kubectl-trace-1234 failed: BackoffLimitExceeded: Job has reached the specified backoff limit",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(tt.objects...)
+			l := NewLogs(cs.CoreV1(), genericclioptions.NewTestIOStreamsDiscard())
+			l.WithJobsClient(cs.BatchV1())
+			assert.EqualError(t, l.Stream(context.Background(), "1234", "default"), tt.expected)
+		})
+	}
+}
+
+func TestStreamFailedPodWithoutJobs(t *testing.T) {
+	cs := fake.NewSimpleClientset(testPod(corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted", Message: "The node was low on resource: memory."}))
+	l := NewLogs(cs.CoreV1(), genericclioptions.NewTestIOStreamsDiscard())
+	assert.EqualError(t, l.Stream(context.Background(), "1234", "default"), "trace pod kubectl-trace-1234-abcd failed: Evicted: The node was low on resource: memory.")
+}
@@ -7,6 +7,18 @@ const (
 	TraceIDLabelKey = "iovisor.org/kubectl-trace-id"
 	// TraceLabelKey is a meta to annotate objects created by this tool
 	TraceLabelKey = "iovisor.org/kubectl-trace"
+	// HeaderModeAnnotationKey records how a trace job provides kernel headers to bpftrace
+	HeaderModeAnnotationKey = "iovisor.org/kubectl-trace-headers"
+	// HeaderModeReasonAnnotationKey records why that header mode was picked
+	HeaderModeReasonAnnotationKey = "iovisor.org/kubectl-trace-headers-reason"
+	// SecurityProfileAnnotationKey records how much privilege a trace job runs with
+	SecurityProfileAnnotationKey = "iovisor.org/kubectl-trace-security-profile"
+	// TargetAnnotationKey records the node or pod a trace job runs against, as TYPE/NAME
+	TargetAnnotationKey = "iovisor.org/kubectl-trace-target"
+	// TargetNamespaceAnnotationKey records the namespace of the pod a trace job runs against
+	TargetNamespaceAnnotationKey = "iovisor.org/kubectl-trace-target-namespace"
+	// HeadersCacheLabelKey is a meta to label the objects managing the kernel headers cache of the nodes
+	HeadersCacheLabelKey = "iovisor.org/kubectl-trace-headers-cache"
 
 	// ObjectNamePrefix is the prefix used for objects created by kubectl-trace
 	ObjectNamePrefix = "kubectl-trace-"
@@ -0,0 +1,144 @@
+package nodeinfo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// Report describes what a node offers to bpftrace programs.
+type Report struct {
+	KernelVersion   string `json:"kernelVersion"`
+	BTF             bool   `json:"btf"`
+	Lockdown        string `json:"lockdown"`
+	AppArmor        bool   `json:"apparmor"`
+	Headers         bool   `json:"headers"`
+	HeadersPath     string `json:"headersPath,omitempty"`
+	CgroupVersion   int    `json:"cgroupVersion"`
+	BpftraceVersion string `json:"bpftraceVersion,omitempty"`
+}
+
+// These are the paths the facts are gathered from, as mounted in the trace-runner container.
+const (
+	osReleasePath  = "/proc/sys/kernel/osrelease"
+	btfPath        = "/sys/kernel/btf/vmlinux"
+	lockdownPath   = "/sys/kernel/security/lockdown"
+	apparmorPath   = "/sys/module/apparmor/parameters/enabled"
+	cgroupV2Path   = "/sys/fs/cgroup/cgroup.controllers"
+	modulesPath    = "/lib/modules"
+	usrHostPath    = "/usr-host"
+	lockdownNone   = "none"
+	lockdownAbsent = "unsupported"
+)
+
+// Gather collects the facts about the node, reading all the paths under root.
+// The bpftrace version is only collected when bpftraceBinaryPath is not empty.
+func Gather(root, bpftraceBinaryPath string) (*Report, error) {
+	r := &Report{}
+
+	b, err := ioutil.ReadFile(path.Join(root, osReleasePath))
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the kernel version: %s", err)
+	}
+	r.KernelVersion = strings.TrimSpace(string(b))
+
+	r.BTF = exists(path.Join(root, btfPath))
+	r.Lockdown = lockdownMode(path.Join(root, lockdownPath))
+	if b, err := ioutil.ReadFile(path.Join(root, apparmorPath)); err == nil {
+		r.AppArmor = strings.TrimSpace(string(b)) == "Y"
+	}
+	r.HeadersPath = headersPath(root, r.KernelVersion)
+	r.Headers = len(r.HeadersPath) > 0
+
+	r.CgroupVersion = 1
+	if exists(path.Join(root, cgroupV2Path)) {
+		r.CgroupVersion = 2
+	}
+
+	if len(bpftraceBinaryPath) > 0 {
+		out, err := exec.Command(bpftraceBinaryPath, "--version").Output()
+		if err != nil {
+			return nil, fmt.Errorf("could not determine the bpftrace version: %s", err)
+		}
+		r.BpftraceVersion = strings.TrimSpace(string(out))
+	}
+
+	return r, nil
+}
+
+// NeedsHeaders tells whether linux headers have to be fetched for programs to work on the node.
+// Kernels exposing BTF describe their own types, so headers are only needed when neither is available.
+func (r Report) NeedsHeaders() bool {
+	return !r.Headers && !r.BTF
+}
+
+// Parse finds the report in the output of trace-runner.
+func Parse(output string) (*Report, error) {
+	for _, l := range strings.Split(output, "\n") {
+		l = strings.TrimSpace(l)
+		if !strings.HasPrefix(l, "{") {
+			continue
+		}
+		r := &Report{}
+		if err := json.Unmarshal([]byte(l), r); err != nil {
+			return nil, fmt.Errorf("invalid node report: %s", err)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("no node report found in output: %s", strings.TrimSpace(output))
+}
+
+// lockdownMode returns the active mode, the one in brackets in "[none] integrity confidentiality".
+func lockdownMode(p string) string {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return lockdownAbsent
+	}
+	s := string(b)
+	start := strings.Index(s, "[")
+	end := strings.Index(s, "]")
+	if start < 0 || end < start {
+		return lockdownNone
+	}
+	return s[start+1 : end]
+}
+
+// headersPath returns where the headers for the kernel are, or an empty string if there are none.
+func headersPath(root, kernelVersion string) string {
+	for _, dir := range []string{"build", "source"} {
+		p := path.Join(modulesPath, kernelVersion, dir)
+		if isHeadersDir(path.Join(root, p)) {
+			return p
+		}
+
+		// The links usually point to /usr/src on the host, which is mounted elsewhere
+		target, err := os.Readlink(path.Join(root, p))
+		if err != nil {
+			continue
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		if !strings.HasPrefix(target, "/usr/") {
+			continue
+		}
+		hostTarget := path.Join(usrHostPath, strings.TrimPrefix(target, "/usr/"))
+		if isHeadersDir(path.Join(root, hostTarget)) {
+			return hostTarget
+		}
+	}
+	return ""
+}
+
+func isHeadersDir(p string) bool {
+	return exists(path.Join(p, "include", "linux", "kconfig.h")) || exists(path.Join(p, "include", "generated"))
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
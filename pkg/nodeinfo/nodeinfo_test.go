@@ -0,0 +1,92 @@
+package nodeinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, root, p, content string) {
+	require.Nil(t, os.MkdirAll(path.Join(root, path.Dir(p)), 0755))
+	require.Nil(t, ioutil.WriteFile(path.Join(root, p), []byte(content), 0644))
+}
+
+func TestGatherMinimalNode(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodeinfo")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	writeFile(t, root, osReleasePath, "4.19.76-linuxkit\n")
+
+	r, err := Gather(root, "")
+	require.Nil(t, err)
+
+	assert.Equal(t, &Report{
+		KernelVersion: "4.19.76-linuxkit",
+		Lockdown:      lockdownAbsent,
+		CgroupVersion: 1,
+	}, r)
+	assert.True(t, r.NeedsHeaders())
+}
+
+func TestGatherFullNode(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodeinfo")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	writeFile(t, root, osReleasePath, "5.8.0-1041-aws\n")
+	writeFile(t, root, btfPath, "")
+	writeFile(t, root, lockdownPath, "none [integrity] confidentiality\n")
+	writeFile(t, root, cgroupV2Path, "cpu memory\n")
+	writeFile(t, root, apparmorPath, "Y\n")
+	writeFile(t, root, "/usr-host/src/linux-headers-5.8.0-1041-aws/include/linux/kconfig.h", "")
+	require.Nil(t, os.MkdirAll(path.Join(root, modulesPath, "5.8.0-1041-aws"), 0755))
+	require.Nil(t, os.Symlink("/usr/src/linux-headers-5.8.0-1041-aws", path.Join(root, modulesPath, "5.8.0-1041-aws", "build")))
+
+	r, err := Gather(root, "")
+	require.Nil(t, err)
+
+	assert.Equal(t, &Report{
+		KernelVersion: "5.8.0-1041-aws",
+		BTF:           true,
+		Lockdown:      "integrity",
+		AppArmor:      true,
+		Headers:       true,
+		HeadersPath:   "/usr-host/src/linux-headers-5.8.0-1041-aws",
+		CgroupVersion: 2,
+	}, r)
+	assert.False(t, r.NeedsHeaders())
+}
+
+func TestGatherWithoutKernelVersion(t *testing.T) {
+	root, err := ioutil.TempDir("", "nodeinfo")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	_, err = Gather(root, "")
+	assert.NotNil(t, err)
+}
+
+func TestNeedsHeadersWithBTF(t *testing.T) {
+	assert.False(t, Report{BTF: true}.NeedsHeaders())
+}
+
+func TestParse(t *testing.T) {
+	output := "some runner message\r\n{\"kernelVersion\":\"5.4.0\",\"btf\":true,\"lockdown\":\"none\",\"headers\":false,\"cgroupVersion\":2}\r\n"
+
+	r, err := Parse(output)
+	require.Nil(t, err)
+	assert.Equal(t, &Report{
+		KernelVersion: "5.4.0",
+		BTF:           true,
+		Lockdown:      "none",
+		CgroupVersion: 2,
+	}, r)
+
+	_, err = Parse("ERROR: something went wrong\n")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,410 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// DefaultNamespace is the namespace dedicated to the traces when none is given.
+	DefaultNamespace = "kubectl-trace-system"
+	// DefaultServiceAccount is the service account of the trace pods.
+	DefaultServiceAccount = "kubectl-trace"
+
+	userRoleName      = meta.ObjectNamePrefix + "user"
+	managedByLabelKey = "app.kubernetes.io/managed-by"
+	versionLabelKey   = "app.kubernetes.io/version"
+	managedBy         = "kubectl-trace"
+
+	// createdAnnotationKey marks a namespace created by setup, that teardown deletes. It is only set when Apply
+	// creates the namespace: an existing namespace is adopted by merging labels, and only these are reverted by teardown.
+	createdAnnotationKey = "iovisor.org/kubectl-trace-setup-created"
+	// labelsAnnotationKey records the values the labels merged into an adopted namespace had before, as json,
+	// null for the labels it did not have.
+	labelsAnnotationKey = "iovisor.org/kubectl-trace-setup-labels"
+
+	// The trace pods use the host PID namespace and host paths, which only the privileged level allows
+	podSecurityEnforceLabelKey = "pod-security.kubernetes.io/enforce"
+	podSecurityPrivileged      = "privileged"
+)
+
+// These are the outcomes of applying or deleting an object.
+const (
+	ActionCreated    = "created"
+	ActionConfigured = "configured"
+	ActionDeleted    = "deleted"
+	ActionNotFound   = "not found"
+	ActionReverted   = "labels reverted"
+)
+
+// Options describes the objects to set up for tracing in a namespace.
+type Options struct {
+	Namespace      string
+	ServiceAccount string
+	// Users and Groups are granted what kubectl trace needs to run traces in the namespace.
+	Users  []string
+	Groups []string
+	// Version is the version of kubectl trace the objects are set up by.
+	Version string
+}
+
+// Manifests returns the objects needed for tracing in a namespace, in the order to create them.
+// The cluster wide objects are named after the namespace, so that several namespaces can be set up.
+// The roles are bound only when there are users or groups to bind them to.
+func Manifests(o Options) []runtime.Object {
+	return manifests(o, len(o.Users) > 0 || len(o.Groups) > 0)
+}
+
+// TeardownManifests returns all the objects Manifests may return for a namespace, whoever the roles are bound to.
+func TeardownManifests(o Options) []runtime.Object {
+	return manifests(o, true)
+}
+
+func manifests(o Options, bindings bool) []runtime.Object {
+	labels := map[string]string{
+		managedByLabelKey: managedBy,
+		versionLabelKey:   o.Version,
+	}
+	clusterRoleName := fmt.Sprintf("%s-%s", userRoleName, o.Namespace)
+
+	ns := &apiv1.Namespace{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{Name: o.Namespace, Labels: copyLabels(labels)},
+	}
+	ns.Labels[podSecurityEnforceLabelKey] = podSecurityPrivileged
+
+	objects := []runtime.Object{
+		ns,
+		&apiv1.ServiceAccount{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+			ObjectMeta: metav1.ObjectMeta{Name: o.ServiceAccount, Namespace: o.Namespace, Labels: labels},
+		},
+		// What the commands do in the namespace of the traces
+		&rbacv1.Role{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+			ObjectMeta: metav1.ObjectMeta{Name: userRoleName, Namespace: o.Namespace, Labels: labels},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{"batch"},
+					Resources: []string{"jobs"},
+					Verbs:     []string{"create", "get", "list", "watch", "update", "patch", "delete"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"configmaps"},
+					Verbs:     []string{"create", "get", "list", "update", "delete"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods/log"},
+					Verbs:     []string{"get"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods/attach"},
+					Verbs:     []string{"create"},
+				},
+				{
+					APIGroups: []string{"apps"},
+					Resources: []string{"daemonsets"},
+					Verbs:     []string{"create", "get", "list", "update", "delete"},
+				},
+			},
+		},
+		// What the commands look up to find where to run the traces, and trigger watches for the conditions
+		&rbacv1.ClusterRole{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+			ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName, Labels: labels},
+			Rules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"nodes"},
+					Verbs:     []string{"get", "list"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"pods"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+				{
+					APIGroups: []string{""},
+					Resources: []string{"events"},
+					Verbs:     []string{"list"},
+				},
+			},
+		},
+	}
+
+	subjects := []rbacv1.Subject{}
+	for _, u := range o.Users {
+		subjects = append(subjects, rbacv1.Subject{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: u})
+	}
+	for _, g := range o.Groups {
+		subjects = append(subjects, rbacv1.Subject{Kind: rbacv1.GroupKind, APIGroup: rbacv1.GroupName, Name: g})
+	}
+	if !bindings {
+		return objects
+	}
+
+	return append(objects,
+		&rbacv1.RoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: userRoleName, Namespace: o.Namespace, Labels: labels},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: userRoleName},
+		},
+		&rbacv1.ClusterRoleBinding{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+			ObjectMeta: metav1.ObjectMeta{Name: clusterRoleName, Labels: labels},
+			Subjects:   subjects,
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRoleName},
+		})
+}
+
+// Apply creates the objects, or updates the ones that exist, calling report with what was done to each.
+// The labels of an existing namespace are merged instead of replaced, and the values they replace recorded.
+func Apply(client kubernetes.Interface, objects []runtime.Object, report func(runtime.Object, string)) error {
+	ctx := context.Background()
+	for _, obj := range objects {
+		var err error
+		created := true
+		switch o := obj.(type) {
+		case *apiv1.Namespace:
+			c := client.CoreV1().Namespaces()
+			// Only the created namespace is marked, not the printed manifests that could be applied over an existing one
+			ns := o.DeepCopy()
+			ns.Annotations = map[string]string{createdAnnotationKey: "true"}
+			if _, err = c.Create(ctx, ns, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *apiv1.Namespace
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					if err = mergeLabels(cur, o.Labels); err == nil {
+						_, err = c.Update(ctx, cur, metav1.UpdateOptions{})
+					}
+				}
+			}
+		case *apiv1.ServiceAccount:
+			c := client.CoreV1().ServiceAccounts(o.Namespace)
+			if _, err = c.Create(ctx, o, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *apiv1.ServiceAccount
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					cur.Labels = o.Labels
+					_, err = c.Update(ctx, cur, metav1.UpdateOptions{})
+				}
+			}
+		case *rbacv1.Role:
+			c := client.RbacV1().Roles(o.Namespace)
+			if _, err = c.Create(ctx, o, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *rbacv1.Role
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					o.ResourceVersion = cur.ResourceVersion
+					_, err = c.Update(ctx, o, metav1.UpdateOptions{})
+				}
+			}
+		case *rbacv1.ClusterRole:
+			c := client.RbacV1().ClusterRoles()
+			if _, err = c.Create(ctx, o, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *rbacv1.ClusterRole
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					o.ResourceVersion = cur.ResourceVersion
+					_, err = c.Update(ctx, o, metav1.UpdateOptions{})
+				}
+			}
+		case *rbacv1.RoleBinding:
+			c := client.RbacV1().RoleBindings(o.Namespace)
+			if _, err = c.Create(ctx, o, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *rbacv1.RoleBinding
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					o.ResourceVersion = cur.ResourceVersion
+					_, err = c.Update(ctx, o, metav1.UpdateOptions{})
+				}
+			}
+		case *rbacv1.ClusterRoleBinding:
+			c := client.RbacV1().ClusterRoleBindings()
+			if _, err = c.Create(ctx, o, metav1.CreateOptions{}); errors.IsAlreadyExists(err) {
+				created = false
+				var cur *rbacv1.ClusterRoleBinding
+				if cur, err = c.Get(ctx, o.Name, metav1.GetOptions{}); err == nil {
+					o.ResourceVersion = cur.ResourceVersion
+					_, err = c.Update(ctx, o, metav1.UpdateOptions{})
+				}
+			}
+		default:
+			err = fmt.Errorf("unexpected object %T", obj)
+		}
+		if err != nil {
+			return fmt.Errorf("could not apply %s: %s", Name(obj), err)
+		}
+
+		if created {
+			report(obj, ActionCreated)
+		} else {
+			report(obj, ActionConfigured)
+		}
+	}
+	return nil
+}
+
+// mergeLabels sets the labels on a namespace that was not created by setup, recording the values they replace.
+// The values recorded by a former setup are kept, they are the ones from before any setup.
+func mergeLabels(ns *apiv1.Namespace, labels map[string]string) error {
+	if ns.Labels == nil {
+		ns.Labels = map[string]string{}
+	}
+	if ns.Annotations == nil {
+		ns.Annotations = map[string]string{}
+	}
+	_, created := ns.Annotations[createdAnnotationKey]
+	if _, recorded := ns.Annotations[labelsAnnotationKey]; !created && !recorded {
+		former := map[string]*string{}
+		for k := range labels {
+			if v, ok := ns.Labels[k]; ok {
+				former[k] = &v
+			} else {
+				former[k] = nil
+			}
+		}
+		b, err := json.Marshal(former)
+		if err != nil {
+			return err
+		}
+		ns.Annotations[labelsAnnotationKey] = string(b)
+	}
+	for k, v := range labels {
+		ns.Labels[k] = v
+	}
+	return nil
+}
+
+// deleteNamespace deletes the namespace if setup created it, or reverts the labels setup merged into it.
+// It returns what was done.
+func deleteNamespace(client kubernetes.Interface, name string) (string, error) {
+	ctx := context.Background()
+	c := client.CoreV1().Namespaces()
+	ns, err := c.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := ns.Annotations[createdAnnotationKey]; ok {
+		return ActionDeleted, c.Delete(ctx, name, metav1.DeleteOptions{})
+	}
+
+	recorded, ok := ns.Annotations[labelsAnnotationKey]
+	if !ok {
+		// Neither created nor adopted by setup, there is nothing to revert
+		return ActionNotFound, nil
+	}
+	former := map[string]*string{}
+	if err := json.Unmarshal([]byte(recorded), &former); err != nil {
+		return "", fmt.Errorf("invalid %s annotation: %s", labelsAnnotationKey, err)
+	}
+	for k, v := range former {
+		if v == nil {
+			delete(ns.Labels, k)
+		} else {
+			ns.Labels[k] = *v
+		}
+	}
+	delete(ns.Annotations, labelsAnnotationKey)
+	_, err = c.Update(ctx, ns, metav1.UpdateOptions{})
+	return ActionReverted, err
+}
+
+// Delete deletes the objects in the reverse order they are created, calling report with what was done to each.
+// A namespace is only deleted when setup created it, the labels setup merged into an existing one are reverted instead.
+func Delete(client kubernetes.Interface, objects []runtime.Object, report func(runtime.Object, string)) error {
+	ctx := context.Background()
+	for i := len(objects) - 1; i >= 0; i-- {
+		var err error
+		action := ActionDeleted
+		switch o := objects[i].(type) {
+		case *apiv1.Namespace:
+			action, err = deleteNamespace(client, o.Name)
+		case *apiv1.ServiceAccount:
+			err = client.CoreV1().ServiceAccounts(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+		case *rbacv1.Role:
+			err = client.RbacV1().Roles(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+		case *rbacv1.ClusterRole:
+			err = client.RbacV1().ClusterRoles().Delete(ctx, o.Name, metav1.DeleteOptions{})
+		case *rbacv1.RoleBinding:
+			err = client.RbacV1().RoleBindings(o.Namespace).Delete(ctx, o.Name, metav1.DeleteOptions{})
+		case *rbacv1.ClusterRoleBinding:
+			err = client.RbacV1().ClusterRoleBindings().Delete(ctx, o.Name, metav1.DeleteOptions{})
+		default:
+			err = fmt.Errorf("unexpected object %T", o)
+		}
+
+		switch {
+		case errors.IsNotFound(err):
+			report(objects[i], ActionNotFound)
+		case err != nil:
+			return fmt.Errorf("could not delete %s: %s", Name(objects[i]), err)
+		default:
+			report(objects[i], action)
+		}
+	}
+	return nil
+}
+
+// Name returns the kind and name of an object, like kubectl does.
+func Name(obj runtime.Object) string {
+	m, err := metaAccessor(obj)
+	if err != nil {
+		return fmt.Sprintf("%T", obj)
+	}
+	return fmt.Sprintf("%s/%s", obj.GetObjectKind().GroupVersionKind().Kind, m.GetName())
+}
+
+// PrintYAML prints the objects as a yaml stream.
+func PrintYAML(w io.Writer, objects []runtime.Object) error {
+	var buf bytes.Buffer
+	for i, obj := range objects {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func metaAccessor(obj runtime.Object) (metav1.Object, error) {
+	m, ok := obj.(metav1.Object)
+	if !ok {
+		return nil, fmt.Errorf("%T has no object meta", obj)
+	}
+	return m, nil
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	c := map[string]string{}
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,180 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestOptions() Options {
+	return Options{
+		Namespace:      "tracing",
+		ServiceAccount: DefaultServiceAccount,
+		Groups:         []string{"sre"},
+		Version:        "abc123",
+	}
+}
+
+func names(objects []runtime.Object) []string {
+	n := []string{}
+	for _, obj := range objects {
+		n = append(n, Name(obj))
+	}
+	return n
+}
+
+func TestManifests(t *testing.T) {
+	objects := Manifests(newTestOptions())
+
+	assert.Equal(t, []string{
+		"Namespace/tracing",
+		"ServiceAccount/kubectl-trace",
+		"Role/kubectl-trace-user",
+		"ClusterRole/kubectl-trace-user-tracing",
+		"RoleBinding/kubectl-trace-user",
+		"ClusterRoleBinding/kubectl-trace-user-tracing",
+	}, names(objects))
+
+	ns := objects[0].(*apiv1.Namespace)
+	assert.Equal(t, "privileged", ns.Labels["pod-security.kubernetes.io/enforce"])
+	assert.Equal(t, "abc123", ns.Labels["app.kubernetes.io/version"])
+
+	// Trigger lists the events of the pods it watches, wherever they are
+	cr := objects[3].(*rbacv1.ClusterRole)
+	assert.Contains(t, cr.Rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"list"}})
+
+	rb := objects[4].(*rbacv1.RoleBinding)
+	assert.Equal(t, []rbacv1.Subject{{Kind: "Group", APIGroup: rbacv1.GroupName, Name: "sre"}}, rb.Subjects)
+}
+
+func TestManifestsWithoutSubjects(t *testing.T) {
+	o := newTestOptions()
+	o.Groups = nil
+
+	assert.Len(t, Manifests(o), 4)
+	assert.Len(t, TeardownManifests(o), 6)
+}
+
+func TestApply(t *testing.T) {
+	client := fake.NewSimpleClientset(&apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "tracing", Labels: map[string]string{"team": "sre"}},
+	})
+
+	actions := map[string]string{}
+	err := Apply(client, Manifests(newTestOptions()), func(obj runtime.Object, action string) {
+		actions[Name(obj)] = action
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, ActionConfigured, actions["Namespace/tracing"])
+	assert.Equal(t, ActionCreated, actions["ClusterRoleBinding/kubectl-trace-user-tracing"])
+
+	ns, err := client.CoreV1().Namespaces().Get(context.Background(), "tracing", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "sre", ns.Labels["team"])
+	assert.Equal(t, "privileged", ns.Labels["pod-security.kubernetes.io/enforce"])
+
+	// Applying again updates everything
+	o := newTestOptions()
+	o.Version = "def456"
+	err = Apply(client, Manifests(o), func(obj runtime.Object, action string) {
+		assert.Equal(t, ActionConfigured, action, Name(obj))
+	})
+	require.Nil(t, err)
+
+	role, err := client.RbacV1().Roles("tracing").Get(context.Background(), "kubectl-trace-user", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "def456", role.Labels["app.kubernetes.io/version"])
+}
+
+func TestDelete(t *testing.T) {
+	o := newTestOptions()
+	o.Groups = nil
+	client := fake.NewSimpleClientset()
+	require.Nil(t, Apply(client, Manifests(o), func(runtime.Object, string) {}))
+
+	deleted := []string{}
+	notFound := []string{}
+	err := Delete(client, TeardownManifests(o), func(obj runtime.Object, action string) {
+		switch action {
+		case ActionDeleted:
+			deleted = append(deleted, Name(obj))
+		case ActionNotFound:
+			notFound = append(notFound, Name(obj))
+		}
+	})
+	require.Nil(t, err)
+
+	assert.Equal(t, []string{"ClusterRoleBinding/kubectl-trace-user-tracing", "RoleBinding/kubectl-trace-user"}, notFound)
+	assert.Equal(t, []string{
+		"ClusterRole/kubectl-trace-user-tracing",
+		"Role/kubectl-trace-user",
+		"ServiceAccount/kubectl-trace",
+		"Namespace/tracing",
+	}, deleted)
+}
+
+func TestDeleteExistingNamespace(t *testing.T) {
+	client := fake.NewSimpleClientset(&apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "tracing", Labels: map[string]string{
+			"team":                               "sre",
+			"pod-security.kubernetes.io/enforce": "baseline",
+		}},
+	})
+	o := newTestOptions()
+	require.Nil(t, Apply(client, Manifests(o), func(runtime.Object, string) {}))
+	// Applying again keeps the labels from before the first setup
+	require.Nil(t, Apply(client, Manifests(o), func(runtime.Object, string) {}))
+
+	actions := map[string]string{}
+	err := Delete(client, TeardownManifests(o), func(obj runtime.Object, action string) {
+		actions[Name(obj)] = action
+	})
+	require.Nil(t, err)
+	assert.Equal(t, ActionReverted, actions["Namespace/tracing"])
+	assert.Equal(t, ActionDeleted, actions["Role/kubectl-trace-user"])
+
+	ns, err := client.CoreV1().Namespaces().Get(context.Background(), "tracing", metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, map[string]string{
+		"team":                               "sre",
+		"pod-security.kubernetes.io/enforce": "baseline",
+	}, ns.Labels)
+	assert.Empty(t, ns.Annotations)
+}
+
+func TestPrintYAML(t *testing.T) {
+	var buf bytes.Buffer
+	require.Nil(t, PrintYAML(&buf, Manifests(newTestOptions())[:2]))
+
+	assert.Equal(t, `apiVersion: v1
+kind: Namespace
+metadata:
+  creationTimestamp: null
+  labels:
+    app.kubernetes.io/managed-by: kubectl-trace
+    app.kubernetes.io/version: abc123
+    pod-security.kubernetes.io/enforce: privileged
+  name: tracing
+spec: {}
+status: {}
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  creationTimestamp: null
+  labels:
+    app.kubernetes.io/managed-by: kubectl-trace
+    app.kubernetes.io/version: abc123
+  name: kubectl-trace
+  namespace: tracing
+`, buf.String())
+}
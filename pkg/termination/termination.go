@@ -0,0 +1,256 @@
+package termination
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultPath is where kubernetes reads the termination message from if not configured otherwise.
+const DefaultPath = "/dev/termination-log"
+
+// maxMessageSize is the maximum size kubernetes keeps for a termination message.
+const maxMessageSize = 4096
+
+// CompileErrorExitCode is the code trace-runner exits with when the program fails validation,
+// telling compile errors from the other failures without reading the termination message.
+const CompileErrorExitCode = 3
+
+// Reason explains why trace-runner exited.
+type Reason string
+
+// These are the reasons trace-runner can report.
+const (
+	// ReasonCompileError means the bpftrace program failed validation before the trace started.
+	ReasonCompileError Reason = "CompileError"
+	// ReasonCompleted means the bpftrace program exited on its own.
+	ReasonCompleted Reason = "Completed"
+	// ReasonFailed means the bpftrace program exited on its own with an error.
+	ReasonFailed Reason = "Failed"
+	// ReasonDeadlineExceeded means the bpftrace program was interrupted at the deadline of the trace.
+	ReasonDeadlineExceeded Reason = "DeadlineExceeded"
+	// ReasonTerminated means the bpftrace program was interrupted because the trace pod was being deleted.
+	ReasonTerminated Reason = "Terminated"
+	// ReasonInterrupted means the bpftrace program was interrupted from an attached terminal.
+	ReasonInterrupted Reason = "Interrupted"
+	// ReasonTargetNotFound means the process of the traced container could not be found.
+	ReasonTargetNotFound Reason = "TargetNotFound"
+)
+
+// ProgramError is a single error reported by bpftrace about the program.
+type ProgramError struct {
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// Message is the summary trace-runner writes to its container termination message.
+type Message struct {
+	Reason Reason `json:"reason"`
+	// PID and Cgroup are those of the traced container process, in the root pid namespace.
+	PID             string `json:"pid,omitempty"`
+	Cgroup          string `json:"cgroup,omitempty"`
+	BpftraceVersion string `json:"bpftraceVersion,omitempty"`
+	ProbesAttached  int    `json:"probesAttached,omitempty"`
+	LostEvents      int64  `json:"lostEvents,omitempty"`
+	// Restarts is how many times the traced container restarted and was traced again.
+	Restarts int `json:"restarts,omitempty"`
+	// ContainerStarted is true when the trace was to start before the container, but the container
+	// was already running once the probes were attached: its start was not traced.
+	ContainerStarted bool `json:"containerStarted,omitempty"`
+	// RuntimeSeconds is how long the program ran.
+	RuntimeSeconds int64          `json:"runtimeSeconds,omitempty"`
+	Errors         []ProgramError `json:"errors,omitempty"`
+}
+
+// bpftrace reports errors either as "file:line:col[-col]: ERROR: message" or just "ERROR: message".
+var programErrorRegexp = regexp.MustCompile(`^(?:\S+?:(\d+):(\d+)(?:-\d+)?:\s+)?ERROR:\s*(.*)$`)
+
+// ParseProgramErrors extracts the errors bpftrace printed in output.
+func ParseProgramErrors(output string) []ProgramError {
+	errs := []ProgramError{}
+	for _, l := range strings.Split(output, "\n") {
+		m := programErrorRegexp.FindStringSubmatch(strings.TrimSpace(l))
+		if m == nil {
+			continue
+		}
+		line, _ := strconv.Atoi(m[1])
+		col, _ := strconv.Atoi(m[2])
+		errs = append(errs, ProgramError{
+			Line:    line,
+			Column:  col,
+			Message: m[3],
+		})
+	}
+	return errs
+}
+
+// Write serializes m into the termination message file at path.
+func Write(path string, m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	// Drop errors until it fits, kubernetes would otherwise truncate it to invalid json
+	for len(b) > maxMessageSize && len(m.Errors) > 0 {
+		m.Errors = m.Errors[:len(m.Errors)-1]
+		b, err = json.Marshal(m)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// Parse decodes a termination message written by trace-runner.
+func Parse(s string) (*Message, error) {
+	m := &Message{}
+	if err := json.Unmarshal([]byte(s), m); err != nil {
+		return nil, fmt.Errorf("invalid termination message: %s", err)
+	}
+	return m, nil
+}
+
+// FromPod returns the termination message left by the first terminated container of the pod, if any.
+func FromPod(pod *corev1.Pod) *Message {
+	for _, cs := range pod.Status.ContainerStatuses {
+		t := cs.State.Terminated
+		if t == nil {
+			t = cs.LastTerminationState.Terminated
+		}
+		if t == nil || len(t.Message) == 0 {
+			continue
+		}
+		m, err := Parse(t.Message)
+		if err != nil {
+			continue
+		}
+		return m
+	}
+	return nil
+}
+
+// Summary formats the reason and what is known of the run on a single line.
+func (m Message) Summary() string {
+	details := []string{}
+	if m.RuntimeSeconds > 0 {
+		details = append(details, fmt.Sprintf("ran %s", time.Duration(m.RuntimeSeconds)*time.Second))
+	}
+	if m.ProbesAttached > 0 {
+		details = append(details, fmt.Sprintf("%d probes attached", m.ProbesAttached))
+	}
+	if m.LostEvents > 0 {
+		details = append(details, fmt.Sprintf("%d events lost", m.LostEvents))
+	}
+	if m.Restarts > 0 {
+		details = append(details, fmt.Sprintf("followed %d restarts", m.Restarts))
+	}
+	if m.ContainerStarted {
+		details = append(details, "container started before the probes were attached")
+	}
+	if len(m.PID) > 0 {
+		details = append(details, "pid "+m.PID)
+	}
+	if len(m.BpftraceVersion) > 0 {
+		details = append(details, m.BpftraceVersion)
+	}
+	if len(details) == 0 {
+		return string(m.Reason)
+	}
+	return fmt.Sprintf("%s (%s)", m.Reason, strings.Join(details, ", "))
+}
+
+// String formats the message for humans, the summary then one error per line.
+func (m Message) String() string {
+	var sb strings.Builder
+	sb.WriteString(m.Summary())
+	for _, e := range m.Errors {
+		sb.WriteString("\n")
+		if e.Line > 0 {
+			fmt.Fprintf(&sb, "%d:%d: ", e.Line, e.Column)
+		}
+		sb.WriteString(e.Message)
+	}
+	return sb.String()
+}
+
+// bpftrace reports the probes it attaches before running the program, and the events it could not read.
+var (
+	attachingRegexp  = regexp.MustCompile(`^Attaching (\d+) probes?\.\.\.`)
+	lostEventsRegexp = regexp.MustCompile(`^Lost (\d+) events`)
+)
+
+// OutputRecorder picks the attached probes and the lost events out of the bpftrace output written to its streams.
+type OutputRecorder struct {
+	mu     sync.Mutex
+	probes int
+	lost   int64
+	// attaching is set once bpftrace reported attaching its probes
+	attaching bool
+}
+
+// Stream returns a writer for one of the outputs of bpftrace, like its stdout or its stderr.
+// Each output needs its own stream, so that the lines of one are not split by the writes of the other.
+func (r *OutputRecorder) Stream() io.Writer {
+	return &outputStream{recorder: r}
+}
+
+// outputStream cuts an output into lines for its recorder.
+type outputStream struct {
+	recorder *OutputRecorder
+	partial  []byte
+}
+
+// Write records the complete lines of p, keeping the last one for the next write if it is not.
+func (s *outputStream) Write(p []byte) (int, error) {
+	s.partial = append(s.partial, p...)
+	for {
+		i := bytes.IndexByte(s.partial, '\n')
+		if i < 0 {
+			break
+		}
+		s.recorder.record(strings.TrimSpace(string(s.partial[:i])))
+		s.partial = s.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+func (r *OutputRecorder) record(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m := attachingRegexp.FindStringSubmatch(line); m != nil {
+		r.probes, _ = strconv.Atoi(m[1])
+		r.attaching = true
+	}
+	if m := lostEventsRegexp.FindStringSubmatch(line); m != nil {
+		lost, _ := strconv.ParseInt(m[1], 10, 64)
+		r.lost += lost
+	}
+}
+
+// Attaching tells whether bpftrace reported attaching its probes, which it does right before attaching them.
+func (r *OutputRecorder) Attaching() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.attaching
+}
+
+// Fill sets the attached probes and the lost events recorded so far in m.
+func (r *OutputRecorder) Fill(m *Message) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m.ProbesAttached = r.probes
+	m.LostEvents = r.lost
+}
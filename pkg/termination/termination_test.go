@@ -0,0 +1,149 @@
+package termination
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestParseProgramErrors(t *testing.T) {
+	output := `/programs/program.bt:1:1-25: ERROR: Invalid probe type: not_a_probe
+Attaching 1 probe...
+cannot attach kprobe, probe entry may not exist
+ERROR: Error attaching probe: 'kprobe:not_a_real_kprobe'
+`
+	expected := []ProgramError{
+		{Line: 1, Column: 1, Message: "Invalid probe type: not_a_probe"},
+		{Message: "Error attaching probe: 'kprobe:not_a_real_kprobe'"},
+	}
+
+	assert.Equal(t, expected, ParseProgramErrors(output))
+}
+
+func TestParseProgramErrorsNoErrors(t *testing.T) {
+	assert.Empty(t, ParseProgramErrors("Attaching 1 probe...\n"))
+}
+
+func TestWriteParse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termination")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "termination-log")
+	m := Message{
+		Reason: ReasonCompileError,
+		Errors: []ProgramError{{Line: 2, Column: 3, Message: "syntax error"}},
+	}
+	require.Nil(t, Write(p, m))
+
+	b, err := ioutil.ReadFile(p)
+	require.Nil(t, err)
+
+	actual, err := Parse(string(b))
+	assert.Nil(t, err)
+	assert.Equal(t, &m, actual)
+}
+
+func TestWriteTruncatesErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "termination")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := path.Join(dir, "termination-log")
+	m := Message{Reason: ReasonCompileError}
+	for i := 0; i < 100; i++ {
+		m.Errors = append(m.Errors, ProgramError{Message: strings.Repeat("x", 100)})
+	}
+	require.Nil(t, Write(p, m))
+
+	b, err := ioutil.ReadFile(p)
+	require.Nil(t, err)
+	assert.LessOrEqual(t, len(b), maxMessageSize)
+
+	actual, err := Parse(string(b))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, actual.Errors)
+}
+
+func TestFromPod(t *testing.T) {
+	pod := &corev1.Pod{
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 1,
+							Message:  `{"reason":"CompileError","errors":[{"message":"boom"}]}`,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	m := FromPod(pod)
+	require.NotNil(t, m)
+	assert.Equal(t, ReasonCompileError, m.Reason)
+	assert.Equal(t, "CompileError\nboom", m.String())
+
+	assert.Nil(t, FromPod(&corev1.Pod{}))
+}
+
+func TestOutputRecorder(t *testing.T) {
+	r := &OutputRecorder{}
+	assert.False(t, r.Attaching())
+	// Lines may be split across writes, with the writes of another stream in between
+	stdout, stderr := r.Stream(), r.Stream()
+	for _, w := range []struct {
+		stream io.Writer
+		data   string
+	}{
+		{stdout, "Attaching 3 pro"},
+		{stderr, "WARNING: could not resolve symbol\n"},
+		{stdout, "bes...\r\n@[comm]: 12\n"},
+		{stderr, "\nLost 5 events\nLost "},
+		{stdout, "@[comm]: 13\n"},
+		{stderr, "2 events\n"},
+		{stdout, "Lost 100"},
+	} {
+		n, err := w.stream.Write([]byte(w.data))
+		require.Nil(t, err)
+		assert.Equal(t, len(w.data), n)
+	}
+
+	assert.True(t, r.Attaching())
+	m := Message{Reason: ReasonDeadlineExceeded}
+	r.Fill(&m)
+	assert.Equal(t, 3, m.ProbesAttached)
+	assert.Equal(t, int64(7), m.LostEvents)
+}
+
+func TestSummary(t *testing.T) {
+	m := Message{
+		Reason:          ReasonDeadlineExceeded,
+		PID:             "4242",
+		Cgroup:          "/kubepods/pod1234/abcd",
+		BpftraceVersion: "bpftrace v0.13.0",
+		ProbesAttached:  3,
+		LostEvents:      7,
+		Restarts:        2,
+		RuntimeSeconds:  90,
+	}
+	assert.Equal(t, "DeadlineExceeded (ran 1m30s, 3 probes attached, 7 events lost, followed 2 restarts, pid 4242, bpftrace v0.13.0)", m.Summary())
+	assert.Equal(t, "Completed", Message{Reason: ReasonCompleted}.Summary())
+	assert.Equal(t, "Completed (container started before the probes were attached)", Message{Reason: ReasonCompleted, ContainerStarted: true}.Summary())
+
+	b, err := json.Marshal(m)
+	require.Nil(t, err)
+	actual, err := Parse(string(b))
+	require.Nil(t, err)
+	assert.Equal(t, &m, actual)
+}
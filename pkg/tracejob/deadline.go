@@ -0,0 +1,134 @@
+package tracejob
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ProgramKey is the key of the trace config map holding the program.
+	ProgramKey = "program.bt"
+	// DeadlineKey is the key of the trace config map holding the deadline of the trace, in seconds from the start
+	// of the trace runner, not of the job: pulling the images and fetching headers do not count.
+	// The trace runner reads it from the config map volume, which follows the updates of the config map.
+	DeadlineKey = "deadline"
+	// DeadlinePollInterval is how often the trace runner reads the deadline file again, to follow its changes.
+	DeadlinePollInterval = 5 * time.Second
+)
+
+// configMapSyncDelay is how long the kubelet can take to update a config map volume once the config map changed.
+const configMapSyncDelay = time.Minute
+
+// ReadDeadline reads a deadline in seconds from a file.
+func ReadDeadline(path string) (time.Duration, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return parseDeadline(string(b))
+}
+
+func parseDeadline(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid deadline %q, must be a positive number of seconds", s)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// ExtendDeadline moves the deadline of the trace matching the filter by the given duration, shortening it when negative.
+// The deadline counts from the start of the trace runner. The job deadline is moved along, so that it still leaves
+// the grace period after the trace deadline, and the time the trace runner takes to read a shortened deadline.
+// It returns the new deadline, from the start of the trace.
+func (t *TraceJobClient) ExtendDeadline(nf TraceJobFilter, by time.Duration) (time.Duration, error) {
+	ctx := context.Background()
+	jl, err := t.findJobsWithFilter(nf)
+	if err != nil {
+		return 0, err
+	}
+	if len(jl) == 0 {
+		return 0, fmt.Errorf("no trace found with the provided criterias")
+	}
+	if len(jl) > 1 {
+		return 0, fmt.Errorf("%d traces found with the provided criterias, the deadline of only one can be changed", len(jl))
+	}
+	job := jl[0]
+	if jobFinished(job) {
+		return 0, fmt.Errorf("trace %s has already finished", job.Name)
+	}
+
+	cm, err := t.ConfigClient.Get(ctx, job.Name, metav1.GetOptions{})
+	if err != nil {
+		return 0, err
+	}
+	value, ok := cm.Data[DeadlineKey]
+	if !ok {
+		return 0, fmt.Errorf("trace %s was created by an older version of kubectl trace, its deadline cannot be changed", job.Name)
+	}
+	deadline, err := parseDeadline(value)
+	if err != nil {
+		return 0, err
+	}
+
+	extended := deadline + by.Truncate(time.Second)
+	if extended <= 0 {
+		return 0, fmt.Errorf("the deadline of trace %s is %s, it cannot be shortened by %s", job.Name, deadline, -by)
+	}
+	seconds := int64(extended / time.Second)
+
+	updateJob := func() error {
+		if job.Spec.ActiveDeadlineSeconds == nil {
+			return nil
+		}
+		current := *job.Spec.ActiveDeadlineSeconds
+		grace := current - int64(deadline/time.Second)
+		activeDeadline := seconds + grace
+		if extended < deadline {
+			// The trace runner keeps the former deadline until its config map volume is updated and it reads it again,
+			// the job is not to be killed before it could stop the program on its own
+			activeDeadline += int64((configMapSyncDelay + DeadlinePollInterval) / time.Second)
+			if activeDeadline > current {
+				activeDeadline = current
+			}
+		}
+		job.Spec.ActiveDeadlineSeconds = int64Ptr(activeDeadline)
+		_, err := t.JobClient.Update(ctx, &job, metav1.UpdateOptions{})
+		return err
+	}
+	updateConfigMap := func() error {
+		cm.Data[DeadlineKey] = strconv.FormatInt(seconds, 10)
+		_, err := t.ConfigClient.Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	}
+
+	// The job deadline always stays past the trace deadline: it is pushed out first, and brought forward last
+	updates := []func() error{updateJob, updateConfigMap}
+	if extended < deadline {
+		updates = []func() error{updateConfigMap, updateJob}
+	}
+	for _, update := range updates {
+		if err := update(); err != nil {
+			return 0, err
+		}
+	}
+	return extended, nil
+}
+
+// ParseDuration parses a duration given as a number of seconds, or like 90s, 15m or 2h.
+// It is truncated to the second, the precision of the deadlines.
+func ParseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, must be a number of seconds or a duration like 90s, 15m or 2h", s)
+	}
+	return d.Truncate(time.Second), nil
+}
@@ -0,0 +1,109 @@
+package tracejob
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestReadDeadline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-deadline")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, DeadlineKey)
+	require.Nil(t, ioutil.WriteFile(path, []byte("3600\n"), 0644))
+	d, err := ReadDeadline(path)
+	require.Nil(t, err)
+	assert.Equal(t, time.Hour, d)
+
+	require.Nil(t, ioutil.WriteFile(path, []byte("0"), 0644))
+	_, err = ReadDeadline(path)
+	assert.EqualError(t, err, `invalid deadline "0", must be a positive number of seconds`)
+}
+
+func TestExtendDeadline(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"/bin/trace-runner", "--program=/programs/program.bt", "--deadline-file=/programs/deadline"}, job.Spec.Template.Spec.Containers[0].Command[:3])
+
+	deadline, err := tc.ExtendDeadline(TraceJobFilter{ID: &tj.ID}, 30*time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, 31*time.Minute, deadline)
+
+	cm, err := tc.ConfigClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "1860", cm.Data[DeadlineKey])
+	job, err = tc.JobClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	// The grace period is kept
+	assert.Equal(t, int64(1870), *job.Spec.ActiveDeadlineSeconds)
+
+	// Shortened, the job deadline leaves the trace runner the time to read the new deadline
+	deadline, err = tc.ExtendDeadline(TraceJobFilter{ID: &tj.ID}, -20*time.Minute)
+	require.Nil(t, err)
+	assert.Equal(t, 11*time.Minute, deadline)
+	cm, err = tc.ConfigClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "660", cm.Data[DeadlineKey])
+	job, err = tc.JobClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(660+10+65), *job.Spec.ActiveDeadlineSeconds)
+
+	// But not past the former job deadline
+	_, err = tc.ExtendDeadline(TraceJobFilter{ID: &tj.ID}, -10*time.Second)
+	require.Nil(t, err)
+	job, err = tc.JobClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, int64(660+10+65), *job.Spec.ActiveDeadlineSeconds)
+
+	_, err = tc.ExtendDeadline(TraceJobFilter{Name: &tj.Name}, -time.Hour)
+	assert.EqualError(t, err, "the deadline of trace "+tj.Name+" is 10m50s, it cannot be shortened by 1h0m0s")
+}
+
+func TestExtendDeadlineOlderTrace(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	_, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	cm, err := tc.ConfigClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	delete(cm.Data, DeadlineKey)
+	_, err = tc.ConfigClient.Update(context.Background(), cm, metav1.UpdateOptions{})
+	require.Nil(t, err)
+
+	_, err = tc.ExtendDeadline(TraceJobFilter{ID: &tj.ID}, time.Minute)
+	assert.EqualError(t, err, "trace "+tj.Name+" was created by an older version of kubectl trace, its deadline cannot be changed")
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Duration
+	}{
+		{in: "3600", expected: time.Hour},
+		{in: "90s", expected: 90 * time.Second},
+		{in: "15m", expected: 15 * time.Minute},
+		{in: "1h30m", expected: 90 * time.Minute},
+		{in: "1500ms", expected: time.Second},
+		{in: "-10m", expected: -10 * time.Minute},
+	}
+	for _, tt := range tests {
+		d, err := ParseDuration(tt.in)
+		require.Nil(t, err, tt.in)
+		assert.Equal(t, tt.expected, d, tt.in)
+	}
+
+	_, err := ParseDuration("soon")
+	assert.EqualError(t, err, `invalid duration "soon", must be a number of seconds or a duration like 90s, 15m or 2h`)
+}
@@ -0,0 +1,227 @@
+package tracejob
+
+import (
+	"fmt"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// HeaderMode is how the trace job provides kernel headers to bpftrace.
+type HeaderMode string
+
+// These are the valid header modes.
+const (
+	// HeaderModeAuto picks one of the other modes depending on what the node offers.
+	HeaderModeAuto HeaderMode = "auto"
+	// HeaderModeBTF relies on the kernel type information only, without mounting any header.
+	HeaderModeBTF HeaderMode = "btf"
+	// HeaderModeHost mounts the headers linked in the host /lib/modules.
+	HeaderModeHost HeaderMode = "host"
+	// HeaderModeFetch downloads and prepares the headers in an init container.
+	HeaderModeFetch HeaderMode = "fetch"
+)
+
+// HeaderModes are the header modes that can be requested.
+var HeaderModes = []HeaderMode{HeaderModeAuto, HeaderModeBTF, HeaderModeHost, HeaderModeFetch}
+
+// ParseHeaderMode validates a header mode given as a string.
+func ParseHeaderMode(s string) (HeaderMode, error) {
+	for _, m := range HeaderModes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("invalid header mode %q, must be one of %s", s, headerModesString())
+}
+
+func headerModesString() string {
+	modes := make([]string, len(HeaderModes))
+	for i, m := range HeaderModes {
+		modes[i] = string(m)
+	}
+	return strings.Join(modes, ", ")
+}
+
+// NodeFacts is what is known about a node when picking a header mode.
+// BTF and Headers are nil when they could not be detected.
+type NodeFacts struct {
+	KernelVersion string
+	OSImage       string
+	BTF           *bool
+	Headers       *bool
+}
+
+// ResolveHeaderMode picks the header mode for a node, along with the reason for the choice.
+func ResolveHeaderMode(facts NodeFacts) (HeaderMode, string) {
+	switch {
+	case facts.BTF != nil && *facts.BTF:
+		return HeaderModeBTF, fmt.Sprintf("kernel %s exposes BTF", facts.KernelVersion)
+	case facts.Headers != nil && *facts.Headers:
+		return HeaderModeHost, fmt.Sprintf("node provides headers for kernel %s", facts.KernelVersion)
+	case facts.BTF != nil && facts.Headers != nil:
+		return HeaderModeFetch, fmt.Sprintf("node provides neither BTF nor headers for kernel %s", facts.KernelVersion)
+	case strings.Contains(facts.OSImage, "Container-Optimized OS"):
+		return HeaderModeFetch, fmt.Sprintf("%s does not ship headers for kernel %s", facts.OSImage, facts.KernelVersion)
+	}
+	return HeaderModeHost, fmt.Sprintf("could not detect BTF nor headers for kernel %s, assuming the host provides headers", facts.KernelVersion)
+}
+
+// HeadersSource is where the init container gets the kernel sources from when headers are fetched,
+// for clusters that cannot download them from the internet. The zero value downloads them from upstream.
+type HeadersSource struct {
+	// MirrorURL is a template for the URL of the kernel sources tarball, where
+	// {release}, {version}, {major} and, on Container-Optimized OS, {build_id} are replaced.
+	MirrorURL string
+	// Image has the kernel sources tarballs or prepared headers in its /linux-headers directory.
+	// {release} is replaced with the kernel release of the node, to use an image per kernel version.
+	Image string
+	// PVC is the name of a persistent volume claim with the kernel sources tarballs or prepared headers.
+	PVC string
+	// HostPath is a directory of the node with the kernel sources tarballs or prepared headers.
+	HostPath string
+}
+
+// These are the paths and variables shared with the init container.
+const (
+	headersSourceVolume       = "headers-source"
+	headersSourceMountPath    = "/headers-source"
+	headersImageDir           = "/linux-headers"
+	headersMirrorURLEnv       = "HEADERS_MIRROR_URL"
+	headersSourceDirEnv       = "HEADERS_SOURCE_DIR"
+	headersImageContainerName = "kubectl-trace-headers"
+	kernelReleasePattern      = "{release}"
+)
+
+// IsZero tells whether the headers are downloaded from upstream.
+func (s HeadersSource) IsZero() bool {
+	return s == HeadersSource{}
+}
+
+// Validate checks that at most one source is set.
+func (s HeadersSource) Validate() error {
+	set := 0
+	for _, v := range []string{s.MirrorURL, s.Image, s.PVC, s.HostPath} {
+		if len(v) > 0 {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("only one of the mirror url, the headers image, the pvc and the host path can be used as headers source")
+	}
+	if len(s.HostPath) > 0 && !strings.HasPrefix(s.HostPath, "/") {
+		return fmt.Errorf("headers host path %q must be absolute", s.HostPath)
+	}
+	return nil
+}
+
+// ValidateFor checks the source like Validate, and that the header mode uses it.
+// Only fetching uses a source, the auto mode may end up fetching.
+func (s HeadersSource) ValidateFor(mode HeaderMode) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if !s.IsZero() && mode != HeaderModeFetch && mode != HeaderModeAuto {
+		return fmt.Errorf("a headers source is only used with %s or %s headers, not with %s headers", HeaderModeFetch, HeaderModeAuto, mode)
+	}
+	return nil
+}
+
+// image returns the headers image for a kernel release.
+func (s HeadersSource) image(kernelRelease string) (string, error) {
+	if !strings.Contains(s.Image, kernelReleasePattern) {
+		return s.Image, nil
+	}
+	if len(kernelRelease) == 0 {
+		return "", fmt.Errorf("the kernel release of the node is needed to pick the headers image %s", s.Image)
+	}
+	return strings.Replace(s.Image, kernelReleasePattern, kernelRelease, -1), nil
+}
+
+// addHeadersSource points the init container fetching the headers to the source.
+// It expects the init container to be the last one of the pod.
+func addHeadersSource(spec *apiv1.PodSpec, s HeadersSource, kernelRelease string) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if s.IsZero() {
+		return nil
+	}
+
+	init := &spec.InitContainers[len(spec.InitContainers)-1]
+	if len(s.MirrorURL) > 0 {
+		init.Env = append(init.Env, apiv1.EnvVar{Name: headersMirrorURLEnv, Value: s.MirrorURL})
+		return nil
+	}
+
+	volume := apiv1.Volume{Name: headersSourceVolume}
+	switch {
+	case len(s.Image) > 0:
+		image, err := s.image(kernelRelease)
+		if err != nil {
+			return err
+		}
+		// Images cannot be mounted, so their content is copied where the init container can find it
+		volume.EmptyDir = &apiv1.EmptyDirVolumeSource{}
+		copier := apiv1.Container{
+			Name:      headersImageContainerName,
+			Image:     image,
+			Command:   []string{"cp", "-R", headersImageDir + "/.", headersSourceMountPath + "/"},
+			Resources: defaultResources(),
+			VolumeMounts: []apiv1.VolumeMount{
+				apiv1.VolumeMount{
+					Name:      headersSourceVolume,
+					MountPath: headersSourceMountPath,
+				},
+			},
+		}
+		spec.InitContainers = append([]apiv1.Container{copier}, spec.InitContainers...)
+		init = &spec.InitContainers[len(spec.InitContainers)-1]
+	case len(s.PVC) > 0:
+		volume.PersistentVolumeClaim = &apiv1.PersistentVolumeClaimVolumeSource{
+			ClaimName: s.PVC,
+			ReadOnly:  true,
+		}
+	case len(s.HostPath) > 0:
+		volume.HostPath = &apiv1.HostPathVolumeSource{
+			Path: s.HostPath,
+		}
+	}
+
+	spec.Volumes = append(spec.Volumes, volume)
+	init.VolumeMounts = append(init.VolumeMounts, apiv1.VolumeMount{
+		Name:      headersSourceVolume,
+		MountPath: headersSourceMountPath,
+		ReadOnly:  true,
+	})
+	init.Env = append(init.Env, apiv1.EnvVar{Name: headersSourceDirEnv, Value: headersSourceMountPath})
+	return nil
+}
+
+// HeadersSourceFromPodSpec returns the headers source a pod was given. An image source is the image
+// picked for the kernel release of the node.
+func HeadersSourceFromPodSpec(spec *apiv1.PodSpec) HeadersSource {
+	s := HeadersSource{}
+	for _, c := range spec.InitContainers {
+		if c.Name == headersImageContainerName {
+			s.Image = c.Image
+		}
+		for _, e := range c.Env {
+			if e.Name == headersMirrorURLEnv {
+				s.MirrorURL = e.Value
+			}
+		}
+	}
+	for _, v := range spec.Volumes {
+		if v.Name != headersSourceVolume {
+			continue
+		}
+		if v.PersistentVolumeClaim != nil {
+			s.PVC = v.PersistentVolumeClaim.ClaimName
+		}
+		if v.HostPath != nil {
+			s.HostPath = v.HostPath.Path
+		}
+	}
+	return s
+}
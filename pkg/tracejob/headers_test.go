@@ -0,0 +1,143 @@
+package tracejob
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestParseHeaderMode(t *testing.T) {
+	m, err := ParseHeaderMode("btf")
+	assert.Nil(t, err)
+	assert.Equal(t, HeaderModeBTF, m)
+
+	_, err = ParseHeaderMode("download")
+	assert.EqualError(t, err, `invalid header mode "download", must be one of auto, btf, host, fetch`)
+}
+
+func TestResolveHeaderMode(t *testing.T) {
+	yes, no := boolPtr(true), boolPtr(false)
+	tests := []struct {
+		name     string
+		facts    NodeFacts
+		expected HeaderMode
+	}{
+		{
+			name:     "btf available",
+			facts:    NodeFacts{KernelVersion: "5.8.0", BTF: yes, Headers: no},
+			expected: HeaderModeBTF,
+		},
+		{
+			name:     "btf preferred over headers",
+			facts:    NodeFacts{KernelVersion: "5.8.0", BTF: yes, Headers: yes},
+			expected: HeaderModeBTF,
+		},
+		{
+			name:     "host headers",
+			facts:    NodeFacts{KernelVersion: "4.15.0", BTF: no, Headers: yes},
+			expected: HeaderModeHost,
+		},
+		{
+			name:     "nothing available",
+			facts:    NodeFacts{KernelVersion: "4.19.76-linuxkit", BTF: no, Headers: no},
+			expected: HeaderModeFetch,
+		},
+		{
+			name:     "undetected on container optimized os",
+			facts:    NodeFacts{KernelVersion: "4.19.112+", OSImage: "Container-Optimized OS from Google"},
+			expected: HeaderModeFetch,
+		},
+		{
+			name:     "undetected",
+			facts:    NodeFacts{KernelVersion: "4.15.0", OSImage: "Ubuntu 18.04.5 LTS"},
+			expected: HeaderModeHost,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mode, reason := ResolveHeaderMode(tt.facts)
+			assert.Equal(t, tt.expected, mode)
+			assert.Contains(t, reason, tt.facts.KernelVersion)
+		})
+	}
+}
+
+func TestHeadersSourceValidate(t *testing.T) {
+	assert.Nil(t, HeadersSource{}.Validate())
+	assert.Nil(t, HeadersSource{HostPath: "/var/lib/linux-headers"}.Validate())
+	assert.NotNil(t, HeadersSource{HostPath: "linux-headers"}.Validate())
+	assert.NotNil(t, HeadersSource{MirrorURL: "http://mirror/linux-{version}.tar.gz", PVC: "headers"}.Validate())
+}
+
+func newFetchTraceJob(s HeadersSource) TraceJob {
+	tj := newTestTraceJob()
+	tj.Headers = HeaderModeFetch
+	tj.HeadersSource = s
+	tj.KernelVersion = "5.4.0-1029-gke"
+	return tj
+}
+
+func initContainerEnv(c apiv1.Container) map[string]string {
+	env := map[string]string{}
+	for _, e := range c.Env {
+		env[e.Name] = e.Value
+	}
+	return env
+}
+
+func TestCreateJobHeadersMirror(t *testing.T) {
+	job, err := newTestClient().CreateJob(newFetchTraceJob(HeadersSource{MirrorURL: "http://mirror.internal/v{major}.x/linux-{version}.tar.gz"}))
+	require.Nil(t, err)
+
+	require.Len(t, job.Spec.Template.Spec.InitContainers, 1)
+	env := initContainerEnv(job.Spec.Template.Spec.InitContainers[0])
+	assert.Equal(t, "http://mirror.internal/v{major}.x/linux-{version}.tar.gz", env["HEADERS_MIRROR_URL"])
+	assert.NotContains(t, env, "HEADERS_SOURCE_DIR")
+}
+
+func TestCreateJobHeadersImage(t *testing.T) {
+	job, err := newTestClient().CreateJob(newFetchTraceJob(HeadersSource{Image: "registry.internal/linux-headers:{release}"}))
+	require.Nil(t, err)
+
+	spec := job.Spec.Template.Spec
+	require.Len(t, spec.InitContainers, 2)
+	assert.Equal(t, "registry.internal/linux-headers:5.4.0-1029-gke", spec.InitContainers[0].Image)
+	assert.Equal(t, "kubectl-trace-init", spec.InitContainers[1].Name)
+	assert.Equal(t, "/headers-source", initContainerEnv(spec.InitContainers[1])["HEADERS_SOURCE_DIR"])
+	assert.Equal(t, &apiv1.EmptyDirVolumeSource{}, spec.Volumes[len(spec.Volumes)-1].EmptyDir)
+}
+
+func TestCreateJobHeadersImageWithoutKernelVersion(t *testing.T) {
+	tj := newFetchTraceJob(HeadersSource{Image: "registry.internal/linux-headers:{release}"})
+	tj.KernelVersion = ""
+
+	_, err := newTestClient().CreateJob(tj)
+	assert.NotNil(t, err)
+}
+
+func TestCreateJobHeadersPVC(t *testing.T) {
+	job, err := newTestClient().CreateJob(newFetchTraceJob(HeadersSource{PVC: "linux-headers"}))
+	require.Nil(t, err)
+
+	spec := job.Spec.Template.Spec
+	require.Len(t, spec.InitContainers, 1)
+	assert.Equal(t, "/headers-source", initContainerEnv(spec.InitContainers[0])["HEADERS_SOURCE_DIR"])
+	volume := spec.Volumes[len(spec.Volumes)-1]
+	require.NotNil(t, volume.PersistentVolumeClaim)
+	assert.Equal(t, "linux-headers", volume.PersistentVolumeClaim.ClaimName)
+}
+
+func TestCreateJobHeadersSourceWithoutFetch(t *testing.T) {
+	tj := newFetchTraceJob(HeadersSource{HostPath: "/var/lib/linux-headers"})
+	tj.Headers = HeaderModeBTF
+
+	_, err := newTestClient().CreateJob(tj)
+	assert.EqualError(t, err, "a headers source is only used with fetch or auto headers, not with btf headers")
+
+	tj.Headers = HeaderModeHost
+	_, err = newTestClient().CreateJob(tj)
+	assert.EqualError(t, err, "a headers source is only used with fetch or auto headers, not with host headers")
+}
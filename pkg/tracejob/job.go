@@ -6,16 +6,22 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	jsonpatch "github.com/evanphx/json-patch"
 	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/termination"
 	batchv1 "k8s.io/api/batch/v1"
 	apiv1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/wait"
 	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
 	batchv1typed "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1typed "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -25,6 +31,7 @@ import (
 type TraceJobClient struct {
 	JobClient    batchv1typed.JobInterface
 	ConfigClient corev1typed.ConfigMapInterface
+	PodClient    corev1typed.PodInterface
 	outStream    io.Writer
 }
 
@@ -33,19 +40,35 @@ type TraceJob struct {
 	Name                string
 	ID                  types.UID
 	Namespace           string
+	TargetNamespace     string
 	ServiceAccount      string
 	Hostname            string
+	Target              string
 	Program             string
+	Args                []string
 	PodUID              string
 	ContainerName       string
 	IsPod               bool
 	ImageNameTag        string
 	InitImageNameTag    string
-	FetchHeaders        bool
+	Headers             HeaderMode
+	HeadersReason       string
+	HeadersSource       HeadersSource
+	KernelVersion       string
+	RuntimeVersion      string
+	SecurityProfile     SecurityProfile
+	AppArmor            bool
+	PodOptions          PodOptions
+	Validate            bool
+	FollowRestarts      bool
+	WaitForContainer    bool
+	ProbePattern        string
+	NodeInfo            bool
 	Deadline            int64
 	DeadlineGracePeriod int64
 	StartTime           *metav1.Time
 	Status              TraceJobStatus
+	TerminationMessage  *termination.Message
 	Patch               string
 	PatchType           string
 }
@@ -61,6 +84,54 @@ func (t *TraceJobClient) WithOutStream(o io.Writer) {
 type TraceJobFilter struct {
 	Name *string
 	ID   *types.UID
+	// TargetNamespace selects the traces running against pods of a namespace
+	TargetNamespace *string
+	// Target selects the traces running against a node or a pod, as node/NAME or pod/NAME
+	Target *string
+	// CreatedBefore selects the traces created before a time
+	CreatedBefore *time.Time
+	// Selector is a label selector the traces must match as well
+	Selector string
+
+	// Status and Node select the traces by the status and the node of their job,
+	// their config maps are selected along with them
+	Status *TraceJobStatus
+	Node   *string
+}
+
+// matches tells whether an object of a trace job passes the filters that selectors cannot express.
+func (nf TraceJobFilter) matches(om metav1.ObjectMeta) bool {
+	if nf.TargetNamespace != nil && om.Annotations[meta.TargetNamespaceAnnotationKey] != *nf.TargetNamespace {
+		return false
+	}
+	if nf.Target != nil && om.Annotations[meta.TargetAnnotationKey] != *nf.Target {
+		return false
+	}
+	if nf.CreatedBefore != nil && !om.CreationTimestamp.Time.Before(*nf.CreatedBefore) {
+		return false
+	}
+	return true
+}
+
+// matchesJob tells whether a trace job passes the filters, including the ones about jobs only.
+func (nf TraceJobFilter) matchesJob(j batchv1.Job) bool {
+	if !nf.matches(j.ObjectMeta) {
+		return false
+	}
+	if nf.Status != nil && jobStatus(j) != *nf.Status {
+		return false
+	}
+	if nf.Node != nil {
+		if hostname, err := JobHostname(j); err != nil || hostname != *nf.Node {
+			return false
+		}
+	}
+	return true
+}
+
+// jobsOnly tells whether some filters are about jobs only, config maps then cannot be selected on their own.
+func (nf TraceJobFilter) jobsOnly() bool {
+	return nf.Status != nil || nf.Node != nil
 }
 
 func (nf TraceJobFilter) selectorOptions() metav1.ListOptions {
@@ -84,6 +155,10 @@ func (nf TraceJobFilter) selectorOptions() metav1.ListOptions {
 		}
 	}
 
+	if len(nf.Selector) > 0 {
+		selectorOptions.LabelSelector += "," + nf.Selector
+	}
+
 	return selectorOptions
 }
 
@@ -99,7 +174,14 @@ func (t *TraceJobClient) findJobsWithFilter(nf TraceJobFilter) ([]batchv1.Job, e
 	if err != nil {
 		return nil, err
 	}
-	return jl.Items, nil
+
+	jobs := []batchv1.Job{}
+	for _, j := range jl.Items {
+		if nf.matchesJob(j) {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs, nil
 }
 
 func (t *TraceJobClient) findConfigMapsWithFilter(nf TraceJobFilter) ([]apiv1.ConfigMap, error) {
@@ -113,9 +195,17 @@ func (t *TraceJobClient) findConfigMapsWithFilter(nf TraceJobFilter) ([]apiv1.Co
 	if err != nil {
 		return nil, err
 	}
-	return cm.Items, nil
+
+	configMaps := []apiv1.ConfigMap{}
+	for _, c := range cm.Items {
+		if nf.matches(c.ObjectMeta) {
+			configMaps = append(configMaps, c)
+		}
+	}
+	return configMaps, nil
 }
 
+// When a PodClient is available, the termination messages of the traces are returned along with them.
 func (t *TraceJobClient) GetJob(nf TraceJobFilter) ([]TraceJob, error) {
 	jl, err := t.findJobsWithFilter(nf)
 	if err != nil {
@@ -123,91 +213,390 @@ func (t *TraceJobClient) GetJob(nf TraceJobFilter) ([]TraceJob, error) {
 	}
 	tjobs := []TraceJob{}
 
+	var messages map[types.UID]*termination.Message
+	if t.PodClient != nil && len(jl) > 0 {
+		messages, err = t.terminationMessages(meta.TraceIDLabelKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	for _, j := range jl {
-		labels := j.GetLabels()
-		name, ok := labels[meta.TraceLabelKey]
-		if !ok {
-			name = ""
+		tj := traceJobFromJob(j)
+		tj.TerminationMessage = messages[tj.ID]
+		tjobs = append(tjobs, tj)
+	}
+
+	return tjobs, nil
+}
+
+// WaitJob blocks until the trace job matching the filter has finished or ctx is done.
+// When a PodClient is available, the termination message of the trace is returned along with it.
+func (t *TraceJobClient) WaitJob(ctx context.Context, nf TraceJobFilter) (*TraceJob, error) {
+	var job *batchv1.Job
+	err := wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		jl, err := t.findJobsWithFilter(nf)
+		if err != nil {
+			return false, err
+		}
+		if len(jl) == 0 {
+			return false, fmt.Errorf("no trace found with the provided criterias")
 		}
-		id, ok := labels[meta.TraceIDLabelKey]
-		if !ok {
-			id = ""
+		if !jobFinished(jl[0]) {
+			return false, nil
 		}
-		hostname, err := jobHostname(j)
+		job = &jl[0]
+		return true, nil
+	}, ctx.Done())
+	if err != nil {
+		return nil, err
+	}
+
+	tj := traceJobFromJob(*job)
+	if t.PodClient != nil {
+		tj.TerminationMessage, err = t.terminationMessage(tj.ID)
 		if err != nil {
-			hostname = ""
+			return nil, err
 		}
-		tj := TraceJob{
-			Name:      name,
-			ID:        types.UID(id),
-			Namespace: j.Namespace,
-			Hostname:  hostname,
-			StartTime: j.Status.StartTime,
-			Status:    jobStatus(j),
+	}
+	return &tj, nil
+}
+
+// terminationMessage returns the termination message of the most recent pod of the trace, if any.
+func (t *TraceJobClient) terminationMessage(id types.UID) (*termination.Message, error) {
+	messages, err := t.terminationMessages(fmt.Sprintf("%s=%s", meta.TraceIDLabelKey, id))
+	if err != nil {
+		return nil, err
+	}
+	return messages[id], nil
+}
+
+// terminationMessages returns the termination messages of the most recent pods of the traces matching the selector,
+// by trace id.
+func (t *TraceJobClient) terminationMessages(selector string) (map[types.UID]*termination.Message, error) {
+	pl, err := t.PodClient.List(context.Background(), metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	latest := map[types.UID]*apiv1.Pod{}
+	for i, p := range pl.Items {
+		id := types.UID(p.Labels[meta.TraceIDLabelKey])
+		if l, ok := latest[id]; !ok || l.CreationTimestamp.Before(&p.CreationTimestamp) {
+			latest[id] = &pl.Items[i]
 		}
-		tjobs = append(tjobs, tj)
 	}
 
-	return tjobs, nil
+	messages := map[types.UID]*termination.Message{}
+	for id, p := range latest {
+		if m := termination.FromPod(p); m != nil {
+			messages[id] = m
+		}
+	}
+	return messages, nil
 }
 
-func (t *TraceJobClient) DeleteJobs(nf TraceJobFilter) error {
-	nothingDeleted := true
+func traceJobFromJob(j batchv1.Job) TraceJob {
+	labels := j.GetLabels()
+	name, ok := labels[meta.TraceLabelKey]
+	if !ok {
+		name = ""
+	}
+	id, ok := labels[meta.TraceIDLabelKey]
+	if !ok {
+		id = ""
+	}
+	hostname, err := JobHostname(j)
+	if err != nil {
+		hostname = ""
+	}
+	return TraceJob{
+		Name:            name,
+		ID:              types.UID(id),
+		Namespace:       j.Namespace,
+		Target:          j.Annotations[meta.TargetAnnotationKey],
+		TargetNamespace: j.Annotations[meta.TargetNamespaceAnnotationKey],
+		Hostname:        hostname,
+		StartTime:       j.Status.StartTime,
+		Status:          jobStatus(j),
+	}
+}
+
+// DeleteWorkers is how many trace objects DeleteJobs deletes at once.
+const DeleteWorkers = 8
+
+// DeleteJobs deletes the jobs and the config maps of the traces matching the filter, reporting each deletion.
+// Deletions go on past failures, which are returned together. It returns the IDs of the traces whose job was deleted.
+func (t *TraceJobClient) DeleteJobs(nf TraceJobFilter) ([]types.UID, error) {
 	jl, err := t.findJobsWithFilter(nf)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	cl, err := t.findConfigMapsWithFilter(nf)
+	if err != nil {
+		return nil, err
+	}
+
+	// Config maps cannot be told apart by the status or the node of their trace, only those of the jobs found are deleted
+	if nf.jobsOnly() {
+		ids := map[string]bool{}
+		for _, j := range jl {
+			ids[j.Labels[meta.TraceIDLabelKey]] = true
+		}
+		selected := []apiv1.ConfigMap{}
+		for _, c := range cl {
+			if ids[c.Labels[meta.TraceIDLabelKey]] {
+				selected = append(selected, c)
+			}
+		}
+		cl = selected
+	}
+
+	if len(jl) == 0 && len(cl) == 0 {
+		fmt.Fprintf(t.outStream, "error: no trace found to be deleted\n")
+		return nil, nil
+	}
+
+	var (
+		mu       sync.Mutex
+		deleted  []types.UID
+		failures []string
+	)
+	report := func(err error, format string, args ...interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf(format, args...)+": "+err.Error())
+			return
+		}
+		fmt.Fprintf(t.outStream, format+" deleted\n", args...)
 	}
 
 	dp := metav1.DeletePropagationForeground
+	tasks := []func(){}
 	for _, j := range jl {
-		err := t.JobClient.Delete(context.Background(), j.Name, metav1.DeleteOptions{
-			GracePeriodSeconds: int64Ptr(0),
-			PropagationPolicy:  &dp,
+		j := j
+		tasks = append(tasks, func() {
+			err := t.JobClient.Delete(context.Background(), j.Name, metav1.DeleteOptions{
+				GracePeriodSeconds: int64Ptr(0),
+				PropagationPolicy:  &dp,
+			})
+			if errors.IsNotFound(err) {
+				err = nil
+			}
+			if err == nil {
+				mu.Lock()
+				deleted = append(deleted, types.UID(j.Labels[meta.TraceIDLabelKey]))
+				mu.Unlock()
+			}
+			report(err, "trace job %s", j.Name)
 		})
+	}
+	for _, c := range cl {
+		c := c
+		tasks = append(tasks, func() {
+			// The config maps owned by the jobs may be gone with them already
+			err := t.ConfigClient.Delete(context.Background(), c.Name, metav1.DeleteOptions{})
+			if errors.IsNotFound(err) {
+				err = nil
+			}
+			report(err, "trace configuration %s", c.Name)
+		})
+	}
+	runTasks(tasks, DeleteWorkers)
+
+	fmt.Fprintf(t.outStream, "%d of %d trace objects deleted\n", len(tasks)-len(failures), len(tasks))
+	if len(failures) > 0 {
+		sort.Strings(failures)
+		return deleted, fmt.Errorf("could not delete %d trace objects:\n  - %s", len(failures), strings.Join(failures, "\n  - "))
+	}
+	return deleted, nil
+}
+
+// runTasks runs the tasks with at most workers of them at once, and returns once they are all done.
+func runTasks(tasks []func(), workers int) {
+	ch := make(chan func())
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(tasks); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range ch {
+				task()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		ch <- task
+	}
+	close(ch)
+	wg.Wait()
+}
+
+// WaitPodsDeleted blocks until the pods of the traces are gone or ctx is done.
+func (t *TraceJobClient) WaitPodsDeleted(ctx context.Context, ids []types.UID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	values := []string{}
+	for _, id := range ids {
+		values = append(values, string(id))
+	}
+	selector := fmt.Sprintf("%s in (%s)", meta.TraceIDLabelKey, strings.Join(values, ","))
+
+	return wait.PollImmediateUntil(time.Second, func() (bool, error) {
+		pl, err := t.PodClient.List(ctx, metav1.ListOptions{LabelSelector: selector})
 		if err != nil {
-			return err
+			return false, err
+		}
+		return len(pl.Items) == 0, nil
+	}, ctx.Done())
+}
+
+// ParseTraceJobStatus parses a trace status, whatever its case.
+func ParseTraceJobStatus(s string) (TraceJobStatus, error) {
+	for _, status := range []TraceJobStatus{TraceJobRunning, TraceJobCompleted, TraceJobFailed} {
+		if strings.EqualFold(s, string(status)) {
+			return status, nil
 		}
-		fmt.Fprintf(t.outStream, "trace job %s deleted\n", j.Name)
-		nothingDeleted = false
 	}
+	return "", fmt.Errorf("invalid status %q, must be one of running, completed, failed", s)
+}
 
-	cl, err := t.findConfigMapsWithFilter(nf)
+// CreateJob creates the config map holding the program and the job running it.
+func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
+	job, cm, err := RenderJob(nj)
+	if err != nil {
+		return nil, err
+	}
 
+	ctx := context.Background()
+	cm, err = t.ConfigClient.Create(ctx, cm, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	created, err := t.JobClient.Create(ctx, job, metav1.CreateOptions{})
 	if err != nil {
-		return err
+		return nil, t.rollback(err, nil, cm)
 	}
 
-	for _, c := range cl {
-		err := t.ConfigClient.Delete(context.Background(), c.Name, metav1.DeleteOptions{})
-		if err != nil {
-			return err
+	// The config map is owned by the job, so that it is garbage collected along with it.
+	// The job cannot exist before the config map its pod mounts, the reference is added afterwards.
+	cm.OwnerReferences = append(cm.OwnerReferences, metav1.OwnerReference{
+		APIVersion: jobTypeMeta.APIVersion,
+		Kind:       jobTypeMeta.Kind,
+		Name:       created.Name,
+		UID:        created.UID,
+	})
+	if _, err := t.ConfigClient.Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
+		return nil, t.rollback(err, created, cm)
+	}
+	return created, nil
+}
+
+// rollback deletes what was created of a trace before err happened, so that a failed creation leaves nothing behind.
+// It returns err, along with the objects that could not be deleted.
+func (t *TraceJobClient) rollback(err error, job *batchv1.Job, cm *apiv1.ConfigMap) error {
+	ctx := context.Background()
+	failed := []string{}
+	if job != nil {
+		dp := metav1.DeletePropagationForeground
+		derr := t.JobClient.Delete(ctx, job.Name, metav1.DeleteOptions{PropagationPolicy: &dp})
+		if derr != nil && !errors.IsNotFound(derr) {
+			failed = append(failed, fmt.Sprintf("job %s: %s", job.Name, derr))
 		}
-		fmt.Fprintf(t.outStream, "trace configuration %s deleted\n", c.Name)
-		nothingDeleted = false
 	}
+	if cm != nil {
+		derr := t.ConfigClient.Delete(ctx, cm.Name, metav1.DeleteOptions{})
+		if derr != nil && !errors.IsNotFound(derr) {
+			failed = append(failed, fmt.Sprintf("config map %s: %s", cm.Name, derr))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s, and the trace could not be cleaned up, delete it with kubectl trace delete: %s", err, strings.Join(failed, ", "))
+	}
+	return err
+}
 
-	if nothingDeleted {
-		fmt.Fprintf(t.outStream, "error: no trace found to be deleted\n")
+// DryRunJob submits the job and the config map for the API server to validate them, admission included,
+// without persisting them. It returns them as the API server would have created them.
+func (t *TraceJobClient) DryRunJob(nj TraceJob) (*batchv1.Job, *apiv1.ConfigMap, error) {
+	job, cm, err := RenderJob(nj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := metav1.CreateOptions{DryRun: []string{metav1.DryRunAll}}
+	cm, err = t.ConfigClient.Create(context.Background(), cm, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	job, err = t.JobClient.Create(context.Background(), job, opts)
+	if err != nil {
+		return nil, nil, err
 	}
-	return nil
+
+	// The API server does not return the kinds of typed objects
+	cm.TypeMeta = configMapTypeMeta
+	job.TypeMeta = jobTypeMeta
+	return job, cm, nil
 }
 
-func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
+var (
+	jobTypeMeta       = metav1.TypeMeta{APIVersion: "batch/v1", Kind: "Job"}
+	configMapTypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"}
+)
 
+// terminationGraceMargin is the time left to the trace runner past the grace period of the program,
+// to kill it and report why it exited.
+const terminationGraceMargin = 5
+
+// RenderJob returns the job of a trace, patched, and the config map holding its program, without creating them.
+func RenderJob(nj TraceJob) (*batchv1.Job, *apiv1.ConfigMap, error) {
+	// The trace runner enforces the deadline, reading it from the config map so that it can be changed,
+	// and gives the program the grace period to print its maps once interrupted
 	bpfTraceCmd := []string{
-		"/bin/timeout",
-		"--preserve-status",
-		"--signal",
-		"INT",
-		strconv.FormatInt(nj.Deadline, 10),
 		"/bin/trace-runner",
-		"--program=/programs/program.bt",
+		"--program=/programs/" + ProgramKey,
+		"--deadline-file=/programs/" + DeadlineKey,
+		fmt.Sprintf("--grace-period=%ds", nj.DeadlineGracePeriod),
 	}
 
 	if nj.IsPod {
 		bpfTraceCmd = append(bpfTraceCmd, "--inpod")
 		bpfTraceCmd = append(bpfTraceCmd, "--container="+nj.ContainerName)
 		bpfTraceCmd = append(bpfTraceCmd, "--poduid="+nj.PodUID)
+		if nj.FollowRestarts {
+			bpfTraceCmd = append(bpfTraceCmd, "--follow-restarts")
+		}
+		if nj.WaitForContainer {
+			bpfTraceCmd = append(bpfTraceCmd, "--wait-for-container")
+		}
+	}
+
+	for _, a := range nj.Args {
+		bpfTraceCmd = append(bpfTraceCmd, "--arg="+a)
+	}
+
+	// Listing probes replaces running the program
+	if len(nj.ProbePattern) > 0 {
+		bpfTraceCmd = append(bpfTraceCmd, "--list="+nj.ProbePattern)
+	}
+
+	// So does reporting about the node
+	if nj.NodeInfo {
+		bpfTraceCmd = append(bpfTraceCmd, "--node-info")
+	}
+
+	// A trace whose pod failed, evicted or killed with its node, is retried once.
+	// A program failing validation would only fail again, trace-runner exits with termination.CompileErrorExitCode
+	// for it so that a pod failure policy can fail the job right away. Pod failure policies come with
+	// batch/v1 in Kubernetes 1.26, the client libraries used here predate them: such a job fails on the retry.
+	if nj.Validate {
+		bpfTraceCmd = append(bpfTraceCmd, "--validate")
 	}
 
 	commonMeta := metav1.ObjectMeta{
@@ -223,14 +612,36 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 		},
 	}
 
+	// Record how headers are provided, so that failures are explainable
+	if len(nj.Headers) > 0 {
+		commonMeta.Annotations[meta.HeaderModeAnnotationKey] = string(nj.Headers)
+	}
+	if len(nj.HeadersReason) > 0 {
+		commonMeta.Annotations[meta.HeaderModeReasonAnnotationKey] = nj.HeadersReason
+	}
+	if len(nj.SecurityProfile) > 0 {
+		commonMeta.Annotations[meta.SecurityProfileAnnotationKey] = string(nj.SecurityProfile)
+	}
+	if len(nj.Target) > 0 {
+		commonMeta.Annotations[meta.TargetAnnotationKey] = nj.Target
+	}
+	if len(nj.TargetNamespace) > 0 {
+		commonMeta.Annotations[meta.TargetNamespaceAnnotationKey] = nj.TargetNamespace
+	}
+	addMissing(commonMeta.Labels, nj.PodOptions.Labels)
+	addMissing(commonMeta.Annotations, nj.PodOptions.Annotations)
+
 	cm := &apiv1.ConfigMap{
+		TypeMeta:   configMapTypeMeta,
 		ObjectMeta: commonMeta,
 		Data: map[string]string{
-			"program.bt": nj.Program,
+			ProgramKey:  nj.Program,
+			DeadlineKey: strconv.FormatInt(nj.Deadline, 10),
 		},
 	}
 
 	job := &batchv1.Job{
+		TypeMeta:   jobTypeMeta,
 		ObjectMeta: commonMeta,
 		Spec: batchv1.JobSpec{
 			ActiveDeadlineSeconds:   int64Ptr(nj.Deadline + nj.DeadlineGracePeriod),
@@ -281,21 +692,12 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 					},
 					Containers: []apiv1.Container{
 						apiv1.Container{
-							Name:    nj.Name,
-							Image:   nj.ImageNameTag,
-							Command: bpfTraceCmd,
-							TTY:     true,
-							Stdin:   true,
-							Resources: apiv1.ResourceRequirements{
-								Requests: apiv1.ResourceList{
-									apiv1.ResourceCPU:    resource.MustParse("100m"),
-									apiv1.ResourceMemory: resource.MustParse("100Mi"),
-								},
-								Limits: apiv1.ResourceList{
-									apiv1.ResourceCPU:    resource.MustParse("1"),
-									apiv1.ResourceMemory: resource.MustParse("1G"),
-								},
-							},
+							Name:      nj.Name,
+							Image:     nj.ImageNameTag,
+							Command:   bpfTraceCmd,
+							TTY:       true,
+							Stdin:     true,
+							Resources: defaultResources(),
 							VolumeMounts: []apiv1.VolumeMount{
 								apiv1.VolumeMount{
 									Name:      "program",
@@ -311,23 +713,12 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 							SecurityContext: &apiv1.SecurityContext{
 								Privileged: boolPtr(true),
 							},
-							// We want to send SIGINT prior to the pod being killed, so we can print the map
-							// we will also wait for an arbitrary amount of time (10s) to give bpftrace time to
-							// process and summarize the data
-							Lifecycle: &apiv1.Lifecycle{
-								PreStop: &apiv1.Handler{
-									Exec: &apiv1.ExecAction{
-										Command: []string{
-											"/bin/bash",
-											"-c",
-											fmt.Sprintf("kill -SIGINT $(pidof bpftrace) && sleep %s", strconv.FormatInt(nj.DeadlineGracePeriod, 10)),
-										},
-									},
-								},
-							},
 						},
 					},
-					RestartPolicy: "Never",
+					// The trace runner interrupts the program on SIGTERM so that it prints its maps,
+					// leave it the grace period and the time to report why it exited
+					TerminationGracePeriodSeconds: int64Ptr(nj.DeadlineGracePeriod + terminationGraceMargin),
+					RestartPolicy:                 "Never",
 					Affinity: &apiv1.Affinity{
 						NodeAffinity: &apiv1.NodeAffinity{
 							RequiredDuringSchedulingIgnoredDuringExecution: &apiv1.NodeSelector{
@@ -346,106 +737,25 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 						},
 					},
 					Tolerations: []apiv1.Toleration{
-						apiv1.Toleration{
-							Effect:   apiv1.TaintEffectNoSchedule,
-							Operator: apiv1.TolerationOpExists,
-						},
+						defaultToleration,
 					},
 				},
 			},
 		},
 	}
 
-	if nj.FetchHeaders {
+	if nj.Headers != HeaderModeAuto {
+		if err := nj.HeadersSource.ValidateFor(nj.Headers); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch nj.Headers {
+	case HeaderModeFetch:
 		// If we are downloading headers, add the initContainer and set up mounts
-		job.Spec.Template.Spec.InitContainers = []apiv1.Container{
-			apiv1.Container{
-				Name:  "kubectl-trace-init",
-				Image: nj.InitImageNameTag,
-				Resources: apiv1.ResourceRequirements{
-					Requests: apiv1.ResourceList{
-						apiv1.ResourceCPU:    resource.MustParse("100m"),
-						apiv1.ResourceMemory: resource.MustParse("100Mi"),
-					},
-					Limits: apiv1.ResourceList{
-						apiv1.ResourceCPU:    resource.MustParse("1"),
-						apiv1.ResourceMemory: resource.MustParse("1G"),
-					},
-				},
-				VolumeMounts: []apiv1.VolumeMount{
-					apiv1.VolumeMount{
-						Name:      "lsb-release",
-						MountPath: "/etc/lsb-release.host",
-						ReadOnly:  true,
-					},
-					apiv1.VolumeMount{
-						Name:      "os-release",
-						MountPath: "/etc/os-release.host",
-						ReadOnly:  true,
-					},
-					apiv1.VolumeMount{
-						Name:      "modules-dir",
-						MountPath: "/lib/modules",
-					},
-					apiv1.VolumeMount{
-						Name:      "modules-host",
-						MountPath: "/lib/modules.host",
-						ReadOnly:  true,
-					},
-					apiv1.VolumeMount{
-						Name:      "linux-headers-generated",
-						MountPath: "/usr/src/",
-					},
-					apiv1.VolumeMount{
-						Name:      "boot-host",
-						MountPath: "/boot.host",
-					},
-				},
-			},
+		if err := AddFetchHeaders(&job.Spec.Template.Spec, nj.InitImageNameTag, nj.HeadersSource, nj.KernelVersion); err != nil {
+			return nil, nil, err
 		}
 
-		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes,
-			apiv1.Volume{
-				Name: "lsb-release",
-				VolumeSource: apiv1.VolumeSource{
-					HostPath: &apiv1.HostPathVolumeSource{
-						Path: "/etc/lsb-release",
-					},
-				},
-			},
-			apiv1.Volume{
-				Name: "os-release",
-				VolumeSource: apiv1.VolumeSource{
-					HostPath: &apiv1.HostPathVolumeSource{
-						Path: "/etc/os-release",
-					},
-				},
-			},
-			apiv1.Volume{
-				Name: "modules-dir",
-				VolumeSource: apiv1.VolumeSource{
-					HostPath: &apiv1.HostPathVolumeSource{
-						Path: "/var/cache/linux-headers/modules_dir",
-					},
-				},
-			},
-			apiv1.Volume{
-				Name: "linux-headers-generated",
-				VolumeSource: apiv1.VolumeSource{
-					HostPath: &apiv1.HostPathVolumeSource{
-						Path: "/var/cache/linux-headers/generated",
-					},
-				},
-			},
-			apiv1.Volume{
-				Name: "boot-host",
-				VolumeSource: apiv1.VolumeSource{
-					HostPath: &apiv1.HostPathVolumeSource{
-						Path: "/boot",
-					},
-				},
-			})
-
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts,
 			apiv1.VolumeMount{
 				Name:      "modules-dir",
@@ -463,7 +773,9 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 				ReadOnly:  true,
 			})
 
-	} else {
+	case HeaderModeBTF:
+		// The kernel describes its own types, there is nothing to mount
+	case "", HeaderModeHost:
 		// If we aren't downloading headers, unconditionally used the ones linked in /lib/modules
 		job.Spec.Template.Spec.Containers[0].VolumeMounts = append(job.Spec.Template.Spec.Containers[0].VolumeMounts,
 			apiv1.VolumeMount{
@@ -476,28 +788,128 @@ func (t *TraceJobClient) CreateJob(nj TraceJob) (*batchv1.Job, error) {
 				MountPath: "/lib/modules",
 				ReadOnly:  true,
 			})
+	default:
+		return nil, nil, fmt.Errorf("header mode %q must be resolved before creating the job", nj.Headers)
 	}
-	if _, err := t.ConfigClient.Create(context.Background(), cm, metav1.CreateOptions{}); err != nil {
-		return nil, err
+
+	switch nj.SecurityProfile {
+	case "", SecurityProfilePrivileged:
+		// Keep the privileged container
+	case SecurityProfileCapabilities:
+		dropPrivileges(&job.Spec.Template, nj.KernelVersion, nj.RuntimeVersion, nj.IsPod, nj.AppArmor)
+	default:
+		return nil, nil, fmt.Errorf("invalid security profile %q", nj.SecurityProfile)
 	}
 
+	applyPodOptions(&job.Spec.Template, nj.PodOptions)
+
 	// Optionally patch the job before creating it
 	if nj.PatchType != "" && nj.Patch != "" {
 		newJob, err := patchJobFile(job, nj.PatchType, nj.Patch)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		job = newJob
 	}
 
-	return t.JobClient.Create(context.Background(), job, metav1.CreateOptions{})
+	return job, cm, nil
+}
+
+// HeadersCachePath is where the init container keeps the headers it prepared on the node.
+const HeadersCachePath = "/var/cache/linux-headers"
+
+// AddFetchHeaders adds to the pod the init container that downloads and prepares the kernel headers
+// in the node cache, along with the volumes it needs except modules-host, the host /lib/modules.
+// The containers using the headers mount the modules-dir and linux-headers-generated volumes.
+func AddFetchHeaders(spec *apiv1.PodSpec, initImageNameTag string, source HeadersSource, kernelRelease string) error {
+	spec.InitContainers = append(spec.InitContainers,
+		apiv1.Container{
+			Name:      "kubectl-trace-init",
+			Image:     initImageNameTag,
+			Resources: defaultResources(),
+			VolumeMounts: []apiv1.VolumeMount{
+				apiv1.VolumeMount{
+					Name:      "lsb-release",
+					MountPath: "/etc/lsb-release.host",
+					ReadOnly:  true,
+				},
+				apiv1.VolumeMount{
+					Name:      "os-release",
+					MountPath: "/etc/os-release.host",
+					ReadOnly:  true,
+				},
+				apiv1.VolumeMount{
+					Name:      "modules-dir",
+					MountPath: "/lib/modules",
+				},
+				apiv1.VolumeMount{
+					Name:      "modules-host",
+					MountPath: "/lib/modules.host",
+					ReadOnly:  true,
+				},
+				apiv1.VolumeMount{
+					Name:      "linux-headers-generated",
+					MountPath: "/usr/src/",
+				},
+				apiv1.VolumeMount{
+					Name:      "boot-host",
+					MountPath: "/boot.host",
+				},
+			},
+		},
+	)
+
+	spec.Volumes = append(spec.Volumes,
+		apiv1.Volume{
+			Name: "lsb-release",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: "/etc/lsb-release",
+				},
+			},
+		},
+		apiv1.Volume{
+			Name: "os-release",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: "/etc/os-release",
+				},
+			},
+		},
+		apiv1.Volume{
+			Name: "modules-dir",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: HeadersCachePath + "/modules_dir",
+				},
+			},
+		},
+		apiv1.Volume{
+			Name: "linux-headers-generated",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: HeadersCachePath + "/generated",
+				},
+			},
+		},
+		apiv1.Volume{
+			Name: "boot-host",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: "/boot",
+				},
+			},
+		})
+
+	return addHeadersSource(spec, source, kernelRelease)
 }
 
 func int32Ptr(i int32) *int32 { return &i }
 func int64Ptr(i int64) *int64 { return &i }
 func boolPtr(b bool) *bool    { return &b }
 
-func jobHostname(j batchv1.Job) (string, error) {
+// JobHostname returns the node a trace job is scheduled on, from its node affinity.
+func JobHostname(j batchv1.Job) (string, error) {
 	aff := j.Spec.Template.Spec.Affinity
 	if aff == nil {
 		return "", fmt.Errorf("affinity not found for job")
@@ -553,6 +965,15 @@ const (
 	TraceJobUnknown TraceJobStatus = "Unknown"
 )
 
+func jobFinished(j batchv1.Job) bool {
+	for _, c := range j.Status.Conditions {
+		if (c.Type == batchv1.JobComplete || c.Type == batchv1.JobFailed) && c.Status == apiv1.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
 func jobStatus(j batchv1.Job) TraceJobStatus {
 	if j.Status.Active > 0 {
 		return TraceJobRunning
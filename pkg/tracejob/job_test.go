@@ -1,10 +1,25 @@
 package tracejob
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/termination"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 type patchTest struct {
@@ -73,3 +88,416 @@ func getJob() *batchv1.Job {
 		},
 	}
 }
+
+func newTestClient() *TraceJobClient {
+	cs := fake.NewSimpleClientset()
+	return &TraceJobClient{
+		JobClient:    cs.BatchV1().Jobs("default"),
+		ConfigClient: cs.CoreV1().ConfigMaps("default"),
+		PodClient:    cs.CoreV1().Pods("default"),
+	}
+}
+
+func newTestTraceJob() TraceJob {
+	return TraceJob{
+		Name:                "kubectl-trace-1bb3ae39-efe8-11e8-9f29-8c164500a77e",
+		ID:                  "1bb3ae39-efe8-11e8-9f29-8c164500a77e",
+		Namespace:           "default",
+		Hostname:            "node-1",
+		Program:             "BEGIN { exit(); }",
+		ImageNameTag:        "quay.io/iovisor/kubectl-trace-runner:latest",
+		Deadline:            60,
+		DeadlineGracePeriod: 10,
+	}
+}
+
+func TestCreateJobValidate(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.Validate = true
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "--validate")
+	assert.Equal(t, int32(1), *job.Spec.BackoffLimit)
+}
+
+func TestCreateJobWithoutValidate(t *testing.T) {
+	tc := newTestClient()
+
+	job, err := tc.CreateJob(newTestTraceJob())
+	require.Nil(t, err)
+
+	assert.NotContains(t, job.Spec.Template.Spec.Containers[0].Command, "--validate")
+	assert.Equal(t, int32(1), *job.Spec.BackoffLimit)
+}
+
+func TestCreateJobFollowRestarts(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.FollowRestarts = true
+
+	// Only pods are followed
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+	assert.NotContains(t, job.Spec.Template.Spec.Containers[0].Command, "--follow-restarts")
+
+	tc = newTestClient()
+	tj.IsPod = true
+	tj.ContainerName = "app"
+	tj.PodUID = "uid"
+	job, err = tc.CreateJob(tj)
+	require.Nil(t, err)
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "--follow-restarts")
+}
+
+func TestCreateJobWaitForContainer(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.IsPod = true
+	tj.ContainerName = "app"
+	tj.PodUID = "uid"
+	tj.WaitForContainer = true
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "--wait-for-container")
+}
+
+func TestCreateJobArgs(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.Target = "node/node-1"
+	tj.Args = []string{"10", "nginx"}
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	cmd := job.Spec.Template.Spec.Containers[0].Command
+	assert.Contains(t, cmd, "--arg=10")
+	assert.Contains(t, cmd, "--arg=nginx")
+	assert.Equal(t, "node/node-1", job.Annotations[meta.TargetAnnotationKey])
+
+	jobs, err := tc.GetJob(TraceJobFilter{Name: &tj.Name})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "node/node-1", jobs[0].Target)
+}
+
+func TestCreateJobGracePeriod(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.DeadlineGracePeriod = 20
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	// The trace runner handles the termination, there is no hook
+	c := job.Spec.Template.Spec.Containers[0]
+	assert.Contains(t, c.Command, "--grace-period=20s")
+	assert.Nil(t, c.Lifecycle)
+	assert.Equal(t, int64(25), *job.Spec.Template.Spec.TerminationGracePeriodSeconds)
+}
+
+func TestGetJobTerminationMessage(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	_, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	jobs, err := tc.GetJob(TraceJobFilter{ID: &tj.ID})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Nil(t, jobs[0].TerminationMessage)
+
+	_, err = tc.PodClient.Create(context.Background(), &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   tj.Name + "-abcde",
+			Labels: map[string]string{meta.TraceIDLabelKey: string(tj.ID)},
+		},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{{
+				State: apiv1.ContainerState{
+					Terminated: &apiv1.ContainerStateTerminated{
+						Message: `{"reason":"DeadlineExceeded","probesAttached":2,"runtimeSeconds":60}`,
+					},
+				},
+			}},
+		},
+	}, metav1.CreateOptions{})
+	require.Nil(t, err)
+
+	jobs, err = tc.GetJob(TraceJobFilter{ID: &tj.ID})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	require.NotNil(t, jobs[0].TerminationMessage)
+	assert.Equal(t, termination.Message{
+		Reason:         termination.ReasonDeadlineExceeded,
+		ProbesAttached: 2,
+		RuntimeSeconds: 60,
+	}, *jobs[0].TerminationMessage)
+}
+
+func TestRenderJob(t *testing.T) {
+	f, err := ioutil.TempFile("", "kubectl-trace-patch")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(patchMerge)
+	require.Nil(t, err)
+	require.Nil(t, f.Close())
+
+	tj := newTestTraceJob()
+	tj.Patch = f.Name()
+	tj.PatchType = "merge"
+
+	job, cm, err := RenderJob(tj)
+	require.Nil(t, err)
+
+	assert.Equal(t, "Job", job.Kind)
+	assert.Equal(t, "batch/v1", job.APIVersion)
+	assert.Equal(t, int32(123), *job.Spec.BackoffLimit)
+	assert.Equal(t, "ConfigMap", cm.Kind)
+	assert.Equal(t, tj.Program, cm.Data[ProgramKey])
+	assert.Equal(t, cm.Name, job.Spec.Template.Spec.Volumes[0].ConfigMap.Name)
+}
+
+func TestCreateJobOwnsConfigMap(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	cm, err := tc.ConfigClient.Get(context.Background(), tj.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+	require.Len(t, cm.OwnerReferences, 1)
+	assert.Equal(t, "Job", cm.OwnerReferences[0].Kind)
+	assert.Equal(t, job.Name, cm.OwnerReferences[0].Name)
+	assert.Equal(t, job.UID, cm.OwnerReferences[0].UID)
+}
+
+func TestCreateJobRollback(t *testing.T) {
+	for _, verb := range []string{"create", "update"} {
+		cs := fake.NewSimpleClientset()
+		resource := "jobs"
+		if verb == "update" {
+			resource = "configmaps"
+		}
+		cs.PrependReactor(verb, resource, func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, fmt.Errorf("denied by admission webhook")
+		})
+		tc := &TraceJobClient{
+			JobClient:    cs.BatchV1().Jobs("default"),
+			ConfigClient: cs.CoreV1().ConfigMaps("default"),
+		}
+
+		_, err := tc.CreateJob(newTestTraceJob())
+		assert.EqualError(t, err, "denied by admission webhook", verb)
+
+		jobs, err := tc.JobClient.List(context.Background(), metav1.ListOptions{})
+		require.Nil(t, err)
+		assert.Empty(t, jobs.Items, verb)
+		cms, err := tc.ConfigClient.List(context.Background(), metav1.ListOptions{})
+		require.Nil(t, err)
+		assert.Empty(t, cms.Items, verb)
+	}
+}
+
+func TestCreateJobProbePattern(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.ProbePattern = "kprobe:tcp_*"
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "--list=kprobe:tcp_*")
+}
+
+func TestCreateJobNodeInfo(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.NodeInfo = true
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	assert.Contains(t, job.Spec.Template.Spec.Containers[0].Command, "--node-info")
+}
+
+func TestCreateJobHeaderModeBTF(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.Headers = HeaderModeBTF
+	tj.HeadersReason = "kernel 5.8.0 exposes BTF"
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	assert.Empty(t, job.Spec.Template.Spec.InitContainers)
+	for _, m := range job.Spec.Template.Spec.Containers[0].VolumeMounts {
+		assert.NotEqual(t, "/lib/modules", m.MountPath)
+	}
+	assert.Equal(t, "btf", job.Annotations[meta.HeaderModeAnnotationKey])
+	assert.Equal(t, "kernel 5.8.0 exposes BTF", job.Annotations[meta.HeaderModeReasonAnnotationKey])
+}
+
+func TestCreateJobHeaderModeFetch(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.Headers = HeaderModeFetch
+
+	job, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	assert.Len(t, job.Spec.Template.Spec.InitContainers, 1)
+	assert.Equal(t, "fetch", job.Annotations[meta.HeaderModeAnnotationKey])
+}
+
+func TestCreateJobHeaderModeAuto(t *testing.T) {
+	tc := newTestClient()
+	tj := newTestTraceJob()
+	tj.Headers = HeaderModeAuto
+
+	_, err := tc.CreateJob(tj)
+	assert.NotNil(t, err)
+}
+
+func TestTargetNamespaceFilter(t *testing.T) {
+	tc := newTestClient()
+	tc.WithOutStream(ioutil.Discard)
+
+	tj := newTestTraceJob()
+	tj.IsPod = true
+	tj.TargetNamespace = "payments"
+	_, err := tc.CreateJob(tj)
+	require.Nil(t, err)
+
+	other := newTestTraceJob()
+	other.Name = "kubectl-trace-2cc4bf4a-efe8-11e8-9f29-8c164500a77e"
+	other.ID = "2cc4bf4a-efe8-11e8-9f29-8c164500a77e"
+	_, err = tc.CreateJob(other)
+	require.Nil(t, err)
+
+	payments := "payments"
+	jobs, err := tc.GetJob(TraceJobFilter{TargetNamespace: &payments})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, tj.ID, jobs[0].ID)
+	assert.Equal(t, "payments", jobs[0].TargetNamespace)
+
+	_, err = tc.DeleteJobs(TraceJobFilter{TargetNamespace: &payments})
+	require.Nil(t, err)
+	jobs, err = tc.GetJob(TraceJobFilter{})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, other.ID, jobs[0].ID)
+
+	cms, err := tc.findConfigMapsWithFilter(TraceJobFilter{})
+	require.Nil(t, err)
+	assert.Len(t, cms, 1)
+}
+
+// createTestTraces creates n traces on node-1, the odd ones completed and labeled team=sre.
+func createTestTraces(t *testing.T, tc *TraceJobClient, n int) []TraceJob {
+	tjs := []TraceJob{}
+	for i := 0; i < n; i++ {
+		tj := newTestTraceJob()
+		tj.ID = types.UID(fmt.Sprintf("1bb3ae39-efe8-11e8-9f29-8c164500a7%02d", i))
+		tj.Name = meta.ObjectNamePrefix + string(tj.ID)
+		tj.Target = fmt.Sprintf("pod/app-%d", i)
+		if i%2 == 1 {
+			tj.PodOptions.Labels = map[string]string{"team": "sre"}
+		}
+		job, err := tc.CreateJob(tj)
+		require.Nil(t, err)
+		if i%2 == 1 {
+			job.Status.Succeeded = 1
+			_, err = tc.JobClient.UpdateStatus(context.Background(), job, metav1.UpdateOptions{})
+			require.Nil(t, err)
+		}
+		tjs = append(tjs, tj)
+	}
+	return tjs
+}
+
+func TestDeleteJobsFilters(t *testing.T) {
+	completed := TraceJobCompleted
+	node1, node2 := "node-1", "node-2"
+	target := "pod/app-2"
+
+	tests := []struct {
+		name     string
+		filter   TraceJobFilter
+		expected int
+	}{
+		{"status", TraceJobFilter{Status: &completed}, 2},
+		{"node", TraceJobFilter{Node: &node1}, 4},
+		{"other node", TraceJobFilter{Node: &node2}, 0},
+		{"target", TraceJobFilter{Target: &target}, 1},
+		{"selector", TraceJobFilter{Selector: "team=sre"}, 2},
+	}
+	for _, tt := range tests {
+		tc := newTestClient()
+		tc.WithOutStream(ioutil.Discard)
+		createTestTraces(t, tc, 4)
+
+		deleted, err := tc.DeleteJobs(tt.filter)
+		require.Nil(t, err, tt.name)
+		assert.Len(t, deleted, tt.expected, tt.name)
+
+		jobs, err := tc.GetJob(TraceJobFilter{})
+		require.Nil(t, err)
+		assert.Len(t, jobs, 4-tt.expected, tt.name)
+		// The config maps go along with their jobs
+		cms, err := tc.findConfigMapsWithFilter(TraceJobFilter{})
+		require.Nil(t, err)
+		assert.Len(t, cms, 4-tt.expected, tt.name)
+	}
+}
+
+func TestFilterCreatedBefore(t *testing.T) {
+	created := time.Date(2020, 11, 2, 12, 0, 0, 0, time.UTC)
+	om := metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)}
+
+	before, after := created.Add(-time.Hour), created.Add(time.Hour)
+	assert.False(t, TraceJobFilter{CreatedBefore: &before}.matches(om))
+	assert.True(t, TraceJobFilter{CreatedBefore: &after}.matches(om))
+}
+
+func TestDeleteJobsFailures(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	tc := &TraceJobClient{
+		JobClient:    cs.BatchV1().Jobs("default"),
+		ConfigClient: cs.CoreV1().ConfigMaps("default"),
+	}
+	tc.WithOutStream(ioutil.Discard)
+	tjs := createTestTraces(t, tc, 12)
+
+	failing := tjs[3].Name
+	cs.PrependReactor("delete", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.(k8stesting.DeleteAction).GetName() == failing {
+			return true, nil, fmt.Errorf("forbidden")
+		}
+		return false, nil, nil
+	})
+
+	deleted, err := tc.DeleteJobs(TraceJobFilter{})
+	assert.EqualError(t, err, "could not delete 1 trace objects:\n  - trace job "+failing+": forbidden")
+	assert.Len(t, deleted, 11)
+
+	jobs, err := tc.GetJob(TraceJobFilter{})
+	require.Nil(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, failing, jobs[0].Name)
+}
+
+func TestParseTraceJobStatus(t *testing.T) {
+	s, err := ParseTraceJobStatus("completed")
+	require.Nil(t, err)
+	assert.Equal(t, TraceJobCompleted, s)
+
+	_, err = ParseTraceJobStatus("done")
+	assert.EqualError(t, err, `invalid status "done", must be one of running, completed, failed`)
+}
@@ -0,0 +1,297 @@
+package tracejob
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const hostMountVolumePrefix = "host-mount-"
+
+// defaultToleration lets the trace pods run on the nodes that do not accept new pods.
+var defaultToleration = apiv1.Toleration{
+	Effect:   apiv1.TaintEffectNoSchedule,
+	Operator: apiv1.TolerationOpExists,
+}
+
+// defaultResources returns the requests and limits of the trace containers, before the ones given in the pod options.
+func defaultResources() apiv1.ResourceRequirements {
+	return apiv1.ResourceRequirements{
+		Requests: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("100m"),
+			apiv1.ResourceMemory: resource.MustParse("100Mi"),
+		},
+		Limits: apiv1.ResourceList{
+			apiv1.ResourceCPU:    resource.MustParse("1"),
+			apiv1.ResourceMemory: resource.MustParse("1G"),
+		},
+	}
+}
+
+// PodOptions are the common customizations of the trace pods, that would otherwise need a patch.
+type PodOptions struct {
+	// Requests and Limits override the default ones of the containers, per resource.
+	Requests          apiv1.ResourceList
+	Limits            apiv1.ResourceList
+	PriorityClassName string
+	// Tolerations are added to the one tolerating all the NoSchedule taints.
+	Tolerations      []apiv1.Toleration
+	ImagePullSecrets []string
+	ImagePullPolicy  apiv1.PullPolicy
+	// Labels and Annotations are added to the trace objects, without overriding the ones kubectl trace sets.
+	Labels      map[string]string
+	Annotations map[string]string
+	// Env is added to the environment of the trace container.
+	Env []apiv1.EnvVar
+	// HostMounts are host paths mounted read-only in the trace container.
+	HostMounts []HostMount
+}
+
+// HostMount is a directory of the node mounted in the trace container.
+type HostMount struct {
+	HostPath  string
+	MountPath string
+}
+
+// ParseResourceList parses resources given as a comma separated list of name=quantity, like cpu=1,memory=1G.
+func ParseResourceList(s string) (apiv1.ResourceList, error) {
+	rl := apiv1.ResourceList{}
+	if len(s) == 0 {
+		return rl, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid resource %q, must be name=quantity", kv)
+		}
+		name := apiv1.ResourceName(parts[0])
+		if name != apiv1.ResourceCPU && name != apiv1.ResourceMemory && name != apiv1.ResourceEphemeralStorage {
+			return nil, fmt.Errorf("invalid resource %q, must be one of cpu, memory, ephemeral-storage", parts[0])
+		}
+		q, err := resource.ParseQuantity(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid quantity %q for %s: %s", parts[1], name, err)
+		}
+		rl[name] = q
+	}
+	return rl, nil
+}
+
+// ParseToleration parses a toleration given like a taint, key=value:Effect. Without a value any value is tolerated,
+// without a key all the taints with the effect are, and without an effect all the effects are.
+func ParseToleration(s string) (apiv1.Toleration, error) {
+	t := apiv1.Toleration{Operator: apiv1.TolerationOpExists}
+
+	kv := s
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		kv = s[:i]
+		t.Effect = apiv1.TaintEffect(s[i+1:])
+		switch t.Effect {
+		case "", apiv1.TaintEffectNoSchedule, apiv1.TaintEffectPreferNoSchedule, apiv1.TaintEffectNoExecute:
+		default:
+			return t, fmt.Errorf("invalid toleration %q, the effect must be one of NoSchedule, PreferNoSchedule, NoExecute", s)
+		}
+	}
+
+	parts := strings.SplitN(kv, "=", 2)
+	t.Key = parts[0]
+	if len(parts) == 2 {
+		if len(t.Key) == 0 {
+			return t, fmt.Errorf("invalid toleration %q, a value needs a key", s)
+		}
+		t.Operator = apiv1.TolerationOpEqual
+		t.Value = parts[1]
+	}
+	return t, nil
+}
+
+// ParseKeyValues parses key=value pairs, as labels, annotations or environment variables.
+func ParseKeyValues(pairs []string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, kv := range pairs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid %q, must be key=value", kv)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// ParseHostMount parses a host mount given as /host/path, mounted at the same path, or /host/path:/mount/path.
+func ParseHostMount(s string) (HostMount, error) {
+	parts := strings.SplitN(s, ":", 2)
+	m := HostMount{HostPath: parts[0], MountPath: parts[0]}
+	if len(parts) == 2 {
+		m.MountPath = parts[1]
+	}
+	if !path.IsAbs(m.HostPath) || !path.IsAbs(m.MountPath) {
+		return m, fmt.Errorf("invalid host mount %q, the paths must be absolute", s)
+	}
+	return m, nil
+}
+
+// Validate checks the options can make a valid pod.
+func (o PodOptions) Validate() error {
+	switch o.ImagePullPolicy {
+	case "", apiv1.PullAlways, apiv1.PullIfNotPresent, apiv1.PullNever:
+	default:
+		return fmt.Errorf("invalid image pull policy %q, must be one of Always, IfNotPresent, Never", o.ImagePullPolicy)
+	}
+	// A request above its limit makes an invalid pod, the defaults count for what is not given
+	defaults := defaultResources()
+	requests := mergeResources(defaults.Requests, o.Requests)
+	limits := mergeResources(defaults.Limits, o.Limits)
+	for _, name := range []apiv1.ResourceName{apiv1.ResourceCPU, apiv1.ResourceMemory, apiv1.ResourceEphemeralStorage} {
+		r, ok := requests[name]
+		l, limited := limits[name]
+		if ok && limited && r.Cmp(l) > 0 {
+			return fmt.Errorf("the %s request %s is above its limit %s, raise the limit as well", name, r.String(), l.String())
+		}
+	}
+	for k, v := range o.Labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %q: %s", k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return fmt.Errorf("invalid label value %q: %s", v, strings.Join(errs, ", "))
+		}
+	}
+	for k := range o.Annotations {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return fmt.Errorf("invalid annotation key %q: %s", k, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// applyPodOptions customizes the trace pod, the trace container being the first one.
+func applyPodOptions(template *apiv1.PodTemplateSpec, o PodOptions) {
+	spec := &template.Spec
+
+	spec.PriorityClassName = o.PriorityClassName
+	spec.Tolerations = append(spec.Tolerations, o.Tolerations...)
+	for _, s := range o.ImagePullSecrets {
+		spec.ImagePullSecrets = append(spec.ImagePullSecrets, apiv1.LocalObjectReference{Name: s})
+	}
+
+	containers := []*apiv1.Container{}
+	for i := range spec.InitContainers {
+		containers = append(containers, &spec.InitContainers[i])
+	}
+	for i := range spec.Containers {
+		containers = append(containers, &spec.Containers[i])
+	}
+	for _, c := range containers {
+		c.ImagePullPolicy = o.ImagePullPolicy
+		c.Resources.Requests = mergeResources(c.Resources.Requests, o.Requests)
+		c.Resources.Limits = mergeResources(c.Resources.Limits, o.Limits)
+	}
+
+	c := &spec.Containers[0]
+	for _, e := range o.Env {
+		c.Env = append(c.Env, e)
+	}
+	for i, m := range o.HostMounts {
+		name := fmt.Sprintf("%s%d", hostMountVolumePrefix, i)
+		spec.Volumes = append(spec.Volumes, apiv1.Volume{
+			Name: name,
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: m.HostPath,
+				},
+			},
+		})
+		c.VolumeMounts = append(c.VolumeMounts, apiv1.VolumeMount{
+			Name:      name,
+			MountPath: m.MountPath,
+			ReadOnly:  true,
+		})
+	}
+}
+
+// mergeResources returns the resources with the overrides, without modifying them.
+func mergeResources(rl, overrides apiv1.ResourceList) apiv1.ResourceList {
+	if len(overrides) == 0 {
+		return rl
+	}
+	merged := apiv1.ResourceList{}
+	for k, v := range rl {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// addMissing adds the extra keys and values to m, keeping the values m has.
+func addMissing(m, extra map[string]string) {
+	for k, v := range extra {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+}
+
+// PodOptionsFromJob returns the customizations of the pod of a trace job. The resources are the ones
+// of the trace container, overridden or not, and the labels and annotations the ones kubectl trace does not set.
+func PodOptionsFromJob(job *batchv1.Job) PodOptions {
+	spec := &job.Spec.Template.Spec
+	o := PodOptions{
+		PriorityClassName: spec.PriorityClassName,
+	}
+
+	for _, t := range spec.Tolerations {
+		if t != defaultToleration {
+			o.Tolerations = append(o.Tolerations, t)
+		}
+	}
+	for _, s := range spec.ImagePullSecrets {
+		o.ImagePullSecrets = append(o.ImagePullSecrets, s.Name)
+	}
+	for k, v := range job.Labels {
+		if !strings.HasPrefix(k, meta.TraceLabelKey) {
+			if o.Labels == nil {
+				o.Labels = map[string]string{}
+			}
+			o.Labels[k] = v
+		}
+	}
+	for k, v := range job.Annotations {
+		if !strings.HasPrefix(k, meta.TraceLabelKey) {
+			if o.Annotations == nil {
+				o.Annotations = map[string]string{}
+			}
+			o.Annotations[k] = v
+		}
+	}
+
+	if len(spec.Containers) == 0 {
+		return o
+	}
+	c := spec.Containers[0]
+	o.ImagePullPolicy = c.ImagePullPolicy
+	o.Requests = c.Resources.Requests
+	o.Limits = c.Resources.Limits
+	o.Env = c.Env
+
+	hostPaths := map[string]string{}
+	for _, v := range spec.Volumes {
+		if strings.HasPrefix(v.Name, hostMountVolumePrefix) && v.HostPath != nil {
+			hostPaths[v.Name] = v.HostPath.Path
+		}
+	}
+	for _, m := range c.VolumeMounts {
+		if p, ok := hostPaths[m.Name]; ok {
+			o.HostMounts = append(o.HostMounts, HostMount{HostPath: p, MountPath: m.MountPath})
+		}
+	}
+	return o
+}
@@ -0,0 +1,109 @@
+package tracejob
+
+import (
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseResourceList(t *testing.T) {
+	rl, err := ParseResourceList("cpu=500m,memory=2G")
+	require.Nil(t, err)
+	assert.Equal(t, resource.MustParse("500m"), rl[apiv1.ResourceCPU])
+	assert.Equal(t, resource.MustParse("2G"), rl[apiv1.ResourceMemory])
+
+	_, err = ParseResourceList("gpu=1")
+	assert.EqualError(t, err, `invalid resource "gpu", must be one of cpu, memory, ephemeral-storage`)
+	_, err = ParseResourceList("cpu")
+	assert.EqualError(t, err, `invalid resource "cpu", must be name=quantity`)
+}
+
+func TestParseToleration(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected apiv1.Toleration
+	}{
+		{":NoExecute", apiv1.Toleration{Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute}},
+		{"dedicated:NoSchedule", apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoSchedule}},
+		{"dedicated=tracing:NoSchedule", apiv1.Toleration{Key: "dedicated", Operator: apiv1.TolerationOpEqual, Value: "tracing", Effect: apiv1.TaintEffectNoSchedule}},
+		{"example.com/gpu", apiv1.Toleration{Key: "example.com/gpu", Operator: apiv1.TolerationOpExists}},
+	}
+	for _, tt := range tests {
+		tol, err := ParseToleration(tt.s)
+		assert.Nil(t, err, tt.s)
+		assert.Equal(t, tt.expected, tol, tt.s)
+	}
+
+	_, err := ParseToleration("dedicated:Never")
+	assert.EqualError(t, err, `invalid toleration "dedicated:Never", the effect must be one of NoSchedule, PreferNoSchedule, NoExecute`)
+	_, err = ParseToleration("=tracing:NoSchedule")
+	assert.NotNil(t, err)
+}
+
+func TestParseHostMount(t *testing.T) {
+	m, err := ParseHostMount("/etc/ssl")
+	require.Nil(t, err)
+	assert.Equal(t, HostMount{HostPath: "/etc/ssl", MountPath: "/etc/ssl"}, m)
+
+	m, err = ParseHostMount("/opt/app:/app")
+	require.Nil(t, err)
+	assert.Equal(t, HostMount{HostPath: "/opt/app", MountPath: "/app"}, m)
+
+	_, err = ParseHostMount("opt/app")
+	assert.NotNil(t, err)
+}
+
+func TestPodOptionsValidate(t *testing.T) {
+	assert.Nil(t, PodOptions{ImagePullPolicy: apiv1.PullAlways, Labels: map[string]string{"team": "sre"}}.Validate())
+	assert.NotNil(t, PodOptions{ImagePullPolicy: "Sometimes"}.Validate())
+	assert.NotNil(t, PodOptions{Labels: map[string]string{"team": "s r e"}}.Validate())
+
+	// Requests are checked against the default limits when these are not given
+	assert.EqualError(t, PodOptions{Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("2G")}}.Validate(), "the memory request 2G is above its limit 1G, raise the limit as well")
+	assert.Nil(t, PodOptions{
+		Requests: apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("2G")},
+		Limits:   apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("4G")},
+	}.Validate())
+	assert.NotNil(t, PodOptions{Limits: apiv1.ResourceList{apiv1.ResourceCPU: resource.MustParse("50m")}}.Validate())
+}
+
+func TestCreateJobPodOptions(t *testing.T) {
+	tj := newTestTraceJob()
+	tj.Headers = HeaderModeFetch
+	tj.PodOptions = PodOptions{
+		Limits:            apiv1.ResourceList{apiv1.ResourceMemory: resource.MustParse("2G")},
+		PriorityClassName: "system-node-critical",
+		Tolerations:       []apiv1.Toleration{{Operator: apiv1.TolerationOpExists, Effect: apiv1.TaintEffectNoExecute}},
+		ImagePullSecrets:  []string{"registry"},
+		ImagePullPolicy:   apiv1.PullAlways,
+		Labels:            map[string]string{"team": "sre", meta.TraceLabelKey: "other"},
+		Annotations:       map[string]string{"ticket": "OPS-1"},
+		Env:               []apiv1.EnvVar{{Name: "BPFTRACE_STRLEN", Value: "200"}},
+		HostMounts:        []HostMount{{HostPath: "/opt/app", MountPath: "/app"}},
+	}
+
+	job, err := newTestClient().CreateJob(tj)
+	require.Nil(t, err)
+
+	spec := job.Spec.Template.Spec
+	assert.Equal(t, "system-node-critical", spec.PriorityClassName)
+	assert.Len(t, spec.Tolerations, 2)
+	assert.Equal(t, []apiv1.LocalObjectReference{{Name: "registry"}}, spec.ImagePullSecrets)
+	assert.Equal(t, "sre", job.Labels["team"])
+	assert.Equal(t, tj.Name, job.Labels[meta.TraceLabelKey])
+	assert.Equal(t, "OPS-1", job.Spec.Template.Annotations["ticket"])
+
+	for _, c := range append(spec.InitContainers, spec.Containers...) {
+		assert.Equal(t, apiv1.PullAlways, c.ImagePullPolicy, c.Name)
+		assert.Equal(t, resource.MustParse("2G"), c.Resources.Limits[apiv1.ResourceMemory], c.Name)
+		assert.Equal(t, resource.MustParse("1"), c.Resources.Limits[apiv1.ResourceCPU], c.Name)
+	}
+
+	c := spec.Containers[0]
+	assert.Equal(t, []apiv1.EnvVar{{Name: "BPFTRACE_STRLEN", Value: "200"}}, c.Env)
+	assert.Contains(t, c.VolumeMounts, apiv1.VolumeMount{Name: "host-mount-0", MountPath: "/app", ReadOnly: true})
+}
@@ -0,0 +1,184 @@
+package tracejob
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// SecurityProfile is how much privilege the trace container runs with.
+type SecurityProfile string
+
+// These are the valid security profiles.
+const (
+	// SecurityProfilePrivileged runs a privileged container with the whole host /sys mounted.
+	SecurityProfilePrivileged SecurityProfile = "privileged"
+	// SecurityProfileCapabilities runs an unprivileged container with only the capabilities bpftrace needs,
+	// a read-only root filesystem, the runtime default seccomp and, where enabled, AppArmor profiles and
+	// the minimal read-only mounts. The pod still uses the host PID namespace and host path volumes.
+	SecurityProfileCapabilities SecurityProfile = "capabilities"
+)
+
+// SecurityProfiles are the security profiles that can be requested.
+var SecurityProfiles = []SecurityProfile{SecurityProfilePrivileged, SecurityProfileCapabilities}
+
+// ParseSecurityProfile validates a security profile given as a string.
+func ParseSecurityProfile(s string) (SecurityProfile, error) {
+	for _, p := range SecurityProfiles {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	modes := make([]string, len(SecurityProfiles))
+	for i, p := range SecurityProfiles {
+		modes[i] = string(p)
+	}
+	return "", fmt.Errorf("invalid security profile %q, must be one of %s", s, strings.Join(modes, ", "))
+}
+
+// bpfCapabilitiesRuntimes are the container runtimes known to grant CAP_BPF and CAP_PERFMON,
+// with the first version that does. Older runtimes do not know them and fail to create the container.
+var bpfCapabilitiesRuntimes = map[string][2]int{
+	"containerd": {1, 5},
+	"docker":     {20, 10},
+}
+
+// TraceCapabilities returns the capabilities a trace with the capabilities profile needs on a node, given its kernel
+// and container runtime versions as the node status reports them, like containerd://1.5.2.
+// CAP_BPF and CAP_PERFMON exist since linux 5.8, before that, or when the runtime is not known to grant them,
+// CAP_SYS_ADMIN is needed instead. The reason tells which of the two applies.
+func TraceCapabilities(kernelVersion, runtimeVersion string) ([]apiv1.Capability, string) {
+	if !KernelAtLeast(kernelVersion, 5, 8) {
+		return []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}, fmt.Sprintf("kernel %s is older than 5.8, CAP_SYS_ADMIN is needed", kernelVersion)
+	}
+	if !runtimeGrantsBPF(runtimeVersion) {
+		runtime := runtimeVersion
+		if len(runtime) == 0 {
+			runtime = "unknown"
+		}
+		return []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}, fmt.Sprintf("container runtime %s is not known to grant CAP_BPF and CAP_PERFMON, CAP_SYS_ADMIN is needed", runtime)
+	}
+	return []apiv1.Capability{"BPF", "PERFMON", "SYS_RESOURCE"}, fmt.Sprintf("kernel %s and container runtime %s support CAP_BPF and CAP_PERFMON", kernelVersion, runtimeVersion)
+}
+
+// runtimeGrantsBPF tells whether a container runtime version, as name://version, grants CAP_BPF and CAP_PERFMON.
+func runtimeGrantsBPF(runtimeVersion string) bool {
+	parts := strings.SplitN(runtimeVersion, "://", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	min, ok := bpfCapabilitiesRuntimes[parts[0]]
+	if !ok {
+		return false
+	}
+	return versionAtLeast(strings.TrimPrefix(parts[1], "v"), min[0], min[1])
+}
+
+// KernelAtLeast tells whether a kernel release like 5.4.0-1029-gke is at least major.minor.
+// Releases that cannot be parsed are considered older.
+func KernelAtLeast(release string, major, minor int) bool {
+	return versionAtLeast(release, major, minor)
+}
+
+// versionAtLeast tells whether a version like 1.4.3 or 5.4.0-1029-gke is at least major.minor.
+// Versions that cannot be parsed are considered older.
+func versionAtLeast(version string, major, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return false
+	}
+	maj, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	min, err := strconv.Atoi(strings.TrimRightFunc(parts[1], func(r rune) bool { return r < '0' || r > '9' }))
+	if err != nil {
+		return false
+	}
+	return maj > major || (maj == major && min >= minor)
+}
+
+// dropPrivileges replaces the privileges of the trace container with the capabilities it needs.
+// The kubelet refuses pods with an AppArmor profile on nodes without AppArmor, so it is set only when appArmor is.
+func dropPrivileges(template *apiv1.PodTemplateSpec, kernelVersion, runtimeVersion string, isPod bool, appArmor bool) {
+	spec := &template.Spec
+	c := &spec.Containers[0]
+
+	caps, _ := TraceCapabilities(kernelVersion, runtimeVersion)
+	// Looking for the container processes and reading their binaries crosses users
+	if isPod {
+		caps = append(caps, "SYS_PTRACE")
+	}
+	sysAdmin := false
+	for _, cap := range caps {
+		sysAdmin = sysAdmin || cap == "SYS_ADMIN"
+	}
+
+	c.SecurityContext = &apiv1.SecurityContext{
+		Privileged: boolPtr(false),
+		Capabilities: &apiv1.Capabilities{
+			Add:  caps,
+			Drop: []apiv1.Capability{"ALL"},
+		},
+		// The API server rejects disabling privilege escalation along with CAP_SYS_ADMIN
+		AllowPrivilegeEscalation: boolPtr(sysAdmin),
+		ReadOnlyRootFilesystem:   boolPtr(true),
+		SeccompProfile: &apiv1.SeccompProfile{
+			Type: apiv1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+
+	// The container sees its own read-only /sys, only the tracing filesystems are needed from the host
+	volumes := []apiv1.Volume{}
+	for _, v := range spec.Volumes {
+		if v.Name != "sys" {
+			volumes = append(volumes, v)
+		}
+	}
+	spec.Volumes = append(volumes,
+		apiv1.Volume{
+			Name: "debugfs",
+			VolumeSource: apiv1.VolumeSource{
+				HostPath: &apiv1.HostPathVolumeSource{
+					Path: "/sys/kernel/debug",
+				},
+			},
+		},
+		apiv1.Volume{
+			Name: "tmp",
+			VolumeSource: apiv1.VolumeSource{
+				EmptyDir: &apiv1.EmptyDirVolumeSource{},
+			},
+		})
+
+	mounts := []apiv1.VolumeMount{}
+	for _, m := range c.VolumeMounts {
+		if m.Name != "sys" {
+			mounts = append(mounts, m)
+		}
+	}
+	c.VolumeMounts = append(mounts,
+		apiv1.VolumeMount{
+			Name:      "debugfs",
+			MountPath: "/sys/kernel/debug",
+			ReadOnly:  true,
+		},
+		apiv1.VolumeMount{
+			Name:      "tmp",
+			MountPath: "/tmp",
+		})
+
+	if !appArmor {
+		return
+	}
+
+	// The annotations are shared with the job, so they are copied before adding the pod only ones
+	annotations := map[string]string{}
+	for k, v := range template.Annotations {
+		annotations[k] = v
+	}
+	annotations[apiv1.AppArmorBetaContainerAnnotationKeyPrefix+c.Name] = apiv1.AppArmorBetaProfileRuntimeDefault
+	template.Annotations = annotations
+}
@@ -0,0 +1,99 @@
+package tracejob
+
+import (
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+func TestParseSecurityProfile(t *testing.T) {
+	p, err := ParseSecurityProfile("capabilities")
+	assert.Nil(t, err)
+	assert.Equal(t, SecurityProfileCapabilities, p)
+
+	_, err = ParseSecurityProfile("restricted")
+	assert.EqualError(t, err, `invalid security profile "restricted", must be one of privileged, capabilities`)
+}
+
+func TestTraceCapabilities(t *testing.T) {
+	tests := []struct {
+		kernelVersion  string
+		runtimeVersion string
+		expected       []apiv1.Capability
+	}{
+		{"5.8.0", "containerd://1.5.2", []apiv1.Capability{"BPF", "PERFMON", "SYS_RESOURCE"}},
+		{"5.10.47-linuxkit", "docker://20.10.7", []apiv1.Capability{"BPF", "PERFMON", "SYS_RESOURCE"}},
+		{"6.1.0+", "containerd://v1.6.4", []apiv1.Capability{"BPF", "PERFMON", "SYS_RESOURCE"}},
+		{"5.10.0", "containerd://1.4.3", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"5.10.0", "docker://19.3.13", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"5.10.0", "cri-o://1.21.0", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"5.10.0", "", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"5.4.0-1029-gke", "containerd://1.5.2", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"4.19.112+", "containerd://1.5.2", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+		{"", "containerd://1.5.2", []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE"}},
+	}
+
+	for _, tt := range tests {
+		caps, _ := TraceCapabilities(tt.kernelVersion, tt.runtimeVersion)
+		assert.Equal(t, tt.expected, caps, tt.kernelVersion+" "+tt.runtimeVersion)
+	}
+
+	_, reason := TraceCapabilities("5.10.0", "")
+	assert.Equal(t, "container runtime unknown is not known to grant CAP_BPF and CAP_PERFMON, CAP_SYS_ADMIN is needed", reason)
+}
+
+func TestCreateJobCapabilities(t *testing.T) {
+	tj := newTestTraceJob()
+	tj.SecurityProfile = SecurityProfileCapabilities
+	tj.KernelVersion = "5.10.0"
+	tj.RuntimeVersion = "containerd://1.5.2"
+	tj.AppArmor = true
+
+	job, err := newTestClient().CreateJob(tj)
+	require.Nil(t, err)
+
+	c := job.Spec.Template.Spec.Containers[0]
+	require.NotNil(t, c.SecurityContext)
+	assert.False(t, *c.SecurityContext.Privileged)
+	assert.False(t, *c.SecurityContext.AllowPrivilegeEscalation)
+	assert.True(t, *c.SecurityContext.ReadOnlyRootFilesystem)
+	assert.Equal(t, []apiv1.Capability{"BPF", "PERFMON", "SYS_RESOURCE"}, c.SecurityContext.Capabilities.Add)
+	assert.Equal(t, apiv1.SeccompProfileTypeRuntimeDefault, c.SecurityContext.SeccompProfile.Type)
+
+	for _, m := range c.VolumeMounts {
+		assert.NotEqual(t, "sys", m.Name)
+		if m.Name != "tmp" {
+			assert.True(t, m.ReadOnly, m.Name)
+		}
+	}
+
+	apparmorKey := apiv1.AppArmorBetaContainerAnnotationKeyPrefix + c.Name
+	assert.Equal(t, "runtime/default", job.Spec.Template.Annotations[apparmorKey])
+	assert.NotContains(t, job.Annotations, apparmorKey)
+	assert.Equal(t, "capabilities", job.Annotations[meta.SecurityProfileAnnotationKey])
+}
+
+func TestCreateJobCapabilitiesOldKernelPod(t *testing.T) {
+	tj := newTestTraceJob()
+	tj.SecurityProfile = SecurityProfileCapabilities
+	tj.KernelVersion = "4.19.112+"
+	tj.IsPod = true
+
+	job, err := newTestClient().CreateJob(tj)
+	require.Nil(t, err)
+
+	c := job.Spec.Template.Spec.Containers[0]
+	assert.Equal(t, []apiv1.Capability{"SYS_ADMIN", "SYS_RESOURCE", "SYS_PTRACE"}, c.SecurityContext.Capabilities.Add)
+	assert.True(t, *c.SecurityContext.AllowPrivilegeEscalation)
+	assert.NotContains(t, job.Spec.Template.Annotations, apiv1.AppArmorBetaContainerAnnotationKeyPrefix+c.Name)
+}
+
+func TestCreateJobPrivileged(t *testing.T) {
+	job, err := newTestClient().CreateJob(newTestTraceJob())
+	require.Nil(t, err)
+
+	assert.True(t, *job.Spec.Template.Spec.Containers[0].SecurityContext.Privileged)
+}
@@ -0,0 +1,123 @@
+package tracespec
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/iovisor/kubectl-trace/pkg/meta"
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+)
+
+// FromJob returns the spec of an existing trace, from its job and the config map holding its program.
+// What is not recorded in them, like the patch the job was created with, is left out.
+func FromJob(job *batchv1.Job, cm *apiv1.ConfigMap) *TraceSpec {
+	s := &TraceSpec{
+		APIVersion: APIVersion,
+		Kind:       Kind,
+		Metadata: Metadata{
+			Namespace: job.Namespace,
+		},
+	}
+	spec := &s.Spec
+
+	target := job.Annotations[meta.TargetAnnotationKey]
+	switch {
+	case strings.HasPrefix(target, "pod/"):
+		spec.Target.Pod = strings.TrimPrefix(target, "pod/")
+		spec.Target.Namespace = job.Annotations[meta.TargetNamespaceAnnotationKey]
+	case strings.HasPrefix(target, "node/"):
+		spec.Target.Node = strings.TrimPrefix(target, "node/")
+	default:
+		// Traces created before the target was recorded only tell their node
+		spec.Target.Node, _ = tracejob.JobHostname(*job)
+	}
+
+	if cm != nil {
+		spec.Program = cm.Data[tracejob.ProgramKey]
+		if d, err := strconv.ParseInt(cm.Data[tracejob.DeadlineKey], 10, 64); err == nil {
+			deadline := Seconds(d)
+			spec.Deadline = &deadline
+		}
+	}
+
+	tmpl := &job.Spec.Template
+	spec.ServiceAccount = tmpl.Spec.ServiceAccountName
+	spec.Headers = job.Annotations[meta.HeaderModeAnnotationKey]
+	spec.SecurityProfile = job.Annotations[meta.SecurityProfileAnnotationKey]
+	// The init container fetching the headers is the last one
+	if n := len(tmpl.Spec.InitContainers); n > 0 {
+		spec.InitImage = tmpl.Spec.InitContainers[n-1].Image
+	}
+	if hs := tracejob.HeadersSourceFromPodSpec(&tmpl.Spec); hs != (tracejob.HeadersSource{}) {
+		spec.HeadersSource = &HeadersSource{
+			MirrorURL: hs.MirrorURL,
+			Image:     hs.Image,
+			PVC:       hs.PVC,
+			HostPath:  hs.HostPath,
+		}
+	}
+
+	if len(tmpl.Spec.Containers) > 0 {
+		c := tmpl.Spec.Containers[0]
+		spec.Image = c.Image
+		fromCommand(spec, c.Command)
+		if spec.Deadline != nil && job.Spec.ActiveDeadlineSeconds != nil {
+			grace := Seconds(*job.Spec.ActiveDeadlineSeconds) - *spec.Deadline
+			spec.DeadlineGracePeriod = &grace
+		}
+	}
+
+	po := tracejob.PodOptionsFromJob(job)
+	s.Metadata.Labels = po.Labels
+	s.Metadata.Annotations = po.Annotations
+	spec.Resources.Requests = resourceMap(po.Requests)
+	spec.Resources.Limits = resourceMap(po.Limits)
+	spec.PriorityClassName = po.PriorityClassName
+	spec.Tolerations = po.Tolerations
+	spec.ImagePullSecrets = po.ImagePullSecrets
+	spec.ImagePullPolicy = string(po.ImagePullPolicy)
+	for _, e := range po.Env {
+		spec.Env = append(spec.Env, EnvVar{Name: e.Name, Value: e.Value})
+	}
+	for _, m := range po.HostMounts {
+		spec.HostMounts = append(spec.HostMounts, HostMount{HostPath: m.HostPath, MountPath: m.MountPath})
+	}
+	return s
+}
+
+// fromCommand reads the trace-runner flags from the command of the trace container,
+// and the deadline of the traces created when it was given to /bin/timeout.
+func fromCommand(spec *Spec, command []string) {
+	validate := false
+	for i, arg := range command {
+		switch {
+		case arg == "/bin/trace-runner" && i > 0 && spec.Deadline == nil:
+			if d, err := strconv.ParseInt(command[i-1], 10, 64); err == nil {
+				deadline := Seconds(d)
+				spec.Deadline = &deadline
+			}
+		case strings.HasPrefix(arg, "--container="):
+			spec.Target.Container = strings.TrimPrefix(arg, "--container=")
+		case strings.HasPrefix(arg, "--arg="):
+			spec.Args = append(spec.Args, strings.TrimPrefix(arg, "--arg="))
+		case arg == "--validate":
+			validate = true
+		case arg == "--follow-restarts":
+			spec.FollowRestarts = true
+		}
+	}
+	spec.Validate = &validate
+}
+
+func resourceMap(rl apiv1.ResourceList) map[string]string {
+	if len(rl) == 0 {
+		return nil
+	}
+	m := map[string]string{}
+	for name, q := range rl {
+		m[string(name)] = q.String()
+	}
+	return m
+}
@@ -0,0 +1,374 @@
+package tracespec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+// These are the version and kind of the trace spec files.
+const (
+	APIVersion = "kubectl-trace.iovisor.org/v1alpha1"
+	Kind       = "TraceSpec"
+)
+
+// TraceSpec describes a trace in a file, so that it can be kept along with runbooks.
+type TraceSpec struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Metadata   Metadata `json:"metadata,omitempty"`
+	Spec       Spec     `json:"spec"`
+}
+
+// Metadata is where the trace is created and how it is labeled.
+type Metadata struct {
+	// Namespace is the namespace to create the trace in, the one of the target by default.
+	Namespace   string            `json:"namespace,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Seconds is a number of seconds, written as a duration like 90s, 15m or 2h, or as a plain number of seconds.
+type Seconds int64
+
+// MarshalJSON writes the seconds as a duration.
+func (s Seconds) MarshalJSON() ([]byte, error) {
+	return json.Marshal((time.Duration(s) * time.Second).String())
+}
+
+// UnmarshalJSON reads the seconds from a number or from a duration.
+func (s *Seconds) UnmarshalJSON(b []byte) error {
+	var n int64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Seconds(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("must be a number of seconds or a duration like 90s, 15m or 2h")
+	}
+	d, err := tracejob.ParseDuration(str)
+	if err != nil {
+		return err
+	}
+	*s = Seconds(d / time.Second)
+	return nil
+}
+
+// Spec is what the trace runs and how.
+type Spec struct {
+	Target Target `json:"target"`
+	// Program is the bpftrace program, or ProgramFile the path of the file it is in,
+	// relative to the trace spec file.
+	Program     string `json:"program,omitempty"`
+	ProgramFile string `json:"programFile,omitempty"`
+	// Args are the positional parameters of the program, $1 being the first one.
+	Args []string `json:"args,omitempty"`
+
+	// Deadline and DeadlineGracePeriod are numbers of seconds or durations like 90s, 15m or 2h.
+	Deadline            *Seconds `json:"deadline,omitempty"`
+	DeadlineGracePeriod *Seconds `json:"deadlineGracePeriod,omitempty"`
+
+	ServiceAccount  string         `json:"serviceAccount,omitempty"`
+	Image           string         `json:"image,omitempty"`
+	InitImage       string         `json:"initImage,omitempty"`
+	Headers         string         `json:"headers,omitempty"`
+	HeadersSource   *HeadersSource `json:"headersSource,omitempty"`
+	SecurityProfile string         `json:"securityProfile,omitempty"`
+	Validate        *bool          `json:"validate,omitempty"`
+	FollowRestarts  bool           `json:"followRestarts,omitempty"`
+	SkipLint        bool           `json:"skipLint,omitempty"`
+
+	Resources         Resources          `json:"resources,omitempty"`
+	PriorityClassName string             `json:"priorityClassName,omitempty"`
+	Tolerations       []apiv1.Toleration `json:"tolerations,omitempty"`
+	ImagePullSecrets  []string           `json:"imagePullSecrets,omitempty"`
+	ImagePullPolicy   string             `json:"imagePullPolicy,omitempty"`
+	Env               []EnvVar           `json:"env,omitempty"`
+	HostMounts        []HostMount        `json:"hostMounts,omitempty"`
+	Patch             *Patch             `json:"patch,omitempty"`
+
+	Output Output `json:"output,omitempty"`
+}
+
+// Target is the node, or the pod, the trace runs against.
+type Target struct {
+	Node      string `json:"node,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	// Namespace is the namespace of the pod, the current one by default.
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// HeadersSource is where fetched headers come from instead of the internet.
+type HeadersSource struct {
+	MirrorURL string `json:"mirrorURL,omitempty"`
+	Image     string `json:"image,omitempty"`
+	PVC       string `json:"pvc,omitempty"`
+	HostPath  string `json:"hostPath,omitempty"`
+}
+
+// Resources override the resources of the trace containers.
+type Resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// EnvVar is an environment variable of the trace container.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HostMount is a directory of the node mounted read-only in the trace container.
+type HostMount struct {
+	HostPath  string `json:"hostPath"`
+	MountPath string `json:"mountPath,omitempty"`
+}
+
+// Patch is a patch of the trace job, in a file relative to the trace spec file.
+type Patch struct {
+	Type string `json:"type"`
+	File string `json:"file"`
+}
+
+// Output is what to do once the trace is created.
+type Output struct {
+	Attach bool `json:"attach,omitempty"`
+	Wait   bool `json:"wait,omitempty"`
+}
+
+// Load reads the trace specs of a file, that can hold several yaml documents.
+// The paths in the specs are made relative to the directory of the file.
+func Load(path string) ([]*TraceSpec, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := Decode(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, s := range specs {
+		if len(s.Spec.ProgramFile) > 0 && !filepath.IsAbs(s.Spec.ProgramFile) {
+			s.Spec.ProgramFile = filepath.Join(dir, s.Spec.ProgramFile)
+		}
+		if s.Spec.Patch != nil && len(s.Spec.Patch.File) > 0 && !filepath.IsAbs(s.Spec.Patch.File) {
+			s.Spec.Patch.File = filepath.Join(dir, s.Spec.Patch.File)
+		}
+	}
+	return specs, nil
+}
+
+// Decode decodes the trace specs of a yaml stream, refusing unknown fields and invalid specs.
+func Decode(b []byte) ([]*TraceSpec, error) {
+	specs := []*TraceSpec{}
+	r := yamlutil.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for i := 0; ; i++ {
+		doc, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Documents with only separators or comments are skipped
+		var fields map[string]interface{}
+		if err := yaml.Unmarshal(doc, &fields); err != nil {
+			return nil, fmt.Errorf("document %d: %s", i, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		s := &TraceSpec{}
+		if err := yaml.UnmarshalStrict(doc, s); err != nil {
+			return nil, fmt.Errorf("document %d: %s", i, err)
+		}
+		if errs := s.Validate(); len(errs) > 0 {
+			return nil, fmt.Errorf("document %d: %s", i, errs.ToAggregate())
+		}
+		specs = append(specs, s)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no trace spec found")
+	}
+	return specs, nil
+}
+
+// Validate returns the errors of the spec, with the path of the fields they are about.
+func (s *TraceSpec) Validate() field.ErrorList {
+	errs := field.ErrorList{}
+	if s.APIVersion != APIVersion {
+		errs = append(errs, field.NotSupported(field.NewPath("apiVersion"), s.APIVersion, []string{APIVersion}))
+	}
+	if s.Kind != Kind {
+		errs = append(errs, field.NotSupported(field.NewPath("kind"), s.Kind, []string{Kind}))
+	}
+
+	spec := &s.Spec
+	p := field.NewPath("spec")
+
+	t := p.Child("target")
+	switch {
+	case len(spec.Target.Node) == 0 && len(spec.Target.Pod) == 0:
+		errs = append(errs, field.Required(t, "either node or pod is required"))
+	case len(spec.Target.Node) > 0 && len(spec.Target.Pod) > 0:
+		errs = append(errs, field.Invalid(t.Child("pod"), spec.Target.Pod, "node and pod cannot both be given"))
+	case len(spec.Target.Node) > 0 && len(spec.Target.Container) > 0:
+		errs = append(errs, field.Invalid(t.Child("container"), spec.Target.Container, "only pods have containers"))
+	}
+	if spec.FollowRestarts && len(spec.Target.Pod) == 0 {
+		errs = append(errs, field.Invalid(p.Child("followRestarts"), spec.FollowRestarts, "only pods can be followed across restarts"))
+	}
+
+	switch {
+	case len(spec.Program) == 0 && len(spec.ProgramFile) == 0:
+		errs = append(errs, field.Required(p.Child("program"), "either program or programFile is required"))
+	case len(spec.Program) > 0 && len(spec.ProgramFile) > 0:
+		errs = append(errs, field.Invalid(p.Child("programFile"), spec.ProgramFile, "program and programFile cannot both be given"))
+	}
+
+	if spec.Deadline != nil && *spec.Deadline <= 0 {
+		errs = append(errs, field.Invalid(p.Child("deadline"), int64(*spec.Deadline), "must be positive"))
+	}
+	if spec.DeadlineGracePeriod != nil && *spec.DeadlineGracePeriod < 0 {
+		errs = append(errs, field.Invalid(p.Child("deadlineGracePeriod"), int64(*spec.DeadlineGracePeriod), "must not be negative"))
+	}
+
+	headers := tracejob.HeaderModeAuto
+	if len(spec.Headers) > 0 {
+		mode, err := tracejob.ParseHeaderMode(spec.Headers)
+		if err != nil {
+			errs = append(errs, field.Invalid(p.Child("headers"), spec.Headers, err.Error()))
+		} else {
+			headers = mode
+		}
+	}
+	if err := s.HeadersSource().ValidateFor(headers); err != nil {
+		errs = append(errs, field.Invalid(p.Child("headersSource"), spec.HeadersSource, err.Error()))
+	}
+	if len(spec.SecurityProfile) > 0 {
+		if _, err := tracejob.ParseSecurityProfile(spec.SecurityProfile); err != nil {
+			errs = append(errs, field.Invalid(p.Child("securityProfile"), spec.SecurityProfile, err.Error()))
+		}
+	}
+
+	errs = append(errs, validateResources(p.Child("resources", "requests"), spec.Resources.Requests)...)
+	errs = append(errs, validateResources(p.Child("resources", "limits"), spec.Resources.Limits)...)
+	for i, tol := range spec.Tolerations {
+		switch tol.Operator {
+		case "", apiv1.TolerationOpExists, apiv1.TolerationOpEqual:
+		default:
+			errs = append(errs, field.NotSupported(p.Child("tolerations").Index(i).Child("operator"), tol.Operator, []string{string(apiv1.TolerationOpExists), string(apiv1.TolerationOpEqual)}))
+		}
+	}
+	for i, e := range spec.Env {
+		if len(e.Name) == 0 {
+			errs = append(errs, field.Required(p.Child("env").Index(i).Child("name"), ""))
+		}
+	}
+	for i, m := range spec.HostMounts {
+		if !filepath.IsAbs(m.HostPath) {
+			errs = append(errs, field.Invalid(p.Child("hostMounts").Index(i).Child("hostPath"), m.HostPath, "must be absolute"))
+		}
+		if len(m.MountPath) > 0 && !filepath.IsAbs(m.MountPath) {
+			errs = append(errs, field.Invalid(p.Child("hostMounts").Index(i).Child("mountPath"), m.MountPath, "must be absolute"))
+		}
+	}
+	if err := s.PodOptions().Validate(); err != nil {
+		errs = append(errs, field.Invalid(p, "", err.Error()))
+	}
+
+	if spec.Patch != nil {
+		switch spec.Patch.Type {
+		case "json", "merge", "strategic":
+		default:
+			errs = append(errs, field.NotSupported(p.Child("patch", "type"), spec.Patch.Type, []string{"json", "merge", "strategic"}))
+		}
+		if len(spec.Patch.File) == 0 {
+			errs = append(errs, field.Required(p.Child("patch", "file"), ""))
+		}
+	}
+
+	if spec.Output.Attach && spec.Output.Wait {
+		errs = append(errs, field.Invalid(p.Child("output"), spec.Output, "attach and wait cannot both be set"))
+	}
+	return errs
+}
+
+func validateResources(p *field.Path, rl map[string]string) field.ErrorList {
+	errs := field.ErrorList{}
+	for name, q := range rl {
+		if _, err := tracejob.ParseResourceList(fmt.Sprintf("%s=%s", name, q)); err != nil {
+			errs = append(errs, field.Invalid(p.Key(name), q, err.Error()))
+		}
+	}
+	return errs
+}
+
+// HeadersSource returns the source of the fetched headers.
+func (s *TraceSpec) HeadersSource() tracejob.HeadersSource {
+	if s.Spec.HeadersSource == nil {
+		return tracejob.HeadersSource{}
+	}
+	return tracejob.HeadersSource{
+		MirrorURL: s.Spec.HeadersSource.MirrorURL,
+		Image:     s.Spec.HeadersSource.Image,
+		PVC:       s.Spec.HeadersSource.PVC,
+		HostPath:  s.Spec.HeadersSource.HostPath,
+	}
+}
+
+// PodOptions returns the customizations of the trace pod. Invalid resources are left out, Validate reports them.
+func (s *TraceSpec) PodOptions() tracejob.PodOptions {
+	spec := &s.Spec
+	po := tracejob.PodOptions{
+		Requests:          resourceList(spec.Resources.Requests),
+		Limits:            resourceList(spec.Resources.Limits),
+		PriorityClassName: spec.PriorityClassName,
+		Tolerations:       spec.Tolerations,
+		ImagePullSecrets:  spec.ImagePullSecrets,
+		ImagePullPolicy:   apiv1.PullPolicy(spec.ImagePullPolicy),
+		Labels:            s.Metadata.Labels,
+		Annotations:       s.Metadata.Annotations,
+	}
+	for _, e := range spec.Env {
+		po.Env = append(po.Env, apiv1.EnvVar{Name: e.Name, Value: e.Value})
+	}
+	for _, m := range spec.HostMounts {
+		mountPath := m.MountPath
+		if len(mountPath) == 0 {
+			mountPath = m.HostPath
+		}
+		po.HostMounts = append(po.HostMounts, tracejob.HostMount{HostPath: m.HostPath, MountPath: mountPath})
+	}
+	return po
+}
+
+func resourceList(rl map[string]string) apiv1.ResourceList {
+	if len(rl) == 0 {
+		return nil
+	}
+	l := apiv1.ResourceList{}
+	for name, q := range rl {
+		if v, err := resource.ParseQuantity(q); err == nil {
+			l[apiv1.ResourceName(name)] = v
+		}
+	}
+	return l
+}
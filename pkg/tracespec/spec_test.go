@@ -0,0 +1,177 @@
+package tracespec
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/iovisor/kubectl-trace/pkg/tracejob"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testSpec = `
+apiVersion: kubectl-trace.iovisor.org/v1alpha1
+kind: TraceSpec
+metadata:
+  namespace: kubectl-trace-system
+  labels:
+    team: sre
+spec:
+  target:
+    pod: checkout
+    container: app
+    namespace: payments
+  programFile: read.bt
+  args: ["10"]
+  deadline: 300
+  resources:
+    limits:
+      memory: 2G
+  hostMounts:
+  - hostPath: /opt/app
+  patch:
+    type: merge
+    file: patch.yaml
+  output:
+    wait: true
+`
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kubectl-trace-spec")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace.yaml")
+	require.Nil(t, ioutil.WriteFile(path, []byte(testSpec+"---\n"+strings.Replace(testSpec, "deadline: 300", "deadline: 5m", 1)), 0644))
+
+	specs, err := Load(path)
+	require.Nil(t, err)
+	require.Len(t, specs, 2)
+
+	s := specs[0]
+	assert.Equal(t, "checkout", s.Spec.Target.Pod)
+	assert.Equal(t, filepath.Join(dir, "read.bt"), s.Spec.ProgramFile)
+	assert.Equal(t, filepath.Join(dir, "patch.yaml"), s.Spec.Patch.File)
+	assert.Equal(t, Seconds(300), *s.Spec.Deadline)
+	assert.Equal(t, Seconds(300), *specs[1].Spec.Deadline)
+
+	po := s.PodOptions()
+	assert.Equal(t, resource.MustParse("2G"), po.Limits[apiv1.ResourceMemory])
+	assert.Equal(t, []tracejob.HostMount{{HostPath: "/opt/app", MountPath: "/opt/app"}}, po.HostMounts)
+	assert.Equal(t, map[string]string{"team": "sre"}, po.Labels)
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		expected string
+	}{
+		{
+			name:     "unknown field",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n  deadlne: 10\n",
+			expected: `document 0: error unmarshaling JSON: while decoding JSON: json: unknown field "deadlne"`,
+		},
+		{
+			name:     "deadline",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n  deadline: soon\n",
+			expected: `document 0: error unmarshaling JSON: while decoding JSON: invalid duration "soon", must be a number of seconds or a duration like 90s, 15m or 2h`,
+		},
+		{
+			name:     "follow restarts",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n  followRestarts: true\n",
+			expected: "document 0: spec.followRestarts: Invalid value: true: only pods can be followed across restarts",
+		},
+		{
+			name:     "version",
+			spec:     "apiVersion: v1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n",
+			expected: `document 0: apiVersion: Unsupported value: "v1": supported values: "kubectl-trace.iovisor.org/v1alpha1"`,
+		},
+		{
+			name:     "target",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  program: 'BEGIN { exit(); }'\n",
+			expected: "document 0: spec.target: Required value: either node or pod is required",
+		},
+		{
+			name:     "resources",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n  resources:\n    limits:\n      memory: lots\n",
+			expected: `document 0: spec.resources.limits[memory]: Invalid value: "lots": invalid quantity "lots" for memory: quantities must match the regular expression '^([+-]?[0-9.]+)([eEinumkKMGTP]*[-+]?[0-9]*)$'`,
+		},
+		{
+			name:     "host mount",
+			spec:     "apiVersion: kubectl-trace.iovisor.org/v1alpha1\nkind: TraceSpec\nspec:\n  target:\n    node: node-1\n  program: 'BEGIN { exit(); }'\n  hostMounts:\n  - hostPath: opt/app\n",
+			expected: `document 0: spec.hostMounts[0].hostPath: Invalid value: "opt/app": must be absolute`,
+		},
+		{
+			name:     "empty",
+			spec:     "---\n",
+			expected: "no trace spec found",
+		},
+	}
+	for _, tt := range tests {
+		_, err := Decode([]byte(tt.spec))
+		assert.EqualError(t, err, tt.expected, tt.name)
+	}
+}
+
+func TestFromJob(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	tc := &tracejob.TraceJobClient{
+		JobClient:    cs.BatchV1().Jobs("kubectl-trace-system"),
+		ConfigClient: cs.CoreV1().ConfigMaps("kubectl-trace-system"),
+	}
+	tc.WithOutStream(ioutil.Discard)
+
+	job, err := tc.CreateJob(tracejob.TraceJob{
+		Name:                "kubectl-trace-1bb3ae39-efe8-11e8-9f29-8c164500a77e",
+		ID:                  "1bb3ae39-efe8-11e8-9f29-8c164500a77e",
+		Namespace:           "kubectl-trace-system",
+		TargetNamespace:     "payments",
+		ServiceAccount:      "kubectl-trace",
+		Hostname:            "node-1",
+		Target:              "pod/checkout",
+		Program:             "BEGIN { printf(\"%s\\n\", str($1)); exit(); }",
+		Args:                []string{"10"},
+		IsPod:               true,
+		ContainerName:       "app",
+		PodUID:              "uid",
+		ImageNameTag:        "quay.io/iovisor/kubectl-trace-bpftrace:latest",
+		Validate:            true,
+		FollowRestarts:      true,
+		Deadline:            300,
+		DeadlineGracePeriod: 30,
+		PodOptions: tracejob.PodOptions{
+			Labels:     map[string]string{"team": "sre"},
+			Env:        []apiv1.EnvVar{{Name: "BPFTRACE_STRLEN", Value: "200"}},
+			HostMounts: []tracejob.HostMount{{HostPath: "/opt/app", MountPath: "/app"}},
+		},
+	})
+	require.Nil(t, err)
+	cm, err := tc.ConfigClient.Get(context.Background(), job.Name, metav1.GetOptions{})
+	require.Nil(t, err)
+
+	s := FromJob(job, cm)
+	assert.Empty(t, s.Validate())
+	assert.Equal(t, "kubectl-trace-system", s.Metadata.Namespace)
+	assert.Equal(t, map[string]string{"team": "sre"}, s.Metadata.Labels)
+	assert.Equal(t, Target{Pod: "checkout", Container: "app", Namespace: "payments"}, s.Spec.Target)
+	assert.Equal(t, "BEGIN { printf(\"%s\\n\", str($1)); exit(); }", s.Spec.Program)
+	assert.Equal(t, []string{"10"}, s.Spec.Args)
+	assert.Equal(t, Seconds(300), *s.Spec.Deadline)
+	assert.Equal(t, Seconds(30), *s.Spec.DeadlineGracePeriod)
+	assert.True(t, *s.Spec.Validate)
+	assert.True(t, s.Spec.FollowRestarts)
+	assert.Equal(t, "kubectl-trace", s.Spec.ServiceAccount)
+	assert.Equal(t, "1G", s.Spec.Resources.Limits["memory"])
+	assert.Empty(t, s.Spec.Tolerations)
+	assert.Equal(t, []EnvVar{{Name: "BPFTRACE_STRLEN", Value: "200"}}, s.Spec.Env)
+	assert.Equal(t, []HostMount{{HostPath: "/opt/app", MountPath: "/app"}}, s.Spec.HostMounts)
+}
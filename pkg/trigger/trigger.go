@@ -0,0 +1,295 @@
+package trigger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// Condition is something happening to a pod that starts a trace.
+type Condition string
+
+// These are the conditions a trace can be triggered on.
+const (
+	// ConditionRestart fires when a container of the pod restarts.
+	ConditionRestart Condition = "restart"
+	// ConditionNotReady fires when the pod goes from ready to not ready.
+	ConditionNotReady Condition = "not-ready"
+	// ConditionOOMKilled fires when a container of the pod is killed for running out of memory.
+	ConditionOOMKilled Condition = "oom-killed"
+	// EventConditionPrefix prefixes the conditions firing on the events of the pod with a given reason, like event:BackOff.
+	EventConditionPrefix = "event:"
+)
+
+// ParseConditions parses the conditions given as restart, not-ready, oom-killed or event:REASON.
+func ParseConditions(values []string) ([]Condition, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("at least one condition is required")
+	}
+	conditions := []Condition{}
+	for _, v := range values {
+		c := Condition(v)
+		switch {
+		case c == ConditionRestart, c == ConditionNotReady, c == ConditionOOMKilled:
+		case strings.HasPrefix(v, EventConditionPrefix) && len(v) > len(EventConditionPrefix):
+		default:
+			return nil, fmt.Errorf("invalid condition %q, must be one of %s, %s, %s or %sREASON", v, ConditionRestart, ConditionNotReady, ConditionOOMKilled, EventConditionPrefix)
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// Firing is a condition met by a pod.
+type Firing struct {
+	Condition Condition
+	Pod       *v1.Pod
+	Message   string
+}
+
+// String describes the firing for humans.
+func (f Firing) String() string {
+	return fmt.Sprintf("pod %s/%s: %s, %s", f.Pod.Namespace, f.Pod.Name, f.Condition, f.Message)
+}
+
+// podState is what a pod was last seen like.
+type podState struct {
+	pod      *v1.Pod
+	ready    bool
+	restarts map[string]int32
+	oomKills map[string]bool
+}
+
+// Detector finds the conditions met by the pods between the times it looks at them.
+// It can be given the pods and the events from different goroutines.
+type Detector struct {
+	conditions map[Condition]bool
+	reasons    map[string]bool
+	// container restricts the restarts and the OOM kills to one container, all are looked at when empty.
+	container string
+	since     time.Time
+
+	mu     sync.Mutex
+	pods   map[types.UID]podState
+	events map[types.UID]int32
+}
+
+// NewDetector returns a detector of the conditions, about the container when not empty.
+// The events older than since are ignored.
+func NewDetector(conditions []Condition, container string, since time.Time) *Detector {
+	d := &Detector{
+		conditions: map[Condition]bool{},
+		reasons:    map[string]bool{},
+		container:  container,
+		since:      since,
+		pods:       map[types.UID]podState{},
+		events:     map[types.UID]int32{},
+	}
+	for _, c := range conditions {
+		if strings.HasPrefix(string(c), EventConditionPrefix) {
+			d.reasons[strings.TrimPrefix(string(c), EventConditionPrefix)] = true
+			continue
+		}
+		d.conditions[c] = true
+	}
+	return d
+}
+
+// WatchesEvents tells whether some conditions fire on events.
+func (d *Detector) WatchesEvents() bool {
+	return len(d.reasons) > 0
+}
+
+// Pod compares the pod with how it was last seen, and returns the conditions it met since.
+// A pod seen for the first time is only recorded.
+func (d *Detector) Pod(pod *v1.Pod) []Firing {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	firings := []Firing{}
+	state := d.podState(pod)
+	prev, ok := d.pods[pod.UID]
+	d.pods[pod.UID] = state
+
+	// A pod being deleted goes not ready and its containers stop, that is no condition to trace
+	if !ok || pod.DeletionTimestamp != nil {
+		return firings
+	}
+
+	if d.conditions[ConditionNotReady] && prev.ready && !state.ready {
+		firings = append(firings, Firing{Condition: ConditionNotReady, Pod: pod, Message: "pod is not ready"})
+	}
+	// The containers are looked at in the order of the pod
+	for _, cs := range pod.Status.ContainerStatuses {
+		name := cs.Name
+		restarts, ok := state.restarts[name]
+		if !ok {
+			continue
+		}
+		restarted := restarts > prev.restarts[name]
+		if d.conditions[ConditionRestart] && restarted {
+			firings = append(firings, Firing{Condition: ConditionRestart, Pod: pod, Message: fmt.Sprintf("container %s restarted, %d restarts", name, restarts)})
+		}
+		if d.conditions[ConditionOOMKilled] && state.oomKills[name] && (!prev.oomKills[name] || restarted) {
+			firings = append(firings, Firing{Condition: ConditionOOMKilled, Pod: pod, Message: fmt.Sprintf("container %s was OOM killed", name)})
+		}
+	}
+	return firings
+}
+
+// Forget forgets a pod that is gone.
+func (d *Detector) Forget(pod types.UID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.pods, pod)
+}
+
+// Reasons returns the reasons of the events some conditions fire on.
+func (d *Detector) Reasons() []string {
+	reasons := []string{}
+	for r := range d.reasons {
+		reasons = append(reasons, r)
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+func (d *Detector) podState(pod *v1.Pod) podState {
+	s := podState{
+		pod:      pod,
+		restarts: map[string]int32{},
+		oomKills: map[string]bool{},
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == v1.PodReady {
+			s.ready = c.Status == v1.ConditionTrue
+		}
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if len(d.container) > 0 && cs.Name != d.container {
+			continue
+		}
+		s.restarts[cs.Name] = cs.RestartCount
+		// The last termination is the OOM kill the container restarted from,
+		// the current one is the OOM kill of a container that is not restarted
+		if t := cs.State.Terminated; t != nil && t.Reason == "OOMKilled" {
+			s.oomKills[cs.Name] = true
+		}
+		if t := cs.LastTerminationState.Terminated; t != nil && t.Reason == "OOMKilled" {
+			s.oomKills[cs.Name] = true
+		}
+	}
+	return s
+}
+
+// Events returns the conditions met by the pods already seen, from the events that happened since they were last looked at.
+// The events are all those with the reasons of the conditions, the ones missing are forgotten.
+func (d *Detector) Events(events []v1.Event) []Firing {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	firings := []Firing{}
+	seen := map[types.UID]int32{}
+	for _, ev := range events {
+		if ev.InvolvedObject.Kind != "Pod" || !d.reasons[ev.Reason] {
+			continue
+		}
+		count := ev.Count
+		if count == 0 {
+			count = 1
+		}
+		prev, ok := d.events[ev.UID]
+		seen[ev.UID] = count
+		if (ok && count <= prev) || eventTime(ev).Before(d.since) {
+			continue
+		}
+		state, ok := d.pods[ev.InvolvedObject.UID]
+		if !ok {
+			continue
+		}
+		firings = append(firings, Firing{
+			Condition: Condition(EventConditionPrefix + ev.Reason),
+			Pod:       state.pod,
+			Message:   ev.Message,
+		})
+	}
+	// Forget the events that expired
+	d.events = seen
+	return firings
+}
+
+// eventTime returns when the event last happened.
+func eventTime(ev v1.Event) time.Time {
+	switch {
+	case ev.Series != nil:
+		return ev.Series.LastObservedTime.Time
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	default:
+		return ev.EventTime.Time
+	}
+}
+
+// Limiter caps the traces triggered: one per pod per cooldown, and no more than a maximum running at once.
+// A trace counts as running for its duration, from the time it was created. The traces being created
+// count as running too, but they only start the cooldown of their pod once created.
+type Limiter struct {
+	Cooldown      time.Duration
+	MaxConcurrent int
+	TraceDuration time.Duration
+
+	mu       sync.Mutex
+	last     map[types.UID]time.Time
+	ends     []time.Time
+	creating map[types.UID]bool
+}
+
+// Allow tells whether a trace of the pod can be started now, and why not when it cannot.
+// An allowed trace is being created until Done is called for the pod.
+func (l *Limiter) Allow(pod types.UID, now time.Time) (bool, string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.last == nil {
+		l.last = map[types.UID]time.Time{}
+		l.creating = map[types.UID]bool{}
+	}
+
+	if l.creating[pod] {
+		return false, "a trace of the pod is being created"
+	}
+	if last, ok := l.last[pod]; ok && now.Sub(last) < l.Cooldown {
+		return false, fmt.Sprintf("the pod was traced %s ago, less than the cooldown of %s", now.Sub(last).Truncate(time.Second), l.Cooldown)
+	}
+
+	running := []time.Time{}
+	for _, end := range l.ends {
+		if end.After(now) {
+			running = append(running, end)
+		}
+	}
+	l.ends = running
+	if l.MaxConcurrent > 0 && len(l.ends)+len(l.creating) >= l.MaxConcurrent {
+		return false, fmt.Sprintf("%d traces are already running", len(l.ends)+len(l.creating))
+	}
+
+	l.creating[pod] = true
+	return true, ""
+}
+
+// Done tells the trace of the pod allowed last is no longer being created, and whether it was.
+// Only a created trace counts as running, and starts the cooldown of the pod.
+func (l *Limiter) Done(pod types.UID, now time.Time, created bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.creating, pod)
+	if !created {
+		return
+	}
+	l.last[pod] = now
+	l.ends = append(l.ends, now.Add(l.TraceDuration))
+}
@@ -0,0 +1,169 @@
+package trigger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testPod(ready bool, restarts int32, lastReason string) v1.Pod {
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	cs := v1.ContainerStatus{Name: "app", RestartCount: restarts}
+	if len(lastReason) > 0 {
+		cs.LastTerminationState.Terminated = &v1.ContainerStateTerminated{Reason: lastReason}
+	}
+	return v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-1", Namespace: "default", UID: "pod-uid"},
+		Status: v1.PodStatus{
+			Conditions:        []v1.PodCondition{{Type: v1.PodReady, Status: status}},
+			ContainerStatuses: []v1.ContainerStatus{cs, {Name: "sidecar"}},
+		},
+	}
+}
+
+func pod(p v1.Pod) *v1.Pod {
+	return &p
+}
+
+func TestParseConditions(t *testing.T) {
+	c, err := ParseConditions([]string{"restart", "not-ready", "oom-killed", "event:BackOff"})
+	require.Nil(t, err)
+	assert.Equal(t, []Condition{ConditionRestart, ConditionNotReady, ConditionOOMKilled, "event:BackOff"}, c)
+
+	_, err = ParseConditions([]string{"crash"})
+	assert.EqualError(t, err, `invalid condition "crash", must be one of restart, not-ready, oom-killed or event:REASON`)
+	_, err = ParseConditions([]string{"event:"})
+	assert.NotNil(t, err)
+	_, err = ParseConditions(nil)
+	assert.NotNil(t, err)
+}
+
+func TestDetectorPods(t *testing.T) {
+	d := NewDetector([]Condition{ConditionRestart, ConditionNotReady, ConditionOOMKilled}, "", time.Now())
+
+	// The first sighting only records the pod, even when it restarted already
+	assert.Empty(t, d.Pod(pod(testPod(true, 3, "Error"))))
+	assert.Empty(t, d.Pod(pod(testPod(true, 3, "Error"))))
+
+	firings := d.Pod(pod(testPod(false, 3, "Error")))
+	require.Len(t, firings, 1)
+	assert.Equal(t, ConditionNotReady, firings[0].Condition)
+	assert.Equal(t, "pod default/api-1: not-ready, pod is not ready", firings[0].String())
+
+	firings = d.Pod(pod(testPod(false, 4, "OOMKilled")))
+	require.Len(t, firings, 2)
+	assert.Equal(t, ConditionRestart, firings[0].Condition)
+	assert.Equal(t, "container app restarted, 4 restarts", firings[0].Message)
+	assert.Equal(t, ConditionOOMKilled, firings[1].Condition)
+
+	// Killed again, the last termination stays the same but the restarts go up
+	firings = d.Pod(pod(testPod(true, 5, "OOMKilled")))
+	require.Len(t, firings, 2)
+	assert.Equal(t, ConditionOOMKilled, firings[1].Condition)
+
+	// A pod being deleted is passed over
+	p := testPod(false, 5, "OOMKilled")
+	p.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	assert.Empty(t, d.Pod(&p))
+
+	// A pod forgotten is recorded again
+	d.Forget("pod-uid")
+	assert.Empty(t, d.Pod(pod(testPod(false, 6, "OOMKilled"))))
+}
+
+func TestDetectorContainer(t *testing.T) {
+	d := NewDetector([]Condition{ConditionRestart}, "sidecar", time.Now())
+	d.Pod(pod(testPod(true, 0, "")))
+	assert.Empty(t, d.Pod(pod(testPod(true, 1, ""))))
+}
+
+func TestDetectorEvents(t *testing.T) {
+	since := time.Now()
+	d := NewDetector([]Condition{"event:Unhealthy", ConditionRestart, "event:BackOff"}, "", since)
+	require.True(t, d.WatchesEvents())
+	assert.Equal(t, []string{"BackOff", "Unhealthy"}, d.Reasons())
+
+	ev := func(uid types.UID, reason string, count int32, at time.Time) v1.Event {
+		return v1.Event{
+			ObjectMeta:     metav1.ObjectMeta{UID: uid},
+			InvolvedObject: v1.ObjectReference{Kind: "Pod", UID: "pod-uid"},
+			Reason:         reason,
+			Message:        "Readiness probe failed",
+			Count:          count,
+			LastTimestamp:  metav1.Time{Time: at},
+		}
+	}
+
+	// The events of pods not seen yet are passed over
+	assert.Empty(t, d.Events([]v1.Event{ev("ev-1", "Unhealthy", 1, since.Add(time.Second))}))
+
+	d.Pod(pod(testPod(true, 0, "")))
+	assert.Empty(t, d.Events([]v1.Event{
+		// Seen already
+		ev("ev-1", "Unhealthy", 1, since.Add(time.Second)),
+		// From before watching
+		ev("ev-2", "Unhealthy", 1, since.Add(-time.Second)),
+		ev("ev-3", "Pulled", 1, since.Add(time.Second)),
+	}))
+
+	firings := d.Events([]v1.Event{
+		ev("ev-1", "Unhealthy", 2, since.Add(2*time.Second)),
+		ev("ev-2", "Unhealthy", 1, since.Add(-time.Second)),
+	})
+	require.Len(t, firings, 1)
+	assert.Equal(t, Condition("event:Unhealthy"), firings[0].Condition)
+	assert.Equal(t, "api-1", firings[0].Pod.Name)
+	assert.Equal(t, "Readiness probe failed", firings[0].Message)
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Now()
+	l := &Limiter{Cooldown: 10 * time.Minute, MaxConcurrent: 1, TraceDuration: time.Minute}
+
+	ok, _ := l.Allow("a", now)
+	assert.True(t, ok)
+
+	// The trace being created counts as running already
+	ok, reason := l.Allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, "a trace of the pod is being created", reason)
+	ok, reason = l.Allow("b", now)
+	assert.False(t, ok)
+	assert.Equal(t, "1 traces are already running", reason)
+	l.Done("a", now, true)
+
+	ok, reason = l.Allow("b", now.Add(30*time.Second))
+	assert.False(t, ok)
+	assert.Equal(t, "1 traces are already running", reason)
+
+	ok, reason = l.Allow("a", now.Add(2*time.Minute))
+	assert.False(t, ok)
+	assert.Equal(t, "the pod was traced 2m0s ago, less than the cooldown of 10m0s", reason)
+
+	ok, _ = l.Allow("b", now.Add(2*time.Minute))
+	assert.True(t, ok)
+	l.Done("b", now.Add(2*time.Minute), true)
+	ok, _ = l.Allow("a", now.Add(11*time.Minute))
+	assert.True(t, ok)
+}
+
+func TestLimiterFailedCreation(t *testing.T) {
+	now := time.Now()
+	l := &Limiter{Cooldown: 10 * time.Minute, MaxConcurrent: 1, TraceDuration: time.Minute}
+
+	ok, _ := l.Allow("a", now)
+	require.True(t, ok)
+	l.Done("a", now, false)
+
+	// A trace that could not be created neither runs nor starts the cooldown
+	ok, _ = l.Allow("a", now.Add(time.Second))
+	assert.True(t, ok)
+}